@@ -0,0 +1,451 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	slashpath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// distConfig is the desired state of a distribution,
+// as described by a paragraph in a configuration file.
+type distConfig struct {
+	bucketURL string
+	prefix    string
+	dist      distribution
+	keyIDs    []string
+
+	// compressions is the set of index compression extensions to publish.
+	// nil means to keep whatever the Release file currently lists.
+	compressions []string
+	// retention is the maximum number of versions of a package to keep in
+	// each index. Zero means to keep all versions.
+	retention int
+	// validFor is the duration between the Date and Valid-Until fields.
+	// Zero means to omit the Valid-Until field.
+	validFor time.Duration
+
+	// release is the set of Release fields that the distribution should have.
+	release deb.Paragraph
+}
+
+// Configuration fields that control aptblob instead of being copied into the
+// Release file.
+const (
+	configBucketField       = "Bucket"
+	configPrefixField       = "Prefix"
+	configDistributionField = "Distribution"
+	configSignWithField     = "Sign-With"
+	configCompressionField  = "Compression"
+	configRetentionField    = "Retention"
+	configValidForField     = "Valid-For"
+)
+
+// managedReleaseFields is the set of Release fields that aptblob computes
+// and thus may not be present in a configuration file.
+var managedReleaseFields = []string{"Date", "Valid-Until", "MD5Sum", "SHA1", "SHA256"}
+
+// compressionNames maps configuration names of compression algorithms to
+// index file extensions.
+var compressionNames = map[string]string{
+	"none": "",
+	"gz":   gzipExtension,
+	"xz":   xzExtension,
+}
+
+// parseConfig parses a configuration file. A configuration file is a Debian
+// control file where each paragraph describes a distribution.
+func parseConfig(r io.Reader) ([]distConfig, error) {
+	p := deb.NewParser(r)
+	p.Fields = deb.ReleaseFields
+	var configs []distConfig
+	for p.Next() {
+		cfg, err := parseDistConfig(p.Paragraph())
+		if err != nil {
+			return nil, fmt.Errorf("parse config: distribution #%d: %w", len(configs)+1, withCode(codeParseError, err))
+		}
+		configs = append(configs, cfg)
+	}
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("parse config: %w", withCode(codeParseError, err))
+	}
+	return configs, nil
+}
+
+func parseDistConfig(para deb.Paragraph) (distConfig, error) {
+	var cfg distConfig
+	for _, f := range para {
+		switch f.Name {
+		case configBucketField:
+			cfg.bucketURL = f.Value
+		case configPrefixField:
+			cfg.prefix = f.Value
+		case configDistributionField:
+			cfg.dist = distribution(f.Value)
+		case configSignWithField:
+			cfg.keyIDs = strings.Fields(f.Value)
+		case configCompressionField:
+			cfg.compressions = []string{}
+			for _, name := range strings.Fields(f.Value) {
+				ext, ok := compressionNames[name]
+				if !ok {
+					return distConfig{}, fmt.Errorf("%s: unknown compression %q", f.Name, name)
+				}
+				if !containsString(cfg.compressions, ext) {
+					cfg.compressions = append(cfg.compressions, ext)
+				}
+			}
+			if len(cfg.compressions) == 0 {
+				return distConfig{}, fmt.Errorf("%s: empty", f.Name)
+			}
+		case configRetentionField:
+			n, err := strconv.Atoi(f.Value)
+			if err != nil || n < 1 {
+				return distConfig{}, fmt.Errorf("%s: must be a positive integer", f.Name)
+			}
+			cfg.retention = n
+		case configValidForField:
+			d, err := time.ParseDuration(f.Value)
+			if err != nil || d <= 0 {
+				return distConfig{}, fmt.Errorf("%s: must be a positive duration", f.Name)
+			}
+			cfg.validFor = d
+		default:
+			if containsString(managedReleaseFields, f.Name) {
+				return distConfig{}, fmt.Errorf("%s is managed by aptblob", f.Name)
+			}
+			cfg.release = append(cfg.release, f)
+		}
+	}
+	if cfg.bucketURL == "" {
+		return distConfig{}, fmt.Errorf("missing %s", configBucketField)
+	}
+	if cfg.dist == "" {
+		return distConfig{}, fmt.Errorf("missing %s", configDistributionField)
+	}
+	if strings.ContainsAny(string(cfg.dist), " \t/") {
+		return distConfig{}, fmt.Errorf("invalid %s %q", configDistributionField, cfg.dist)
+	}
+	return cfg, nil
+}
+
+// cmdApply reconciles a distribution in the bucket with its configuration.
+// It writes a diff of the Release file to out before making any changes.
+func cmdApply(ctx context.Context, bucket *blob.Bucket, out io.Writer, cfg distConfig, keyIDs []string) error {
+	if len(cfg.keyIDs) > 0 {
+		keyIDs = cfg.keyIDs
+	}
+	if err := checkSigningKeys(ctx, bucket, cfg.dist, keyIDs); err != nil {
+		return err
+	}
+
+	oldRelease, err := downloadReleaseIndex(ctx, bucket, cfg.dist)
+	if err != nil {
+		return fmt.Errorf("read old release: %w", err)
+	}
+	newRelease := append(deb.Paragraph(nil), cfg.release...)
+	for _, k := range []string{"Components", "Architectures"} {
+		// upload maintains these fields if they aren't configured.
+		if v := oldRelease.Get(k); v != "" && newRelease.Get(k) == "" {
+			newRelease.Set(k, v)
+		}
+	}
+	for _, k := range managedReleaseFields {
+		if k == "Valid-Until" && cfg.validFor == 0 {
+			continue
+		}
+		if v := oldRelease.Get(k); v != "" {
+			newRelease.Set(k, v)
+		}
+	}
+	now := time.Now()
+	if cfg.validFor > 0 && !releaseValidUntil(oldRelease, now, cfg.validFor) {
+		setReleaseDates(&newRelease, now, now, cfg.validFor)
+	}
+	pruned, err := pruneUnconfiguredIndexes(&newRelease)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cfg.dist.indexPath(), err)
+	}
+
+	// Plan index changes.
+	type pendingWrite struct {
+		key         string
+		data        []byte
+		contentType string
+	}
+	type pendingDiff struct {
+		key     string
+		newText string
+	}
+	var writes []pendingWrite
+	var diffs []pendingDiff
+	var deletes []string
+	for _, distPath := range pruned {
+		deletes = append(deletes, cfg.dist.dir()+"/"+distPath)
+	}
+	if cfg.compressions != nil || cfg.retention > 0 {
+		indexes, err := listedIndexes(newRelease)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cfg.dist.indexPath(), err)
+		}
+		for _, distPath := range indexes {
+			key := cfg.dist.dir() + "/" + distPath
+			fields := deb.ControlFields
+			if slashpath.Base(distPath) == "Sources" {
+				fields = deb.SourceControlFields
+			}
+			packages, err := downloadIndex(ctx, bucket, key, fields)
+			if err != nil {
+				return err
+			}
+			if cfg.retention > 0 {
+				packages = retainNewestVersions(packages, cfg.retention)
+			}
+			oldCompressions := listedIndexCompressions(newRelease, distPath)
+			compressions := cfg.compressions
+			if compressions == nil {
+				compressions = oldCompressions
+			}
+			sortIndex(key, packages)
+			variants, err := encodeIndex(packages, compressions)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if planFrom(ctx) != nil {
+				newText := new(strings.Builder)
+				if err := deb.Save(newText, packages); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				diffs = append(diffs, pendingDiff{key: key, newText: newText.String()})
+			}
+			hashes := make(map[string]indexHashes, len(variants))
+			for _, v := range variants {
+				h, err := hashContent(bytes.NewReader(v.data))
+				if err != nil {
+					return fmt.Errorf("%s: %w", key+v.ext, err)
+				}
+				hashes[v.ext] = h
+				writes = append(writes, pendingWrite{
+					key:         key + v.ext,
+					data:        v.data,
+					contentType: v.contentType,
+				})
+			}
+			for _, ext := range oldCompressions {
+				if _, ok := hashes[ext]; !ok {
+					deletes = append(deletes, key+ext)
+				}
+			}
+			if err := updateIndexSignatures(&newRelease, distPath, hashes); err != nil {
+				return fmt.Errorf("%s: %w", cfg.dist.indexPath(), err)
+			}
+		}
+	}
+
+	// Only the Date differs between otherwise identical Release files.
+	deb.SortFields(newRelease, deb.ReleaseFieldOrder)
+	if paragraphText(withoutField(oldRelease, "Date")) == paragraphText(withoutField(newRelease, "Date")) {
+		fmt.Fprintf(out, "%s: up to date\n", cfg.dist)
+		return nil
+	}
+	date := now
+	if len(writes) > 0 {
+		// Only rebuilt indexes are new content for SOURCE_DATE_EPOCH.
+		date = releaseNow()
+	}
+	setReleaseDates(&newRelease, date, now, cfg.validFor)
+	io.WriteString(out, unifiedDiff(
+		"a/"+cfg.dist.indexPath(),
+		"b/"+cfg.dist.indexPath(),
+		paragraphText(oldRelease),
+		paragraphText(newRelease),
+	))
+	plan := planFrom(ctx)
+	if plan != nil {
+		// The diff above covers the Release file.
+		for _, d := range diffs {
+			oldText, err := downloadIndexText(ctx, bucket, d.key)
+			if err != nil {
+				return err
+			}
+			plan.diff(d.key, oldText, d.newText)
+		}
+	}
+	for _, w := range writes {
+		_, err := upload(ctx, bucket, w.key, bytes.NewReader(w.data), uploadOptions{
+			contentType: w.contentType,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if plan == nil {
+		if err := uploadReleaseIndex(ctx, bucket, cfg.dist, newRelease, keyIDs); err != nil {
+			return err
+		}
+	}
+	for _, key := range deletes {
+		if err := deleteObject(ctx, bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseValidUntil reports whether a Release paragraph's Valid-Until field
+// is after now and validFor after its Date.
+func releaseValidUntil(release deb.Paragraph, now time.Time, validFor time.Duration) bool {
+	validUntil, err := parseReleaseDate(release.Get("Valid-Until"))
+	if err != nil {
+		return false
+	}
+	return validUntil.After(now) && releaseValidity(release) == validFor
+}
+
+// withoutField returns a copy of para without the named field.
+func withoutField(para deb.Paragraph, name string) deb.Paragraph {
+	var result deb.Paragraph
+	for _, f := range para {
+		if !strings.EqualFold(f.Name, name) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// paragraphText formats a paragraph as it would appear in a file,
+// or returns the empty string for a nil paragraph.
+func paragraphText(para deb.Paragraph) string {
+	if len(para) == 0 {
+		return ""
+	}
+	return para.String() + "\n"
+}
+
+// listedIndexes returns the sorted paths of the Packages and Sources indexes
+// listed in a Release paragraph, relative to the distribution directory and
+// without any compression extension.
+func listedIndexes(release deb.Paragraph) ([]string, error) {
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("SHA256: %w", err)
+	}
+	seen := make(map[string]struct{})
+	var indexes []string
+	for _, sig := range sigs {
+		distPath := trimIndexCompression(sig.Filename)
+		if base := slashpath.Base(distPath); base != "Packages" && base != "Sources" {
+			continue
+		}
+		if _, dup := seen[distPath]; dup {
+			continue
+		}
+		seen[distPath] = struct{}{}
+		indexes = append(indexes, distPath)
+	}
+	sort.Strings(indexes)
+	return indexes, nil
+}
+
+// trimIndexCompression removes any known compression extension from an index
+// path.
+func trimIndexCompression(distPath string) string {
+	for _, ext := range indexCompressions {
+		if ext != "" && strings.HasSuffix(distPath, ext) {
+			return strings.TrimSuffix(distPath, ext)
+		}
+	}
+	return distPath
+}
+
+// pruneUnconfiguredIndexes removes checksums from the Release paragraph for
+// indexes that belong to components or architectures not listed in the
+// paragraph's Components or Architectures fields. An absent field means that
+// any component or architecture is allowed. It returns the paths of the
+// removed files, relative to the distribution directory.
+func pruneUnconfiguredIndexes(release *deb.Paragraph) ([]string, error) {
+	components := strings.Fields(release.Get("Components"))
+	architectures := strings.Fields(release.Get("Architectures"))
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("SHA256: %w", err)
+	}
+	var stale []string
+	for _, sig := range sigs {
+		parts := strings.Split(sig.Filename, "/")
+		if len(components) > 0 && !containsString(components, parts[0]) {
+			stale = append(stale, sig.Filename)
+			continue
+		}
+		if len(parts) > 1 && len(architectures) > 0 && strings.HasPrefix(parts[1], "binary-") {
+			arch := strings.TrimPrefix(parts[1], "binary-")
+			if arch != "all" && !containsString(architectures, arch) {
+				stale = append(stale, sig.Filename)
+			}
+		}
+	}
+	for _, key := range []string{"MD5Sum", "SHA1", "SHA256"} {
+		if err := removeSignatures(release, key, stale...); err != nil {
+			return nil, err
+		}
+	}
+	return stale, nil
+}
+
+// retainNewestVersions removes all but the n newest versions of each package
+// in an index. The relative order of the remaining paragraphs is preserved.
+func retainNewestVersions(packages []deb.Paragraph, n int) []deb.Paragraph {
+	type packageArch struct {
+		name string
+		arch string
+	}
+	versions := make(map[packageArch][]string)
+	for _, pkg := range packages {
+		k := packageArch{pkg.Get("Package"), pkg.Get("Architecture")}
+		versions[k] = append(versions[k], pkg.Get("Version"))
+	}
+	for k, vs := range versions {
+		if len(vs) <= n {
+			delete(versions, k)
+			continue
+		}
+		sort.Slice(vs, func(i, j int) bool {
+			return deb.CompareVersions(vs[i], vs[j]) > 0
+		})
+		versions[k] = vs[:n]
+	}
+	kept := packages[:0]
+	for _, pkg := range packages {
+		k := packageArch{pkg.Get("Package"), pkg.Get("Architecture")}
+		if vs, pruned := versions[k]; pruned && !containsString(vs, pkg.Get("Version")) {
+			continue
+		}
+		kept = append(kept, pkg)
+	}
+	return kept
+}
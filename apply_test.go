@@ -0,0 +1,378 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []distConfig
+		wantErr bool
+	}{
+		{
+			name: "Minimal",
+			source: "Bucket: mem://\n" +
+				"Distribution: stable\n",
+			want: []distConfig{{
+				bucketURL: "mem://",
+				dist:      "stable",
+			}},
+		},
+		{
+			name: "Full",
+			source: "Bucket: gs://example\n" +
+				"Prefix: foo\n" +
+				"Distribution: stable\n" +
+				"Sign-With: 42CAFE\n" +
+				"Compression: none xz\n" +
+				"Retention: 3\n" +
+				"Valid-For: 168h\n" +
+				"Origin: Example\n" +
+				"Components: main\n" +
+				"\n" +
+				"Bucket: gs://example\n" +
+				"Distribution: unstable\n",
+			want: []distConfig{
+				{
+					bucketURL:    "gs://example",
+					prefix:       "foo",
+					dist:         "stable",
+					keyIDs:       []string{"42CAFE"},
+					compressions: []string{"", xzExtension},
+					retention:    3,
+					validFor:     7 * 24 * time.Hour,
+					release: deb.Paragraph{
+						{Name: "Origin", Value: "Example"},
+						{Name: "Components", Value: "main"},
+					},
+				},
+				{
+					bucketURL: "gs://example",
+					dist:      "unstable",
+				},
+			},
+		},
+		{
+			name:    "MissingBucket",
+			source:  "Distribution: stable\n",
+			wantErr: true,
+		},
+		{
+			name:    "MissingDistribution",
+			source:  "Bucket: mem://\n",
+			wantErr: true,
+		},
+		{
+			name:    "ManagedField",
+			source:  "Bucket: mem://\nDistribution: stable\nDate: today\n",
+			wantErr: true,
+		},
+		{
+			name:    "ValidUntil",
+			source:  "Bucket: mem://\nDistribution: stable\nValid-Until: tomorrow\n",
+			wantErr: true,
+		},
+		{
+			name:    "BadValidFor",
+			source:  "Bucket: mem://\nDistribution: stable\nValid-For: 1 week\n",
+			wantErr: true,
+		},
+		{
+			name:    "UnknownCompression",
+			source:  "Bucket: mem://\nDistribution: stable\nCompression: zip\n",
+			wantErr: true,
+		},
+		{
+			name:    "BadRetention",
+			source:  "Bucket: mem://\nDistribution: stable\nRetention: 0\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseConfig(strings.NewReader(test.source))
+			if err != nil {
+				t.Log("parseConfig:", err)
+				if !test.wantErr {
+					t.Fail()
+				}
+				return
+			}
+			if test.wantErr {
+				t.Fatalf("parseConfig(...) = %+v; want error", got)
+			}
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(distConfig{})); diff != "" {
+				t.Errorf("parseConfig(...) (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	err := cmdUpload(ctx, bucket, comp, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+
+	cfg := distConfig{
+		bucketURL:    "mem://",
+		dist:         "stable",
+		compressions: []string{xzExtension},
+		release: deb.Paragraph{
+			{Name: "Origin", Value: "Example"},
+			{Name: "Components", Value: "main"},
+		},
+	}
+	out := new(bytes.Buffer)
+	if err := cmdApply(ctx, bucket, out, cfg, nil); err != nil {
+		t.Fatal("apply:", err)
+	}
+	if !strings.Contains(out.String(), "\n+Origin: Example\n") {
+		t.Errorf("apply output does not show Origin being added. Output:\n%s", out)
+	}
+
+	release, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := release.Get("Origin"), "Example"; got != want {
+		t.Errorf("Origin = %q; want %q", got, want)
+	}
+	if got, want := release.Get("Architectures"), "amd64"; got != want {
+		t.Errorf("Architectures = %q; want %q", got, want)
+	}
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotFiles []string
+	for _, sig := range sigs {
+		gotFiles = append(gotFiles, sig.Filename)
+	}
+	wantFiles := []string{"main/binary-amd64/Packages.xz"}
+	if diff := cmp.Diff(wantFiles, gotFiles); diff != "" {
+		t.Errorf("SHA256 files (-want +got):\n%s", diff)
+	}
+	const packagesKey = "dists/stable/main/binary-amd64/Packages"
+	for _, key := range []string{packagesKey, packagesKey + gzipExtension} {
+		if exists, err := bucket.Exists(ctx, key); err != nil {
+			t.Error(err)
+		} else if exists {
+			t.Errorf("%s still exists", key)
+		}
+	}
+	packages, err := downloadIndex(ctx, bucket, packagesKey, deb.ControlFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].Get("Package") != "nullpkg" {
+		t.Errorf("%s = %v; want nullpkg", packagesKey+xzExtension, packages)
+	}
+
+	out.Reset()
+	if err := cmdApply(ctx, bucket, out, cfg, nil); err != nil {
+		t.Fatal("second apply:", err)
+	}
+	if got, want := out.String(), "stable: up to date\n"; got != want {
+		t.Errorf("second apply output = %q; want %q", got, want)
+	}
+}
+
+func TestApplyExpiredValidUntil(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	err := cmdUpload(ctx, bucket, comp, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	const validFor = 7 * 24 * time.Hour
+	release, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-2 * validFor)
+	setReleaseDates(&release, past, past, validFor)
+	if err := uploadReleaseIndex(ctx, bucket, "stable", release, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := distConfig{
+		bucketURL: "mem://",
+		dist:      "stable",
+		validFor:  validFor,
+		release: deb.Paragraph{
+			{Name: "Components", Value: "main"},
+		},
+	}
+	out := new(bytes.Buffer)
+	if err := cmdApply(ctx, bucket, out, cfg, nil); err != nil {
+		t.Fatal("apply:", err)
+	}
+	if strings.Contains(out.String(), "up to date") {
+		t.Errorf("apply output = %q; want a new Valid-Until", out)
+	}
+	release, err = downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validUntil, err := parseReleaseDate(release.Get("Valid-Until"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validUntil.After(time.Now()) {
+		t.Errorf("Valid-Until = %q; want a time in the future", release.Get("Valid-Until"))
+	}
+
+	out.Reset()
+	if err := cmdApply(ctx, bucket, out, cfg, nil); err != nil {
+		t.Fatal("second apply:", err)
+	}
+	if got, want := out.String(), "stable: up to date\n"; got != want {
+		t.Errorf("second apply output = %q; want %q", got, want)
+	}
+}
+
+func TestApplyPrunesIndexes(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	for _, name := range []string{"main", "contrib"} {
+		comp := component{dist: "stable", name: name}
+		err := cmdUpload(ctx, bucket, comp, []string{
+			filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+		}, packageUploadOptions{})
+		if err != nil {
+			t.Fatalf("upload to %s: %v", name, err)
+		}
+	}
+
+	cfg := distConfig{
+		bucketURL: "mem://",
+		dist:      "stable",
+		release: deb.Paragraph{
+			{Name: "Components", Value: "main"},
+		},
+	}
+	if err := cmdApply(ctx, bucket, new(bytes.Buffer), cfg, nil); err != nil {
+		t.Fatal("apply:", err)
+	}
+	const packagesKey = "dists/stable/contrib/binary-amd64/Packages"
+	for _, key := range []string{packagesKey, packagesKey + gzipExtension} {
+		if exists, err := bucket.Exists(ctx, key); err != nil {
+			t.Error(err)
+		} else if exists {
+			t.Errorf("%s still exists", key)
+		}
+	}
+	if exists, err := bucket.Exists(ctx, "dists/stable/main/binary-amd64/Packages"); err != nil {
+		t.Error(err)
+	} else if !exists {
+		t.Error("main index was deleted")
+	}
+}
+
+func TestRetainNewestVersions(t *testing.T) {
+	packages := []deb.Paragraph{
+		{{Name: "Package", Value: "foo"}, {Name: "Version", Value: "1.10"}},
+		{{Name: "Package", Value: "bar"}, {Name: "Version", Value: "1.0"}},
+		{{Name: "Package", Value: "foo"}, {Name: "Version", Value: "1.9"}},
+		{{Name: "Package", Value: "foo"}, {Name: "Version", Value: "1:0.1"}},
+	}
+	want := []deb.Paragraph{
+		{{Name: "Package", Value: "foo"}, {Name: "Version", Value: "1.10"}},
+		{{Name: "Package", Value: "bar"}, {Name: "Version", Value: "1.0"}},
+		{{Name: "Package", Value: "foo"}, {Name: "Version", Value: "1:0.1"}},
+	}
+	got := retainNewestVersions(packages, 2)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("retainNewestVersions(..., 2) (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	const distPath = "main/binary-amd64/Packages"
+	var packages []deb.Paragraph
+	for _, version := range []string{"1.0-1", "0.9-1"} {
+		packages = append(packages, deb.Paragraph{
+			{Name: "Package", Value: "nullpkg"},
+			{Name: "Version", Value: version},
+			{Name: "Architecture", Value: "amd64"},
+			{Name: "Filename", Value: poolPath("nullpkg_" + version + "_amd64.deb")},
+		})
+	}
+	hashes, err := uploadIndex(ctx, bucket, "dists/stable/"+distPath, packages, []string{"", gzipExtension})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := deb.Paragraph{{Name: "Components", Value: "main"}}
+	if err := updateIndexSignatures(&release, distPath, hashes); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadReleaseIndex(ctx, bucket, "stable", release, nil); err != nil {
+		t.Fatal(err)
+	}
+	before, err := readAllObjects(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := distConfig{
+		bucketURL:    "mem://",
+		dist:         "stable",
+		compressions: []string{xzExtension},
+		retention:    1,
+		release:      deb.Paragraph{{Name: "Components", Value: "main"}},
+	}
+	out := new(bytes.Buffer)
+	if err := cmdApply(withDryRun(ctx, out), bucket, out, cfg, nil); err != nil {
+		t.Fatal("apply:", err)
+	}
+	for _, want := range []string{
+		"+++ b/dists/stable/Release\n",
+		"+++ b/dists/stable/" + distPath + "\n",
+		"-Version: 0.9-1\n",
+		"would delete dists/stable/" + distPath + gzipExtension + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	checkUnchanged(ctx, t, bucket, before)
+}
@@ -18,12 +18,21 @@ package main
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -35,19 +44,15 @@ import (
 	"zombiezen.com/go/aptblob/internal/deb"
 )
 
-func cmdInit(ctx context.Context, bucket *blob.Bucket, stdin io.Reader, stderr io.Writer, dist distribution, keyID string) error {
-	if keyID == "" {
-		if signed, err := isDistributionSigned(ctx, bucket, dist); err != nil {
-			return err
-		} else if signed {
-			return errors.New("distribution is signed but key ID not provided")
-		}
+func cmdInit(ctx context.Context, bucket *blob.Bucket, stdin io.Reader, stderr io.Writer, dist distribution, keyIDs []string, validFor time.Duration) error {
+	if err := checkSigningKeys(ctx, bucket, dist, keyIDs); err != nil {
+		return err
 	}
 
 	fmt.Fprintln(stderr, "aptblob: reading Release from stdin...")
 	newRelease, err := deb.ParseReleaseIndex(stdin)
 	if err != nil {
-		return fmt.Errorf("read stdin: %w", err)
+		return fmt.Errorf("read stdin: %w", withCode(codeParseError, err))
 	}
 	oldRelease, err := downloadReleaseIndex(ctx, bucket, dist)
 	if err != nil {
@@ -59,8 +64,20 @@ func cmdInit(ctx context.Context, bucket *blob.Bucket, stdin io.Reader, stderr i
 			newRelease.Set(k, v)
 		}
 	}
-	newRelease.Set("Date", time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 Z"))
-	err = uploadReleaseIndex(ctx, bucket, dist, newRelease, keyID)
+	if validFor == 0 {
+		// Rebase a Valid-Until from stdin on the new Date rather than
+		// keeping it next to a Date it wasn't computed from.
+		validFor = releaseValidity(newRelease)
+	}
+	if validFor == 0 {
+		validFor = releaseValidity(oldRelease)
+	}
+	if validFor == 0 {
+		newRelease = withoutField(newRelease, "Valid-Until")
+	}
+	now := time.Now()
+	setReleaseDates(&newRelease, now, now, validFor)
+	err = uploadReleaseIndex(ctx, bucket, dist, newRelease, keyIDs)
 	if err != nil {
 		return err
 	}
@@ -68,7 +85,12 @@ func cmdInit(ctx context.Context, bucket *blob.Bucket, stdin io.Reader, stderr i
 }
 
 func downloadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distribution) (deb.Paragraph, error) {
-	key := dist.indexPath()
+	return downloadReleaseIndexAt(ctx, bucket, dist.indexPath())
+}
+
+// downloadReleaseIndexAt reads the Release file at the given key,
+// returning nil if it does not exist.
+func downloadReleaseIndexAt(ctx context.Context, bucket *blob.Bucket, key string) (deb.Paragraph, error) {
 	blob, err := bucket.NewReader(ctx, key, nil)
 	if err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
@@ -79,18 +101,26 @@ func downloadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distrib
 	index, err := deb.ParseReleaseIndex(blob)
 	blob.Close()
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", key, err)
+		return nil, fmt.Errorf("%s: %w", key, withCode(codeParseError, err))
 	}
 	return index, nil
 }
 
-func cmdUpload(ctx context.Context, bucket *blob.Bucket, comp component, keyID string, paths []string) error {
-	if keyID == "" {
-		if signed, err := isDistributionSigned(ctx, bucket, comp.dist); err != nil {
-			return err
-		} else if signed {
-			return errors.New("distribution is signed but key ID not provided")
-		}
+// packageUploadOptions is the set of options to cmdUpload.
+type packageUploadOptions struct {
+	// keyIDs are the keys to sign the Release file with.
+	keyIDs []string
+	// validFor is the lifetime of the signed Release file. If zero, then the
+	// distance between the current Date and Valid-Until fields is kept.
+	validFor time.Duration
+	// sourceKeyringPath is the path to an OpenPGP keyring. If not empty,
+	// source packages must be clear-signed by a key in the keyring.
+	sourceKeyringPath string
+}
+
+func cmdUpload(ctx context.Context, bucket *blob.Bucket, comp component, paths []string, opts packageUploadOptions) error {
+	if err := checkSigningKeys(ctx, bucket, comp.dist, opts.keyIDs); err != nil {
+		return err
 	}
 
 	release, err := downloadReleaseIndex(ctx, bucket, comp.dist)
@@ -118,7 +148,7 @@ func cmdUpload(ctx context.Context, bucket *blob.Bucket, comp component, keyID s
 			addToTokenSet(&release, "Architectures", arch)
 			binaryAdditions[arch] = append(binaryAdditions[arch], pkg)
 		case ".dsc":
-			pkg, err := uploadSourcePackage(ctx, bucket, path)
+			pkg, err := uploadSourcePackage(ctx, bucket, path, opts.sourceKeyringPath)
 			if err != nil {
 				return err
 			}
@@ -153,14 +183,114 @@ func cmdUpload(ctx context.Context, bucket *blob.Bucket, comp component, keyID s
 		return err
 	}
 
-	release.Set("Date", time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 Z"))
-	if err := uploadReleaseIndex(ctx, bucket, comp.dist, release, keyID); err != nil {
+	validFor := opts.validFor
+	if validFor == 0 {
+		validFor = releaseValidity(release)
+	}
+	setReleaseDates(&release, releaseNow(), time.Now(), validFor)
+	if err := uploadReleaseIndex(ctx, bucket, comp.dist, release, opts.keyIDs); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// cmdRefresh updates the Date and Valid-Until fields of a distribution's
+// Release file and signs it again. If validFor is zero, then the distance
+// between the existing Date and Valid-Until fields is kept.
+func cmdRefresh(ctx context.Context, bucket *blob.Bucket, dist distribution, keyIDs []string, validFor time.Duration) error {
+	if err := checkSigningKeys(ctx, bucket, dist, keyIDs); err != nil {
+		return err
+	}
+
+	release, err := downloadReleaseIndex(ctx, bucket, dist)
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		return withCode(codeNotFound, fmt.Errorf("%s not found", dist.indexPath()))
+	}
+	if validFor == 0 {
+		validFor = releaseValidity(release)
+	}
+	// Refreshing doesn't change the indexes, so SOURCE_DATE_EPOCH doesn't apply.
+	now := time.Now()
+	setReleaseDates(&release, now, now, validFor)
+	return uploadReleaseIndex(ctx, bucket, dist, release, keyIDs)
+}
+
+// releaseDateFormat is the format of the Date and Valid-Until Release fields.
+const releaseDateFormat = "Mon, 02 Jan 2006 15:04:05 Z"
+
+// sourceDateEpochEnv is the environment variable that overrides the Date of
+// Release files that list new index content.
+// See https://reproducible-builds.org/specs/source-date-epoch/
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// sourceDateEpoch returns the time given by the SOURCE_DATE_EPOCH environment
+// variable. ok is false if the variable is not set.
+func sourceDateEpoch() (t time.Time, ok bool, err error) {
+	s := os.Getenv(sourceDateEpochEnv)
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sec < 0 {
+		return time.Time{}, false, fmt.Errorf("%s=%q is not a non-negative integer", sourceDateEpochEnv, s)
+	}
+	return time.Unix(sec, 0), true, nil
+}
+
+// releaseNow returns the time to use as the Date of a Release file that lists
+// new index content: the time given by SOURCE_DATE_EPOCH if it is set, or the
+// current time. Commands that only re-sign or copy existing indexes use the
+// current time.
+func releaseNow() time.Time {
+	if t, ok, err := sourceDateEpoch(); ok && err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// setReleaseDates sets the Date field of a Release paragraph to date. If
+// validFor is positive, then the Valid-Until field is set to now + validFor.
+// now is the current time, which differs from date if SOURCE_DATE_EPOCH is
+// set: a Valid-Until computed from a fixed date would already have passed.
+func setReleaseDates(release *deb.Paragraph, date, now time.Time, validFor time.Duration) {
+	release.Set("Date", date.UTC().Format(releaseDateFormat))
+	if validFor > 0 {
+		release.Set("Valid-Until", now.UTC().Add(validFor).Format(releaseDateFormat))
+	}
+}
+
+// releaseValidity returns the duration between the Date and Valid-Until
+// fields of a Release paragraph or zero if either is missing or malformed.
+func releaseValidity(release deb.Paragraph) time.Duration {
+	date, err := parseReleaseDate(release.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	validUntil, err := parseReleaseDate(release.Get("Valid-Until"))
+	if err != nil {
+		return 0
+	}
+	if !validUntil.After(date) {
+		return 0
+	}
+	return validUntil.Sub(date)
+}
+
+// parseReleaseDate parses a date in a Release file. Other tools use any of
+// the RFC 2822 time zone forms, so all of them are accepted.
+func parseReleaseDate(s string) (time.Time, error) {
+	for _, layout := range []string{releaseDateFormat, time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parse date %q: unknown format", s)
+}
+
 func appendToIndex(ctx context.Context, bucket *blob.Bucket, dist distribution, release *deb.Paragraph, key string, fields map[string]deb.FieldType, newParagraphs []deb.Paragraph) error {
 	if len(newParagraphs) == 0 {
 		return nil
@@ -177,79 +307,118 @@ func appendToIndex(ctx context.Context, bucket *blob.Bucket, dist distribution,
 	if err != nil {
 		return err
 	}
-	indexHashes, gzipIndexHashes, err := uploadIndex(ctx, bucket, key, packages)
+	distPath := strings.TrimPrefix(key, dist.dir()+"/")
+	hashes, err := uploadIndex(ctx, bucket, key, packages, listedIndexCompressions(*release, distPath))
 	if err != nil {
 		return err
 	}
 
 	// Update release signatures.
-	distPath := strings.TrimPrefix(key, dist.dir()+"/")
-	gzipDistPath := distPath + gzipExtension
-	err = updateSignature(release, "MD5Sum",
-		deb.IndexSignature{
-			Filename: distPath,
-			Checksum: indexHashes.md5[:],
-			Size:     indexHashes.size,
-		},
-		deb.IndexSignature{
-			Filename: gzipDistPath,
-			Checksum: gzipIndexHashes.md5[:],
-			Size:     gzipIndexHashes.size,
-		},
-	)
-	if err != nil {
+	if err := updateIndexSignatures(release, distPath, hashes); err != nil {
 		return fmt.Errorf("%s: %w", dist.indexPath(), err)
 	}
-	err = updateSignature(release, "SHA1",
-		deb.IndexSignature{
-			Filename: distPath,
-			Checksum: indexHashes.sha1[:],
-			Size:     indexHashes.size,
-		},
-		deb.IndexSignature{
-			Filename: gzipDistPath,
-			Checksum: gzipIndexHashes.sha1[:],
-			Size:     gzipIndexHashes.size,
-		},
-	)
+	return nil
+}
+
+// listedIndexCompressions returns the compression extensions of the index at
+// distPath that are listed in the Release paragraph, or the default set if the
+// index is not listed.
+func listedIndexCompressions(release deb.Paragraph, distPath string) []string {
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
 	if err != nil {
-		return fmt.Errorf("%s: %w", dist.indexPath(), err)
+		return defaultIndexCompressions
 	}
-	err = updateSignature(release, "SHA256",
-		deb.IndexSignature{
-			Filename: distPath,
-			Checksum: indexHashes.sha256[:],
-			Size:     indexHashes.size,
-		},
-		deb.IndexSignature{
-			Filename: gzipDistPath,
-			Checksum: gzipIndexHashes.sha256[:],
-			Size:     gzipIndexHashes.size,
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("%s: %w", dist.indexPath(), err)
+	var compressions []string
+	for _, ext := range indexCompressions {
+		for _, sig := range sigs {
+			if sig.Filename == distPath+ext {
+				compressions = append(compressions, ext)
+				break
+			}
+		}
+	}
+	if len(compressions) == 0 {
+		return defaultIndexCompressions
+	}
+	return compressions
+}
+
+// updateIndexSignatures sets the checksums in the Release paragraph for each
+// of the given variants of the index at distPath. Any other variants of the
+// index are removed from the Release paragraph.
+func updateIndexSignatures(release *deb.Paragraph, distPath string, hashes map[string]indexHashes) error {
+	var stale []string
+	for _, ext := range indexCompressions {
+		if _, ok := hashes[ext]; !ok {
+			stale = append(stale, distPath+ext)
+		}
+	}
+	hashFields := []struct {
+		key      string
+		checksum func(h *indexHashes) []byte
+	}{
+		{"MD5Sum", func(h *indexHashes) []byte { return h.md5[:] }},
+		{"SHA1", func(h *indexHashes) []byte { return h.sha1[:] }},
+		{"SHA256", func(h *indexHashes) []byte { return h.sha256[:] }},
+	}
+	for _, hf := range hashFields {
+		var sigs []deb.IndexSignature
+		for _, ext := range indexCompressions {
+			h, ok := hashes[ext]
+			if !ok {
+				continue
+			}
+			sigs = append(sigs, deb.IndexSignature{
+				Filename: distPath + ext,
+				Checksum: hf.checksum(&h),
+				Size:     h.size,
+			})
+		}
+		if err := removeSignatures(release, hf.key, stale...); err != nil {
+			return err
+		}
+		if err := updateSignature(release, hf.key, sigs...); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// downloadIndex reads the paragraphs of the index at key, reading whichever
+// variant of the index is present in the bucket.
 func downloadIndex(ctx context.Context, bucket *blob.Bucket, key string, fields map[string]deb.FieldType) ([]deb.Paragraph, error) {
-	r, err := bucket.NewReader(ctx, key, nil)
-	if err != nil {
+	for _, ext := range indexCompressions {
+		r, err := bucket.NewReader(ctx, key+ext, nil)
 		if gcerrors.Code(err) == gcerrors.NotFound {
-			return nil, nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key+ext, err)
+		}
+		paragraphs, err := readIndex(r, ext, fields)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key+ext, err)
 		}
-		return nil, fmt.Errorf("%s: %w", key, err)
+		return paragraphs, nil
 	}
-	defer r.Close()
-	p := deb.NewParser(r)
+	return nil, nil
+}
+
+func readIndex(r io.Reader, ext string, fields map[string]deb.FieldType) ([]deb.Paragraph, error) {
+	dr, err := decompressIndex(r, ext)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	p := deb.NewParser(dr)
 	p.Fields = fields
 	var paragraphs []deb.Paragraph
 	for p.Next() {
 		paragraphs = append(paragraphs, append(deb.Paragraph(nil), p.Paragraph()...))
 	}
 	if err := p.Err(); err != nil {
-		return nil, fmt.Errorf("%s: %w", key, err)
+		return nil, withCode(codeParseError, err)
 	}
 	return paragraphs, nil
 }
@@ -309,13 +478,60 @@ func updateSignature(para *deb.Paragraph, key string, newSigs ...deb.IndexSignat
 		sigs = append(sigs, sig)
 		delete(newMap, sig.Filename)
 	}
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].Filename < sigs[j].Filename
+	})
+	setSignatures(para, key, sigs)
+	return nil
+}
+
+// removeSignatures removes the signatures for the given filenames from a
+// Release checksum field.
+func removeSignatures(para *deb.Paragraph, key string, filenames ...string) error {
+	value := para.Get(key)
+	if value == "" || len(filenames) == 0 {
+		return nil
+	}
+	sigs, err := deb.ParseIndexSignatures(value, checksumSize(key))
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	n := 0
+	for _, sig := range sigs {
+		if !containsString(filenames, sig.Filename) {
+			sigs[n] = sig
+			n++
+		}
+	}
+	if n == len(sigs) {
+		return nil
+	}
+	setSignatures(para, key, sigs[:n])
+	return nil
+}
+
+// checksumSize returns the size in bytes of the checksums in the named
+// Release checksum field.
+func checksumSize(key string) int {
+	switch key {
+	case "MD5Sum":
+		return md5.Size
+	case "SHA1":
+		return sha1.Size
+	case "SHA256":
+		return sha256.Size
+	default:
+		panic("unknown checksum field " + key)
+	}
+}
+
+func setSignatures(para *deb.Paragraph, key string, sigs []deb.IndexSignature) {
 	sb := new(strings.Builder)
 	for _, sig := range sigs {
 		sb.WriteString("\n ")
 		sb.WriteString(sig.String())
 	}
 	para.Set(key, sb.String())
-	return nil
 }
 
 func isDistributionSigned(ctx context.Context, bucket *blob.Bucket, dist distribution) (bool, error) {
@@ -334,6 +550,23 @@ func isDistributionSigned(ctx context.Context, bucket *blob.Bucket, dist distrib
 }
 
 func main() {
+	rep := new(report)
+	var output string
+	// setup checks the global flags and environment and prepares the report.
+	setup := func(cmd *cobra.Command) error {
+		rep.command = strings.TrimPrefix(cmd.CommandPath(), "aptblob ")
+		if _, _, err := sourceDateEpoch(); err != nil {
+			return err
+		}
+		switch output {
+		case "text":
+		case "json":
+			rep.enabled = true
+		default:
+			return fmt.Errorf("unknown --output %q (must be text or json)", output)
+		}
+		return nil
+	}
 	rootCmd := &cobra.Command{
 		Use:           "aptblob",
 		Short:         "Manager for blob-storage-based APT repositories",
@@ -343,53 +576,502 @@ func main() {
 			if len(args) == 0 {
 				return fmt.Errorf("must have at least one argument for bucket")
 			}
-			var err error
-			return err
+			return setup(cmd)
 		},
 	}
-	keyID := rootCmd.PersistentFlags().StringP("keyid", "k", "", "GPG key to sign with")
-	rootCmd.AddCommand(&cobra.Command{
+	keyIDs := rootCmd.PersistentFlags().StringArrayP("keyid", "k", nil, "GPG key to sign with (may be repeated)")
+	prefix := rootCmd.PersistentFlags().String("prefix", "", "key prefix of the repository root within the bucket")
+	rootCmd.PersistentFlags().StringVar(&output, "output", "text", "output format: text or json")
+	// textOutput returns w, or a writer that discards its input if JSON
+	// output was requested, so that stdout is only the JSON report.
+	textOutput := func(w io.Writer) io.Writer {
+		if rep.enabled {
+			return ioutil.Discard
+		}
+		return w
+	}
+	// dryRunContext returns the command's context, set up to print a plan
+	// instead of changing the bucket if dryRun is true.
+	dryRunContext := func(cmd *cobra.Command, dryRun bool) context.Context {
+		if !dryRun {
+			return cmd.Context()
+		}
+		rep.dryRun = true
+		return withDryRun(cmd.Context(), textOutput(os.Stdout))
+	}
+	initCmd := &cobra.Command{
 		Use:                   "init [options] BUCKET DIST",
 		Short:                 "Set up a distribution",
 		Args:                  cobra.ExactArgs(2),
 		DisableFlagsInUseLine: true,
 		SilenceErrors:         true,
 		SilenceUsage:          true,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			bucket, err := blob.OpenBucket(cmd.Context(), args[0])
-			if err != nil {
-				return err
-			}
-			return cmdInit(cmd.Context(), bucket, os.Stdin, os.Stderr, distribution(args[1]), *keyID)
-		},
-	})
+	}
+	initValidFor := initCmd.Flags().Duration("valid-for", 0, "set Valid-Until to this long after Date (default is to keep the current interval)")
+	initDryRun := initCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	initCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdInit(dryRunContext(cmd, *initDryRun), bucket, os.Stdin, textOutput(os.Stderr), distribution(args[1]), *keyIDs, *initValidFor)
+	}
+	rootCmd.AddCommand(initCmd)
+	// addPackFlags adds the flags that describe a package to build to cmd.
+	addPackFlags := func(cmd *cobra.Command, opts *packOptions) *string {
+		cmd.Flags().StringVar(&opts.controlPath, "control", "", "control file of the package to build: YAML if named *.yaml or *.yml, deb822 otherwise")
+		cmd.Flags().StringVar(&opts.root, "root", "", "directory of files for the package to install")
+		cmd.Flags().StringVar(&opts.scriptsDir, "scripts", "", "directory of maintainer scripts and other control files like conffiles")
+		return cmd.Flags().String("compression", "xz", "compression of the package's archives: none, gzip, xz, or zstd")
+	}
+	// parsePackFlags checks the flags added by addPackFlags.
+	parsePackFlags := func(opts *packOptions, compression string) error {
+		if opts.controlPath == "" || opts.root == "" {
+			return errors.New("--control and --root are required to build a package")
+		}
+		var ok bool
+		opts.compression, ok = debCompressionNames[compression]
+		if !ok {
+			return fmt.Errorf("unknown --compression %q (must be none, gzip, xz, or zstd)", compression)
+		}
+		return nil
+	}
 	uploadCmd := &cobra.Command{
-		Use:                   "upload [options] BUCKET DIST PACKAGE [...]",
+		Use:                   "upload [options] BUCKET DIST [PACKAGE [...]]",
 		Short:                 "Upload one or more packages",
-		Args:                  cobra.MinimumNArgs(3),
+		Args:                  cobra.MinimumNArgs(2),
 		DisableFlagsInUseLine: true,
 		SilenceErrors:         true,
 		SilenceUsage:          true,
 	}
 	uploadComponentName := uploadCmd.Flags().StringP("component", "c", "main", "component name")
+	uploadValidFor := uploadCmd.Flags().Duration("valid-for", 0, "set Valid-Until to this long after Date (default is to keep the current interval)")
+	uploadDryRun := uploadCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	uploadRequireSignedSources := uploadCmd.Flags().Bool("require-signed-sources", false, "reject source packages that aren't signed by a key in --keyring")
+	uploadKeyring := uploadCmd.Flags().String("keyring", "", "keyring to verify source packages with")
+	uploadPack := uploadCmd.Flags().Bool("pack", false, "build a package from --control and --root and upload it too")
+	var uploadPackOpts packOptions
+	uploadCompression := addPackFlags(uploadCmd, &uploadPackOpts)
 	uploadCmd.RunE = func(cmd *cobra.Command, args []string) error {
-		bucket, err := blob.OpenBucket(cmd.Context(), args[0])
+		paths := args[2:]
+		if *uploadPack {
+			if err := parsePackFlags(&uploadPackOpts, *uploadCompression); err != nil {
+				return err
+			}
+			dir, err := ioutil.TempDir("", "aptblob_pack")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			debPath, err := packTemp(dir, uploadPackOpts)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, debPath)
+		} else if len(paths) == 0 {
+			return errors.New("no packages given (use --pack to build one)")
+		}
+		opts := packageUploadOptions{
+			keyIDs:   *keyIDs,
+			validFor: *uploadValidFor,
+		}
+		switch {
+		case *uploadRequireSignedSources && *uploadKeyring == "":
+			return errors.New("--require-signed-sources requires --keyring")
+		case !*uploadRequireSignedSources && *uploadKeyring != "":
+			return errors.New("--keyring requires --require-signed-sources")
+		case *uploadRequireSignedSources:
+			opts.sourceKeyringPath = *uploadKeyring
+		}
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
 		if err != nil {
 			return err
 		}
+		defer bucket.Close()
 		comp := component{
 			dist: distribution(args[1]),
 			name: *uploadComponentName,
 		}
-		return cmdUpload(cmd.Context(), bucket, comp, *keyID, args[2:])
+		return cmdUpload(dryRunContext(cmd, *uploadDryRun), bucket, comp, paths, opts)
 	}
 	rootCmd.AddCommand(uploadCmd)
-	if err := rootCmd.Execute(); err != nil {
+	packCmd := &cobra.Command{
+		Use:                   "pack [options]",
+		Short:                 "Build a binary package from a directory",
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		// pack builds a local file, so unlike the other commands, it doesn't
+		// take a bucket argument.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return setup(cmd)
+		},
+	}
+	var packOpts packOptions
+	packCompression := addPackFlags(packCmd, &packOpts)
+	packOut := packCmd.Flags().String("out", "", "path to write the package to (default PACKAGE_VERSION_ARCH.deb)")
+	packCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if err := parsePackFlags(&packOpts, *packCompression); err != nil {
+			return err
+		}
+		return cmdPack(cmd.Context(), textOutput(os.Stdout), *packOut, packOpts)
+	}
+	rootCmd.AddCommand(packCmd)
+	mirrorCmd := &cobra.Command{
+		Use:                   "mirror [options] BUCKET DIST",
+		Short:                 "Copy packages from another APT repository",
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	var mirrorOpts mirrorOptions
+	mirrorCmd.Flags().StringVar(&mirrorOpts.baseURL, "from", "", "URL of the upstream repository")
+	mirrorCmd.Flags().StringVar(&mirrorOpts.suite, "suite", "", "upstream distribution (default DIST)")
+	mirrorCmd.Flags().StringVarP(&mirrorOpts.component, "component", "c", "main", "component name, both upstream and in BUCKET")
+	mirrorCmd.Flags().StringArrayVar(&mirrorOpts.archs, "arch", nil, "architecture to mirror (may be repeated)")
+	mirrorCmd.Flags().StringArrayVar(&mirrorOpts.filters, "filter", nil, "only mirror packages whose names match this shell pattern (may be repeated)")
+	mirrorCmd.Flags().StringVar(&mirrorOpts.keyringPath, "keyring", "", "keyring to verify the upstream InRelease file with")
+	mirrorValidFor := mirrorCmd.Flags().Duration("valid-for", 0, "set Valid-Until to this long after Date (default is to keep the current interval)")
+	mirrorDryRun := mirrorCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	mirrorCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if mirrorOpts.baseURL == "" {
+			return errors.New("--from not provided")
+		}
+		if mirrorOpts.suite == "" {
+			mirrorOpts.suite = args[1]
+		}
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		comp := component{
+			dist: distribution(args[1]),
+			name: mirrorOpts.component,
+		}
+		return cmdMirror(dryRunContext(cmd, *mirrorDryRun), bucket, comp, *keyIDs, *mirrorValidFor, mirrorOpts)
+	}
+	rootCmd.AddCommand(mirrorCmd)
+	importCmd := &cobra.Command{
+		Use:                   "import [options] BUCKET DIR [DIST [...]]",
+		Short:                 "Copy a repository from a local directory",
+		Args:                  cobra.MinimumNArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	importValidFor := importCmd.Flags().Duration("valid-for", 0, "set Valid-Until to this long after Date (default is to keep the imported interval)")
+	importDryRun := importCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	importCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		var dists []distribution
+		for _, arg := range args[2:] {
+			dists = append(dists, distribution(arg))
+		}
+		return cmdImport(dryRunContext(cmd, *importDryRun), bucket, args[1], dists, *keyIDs, *importValidFor)
+	}
+	rootCmd.AddCommand(importCmd)
+	exportCmd := &cobra.Command{
+		Use:                   "export [options] BUCKET DIST",
+		Short:                 "Copy a distribution to a local directory or tar file",
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	var exportOpts exportOptions
+	exportCmd.Flags().StringVar(&exportOpts.dest, "to", "", "directory or .tar file to write to")
+	exportCmd.Flags().StringArrayVarP(&exportOpts.components, "component", "c", nil, "only export this component (may be repeated)")
+	exportCmd.Flags().StringArrayVar(&exportOpts.archs, "arch", nil, "only export this architecture or \"source\" (may be repeated)")
+	exportCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if exportOpts.dest == "" {
+			return errors.New("--to not provided")
+		}
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdExport(cmd.Context(), bucket, distribution(args[1]), exportOpts)
+	}
+	rootCmd.AddCommand(exportCmd)
+	syncCmd := &cobra.Command{
+		Use:                   "sync [options] SRCBUCKET DSTBUCKET [DIST [...]]",
+		Short:                 "Copy distributions from one bucket to another",
+		Args:                  cobra.MinimumNArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	syncDryRun := syncCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	syncCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		src, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := openBucket(cmd.Context(), args[1], *prefix)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+		var dists []distribution
+		for _, arg := range args[2:] {
+			dists = append(dists, distribution(arg))
+		}
+		return cmdSync(dryRunContext(cmd, *syncDryRun), dst, src, dists)
+	}
+	rootCmd.AddCommand(syncCmd)
+	serveCmd := &cobra.Command{
+		Use:                   "serve [options] BUCKET",
+		Short:                 "Serve a bucket over HTTP",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	serveAddr := serveCmd.Flags().String("addr", "localhost:8080", "address to listen on")
+	serveAuth := serveCmd.Flags().String("auth", "", "access configuration file (default is to serve publicly)")
+	var serveOpts serveOptions
+	serveCmd.Flags().DurationVar(&serveOpts.signedURLExpiry, "signed-url-expiry", 0, "redirect pool requests to signed URLs that expire after this long")
+	serveCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if *serveAuth != "" {
+			f, err := os.Open(*serveAuth)
+			if err != nil {
+				return err
+			}
+			serveOpts.policy, err = parseAccessPolicy(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", *serveAuth, err)
+			}
+		}
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		go func() {
+			select {
+			case <-sig:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		bucket, err := openBucket(ctx, args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdServe(ctx, bucket, *serveAddr, log.New(os.Stderr, "aptblob: ", log.LstdFlags), serveOpts)
+	}
+	rootCmd.AddCommand(serveCmd)
+	refreshCmd := &cobra.Command{
+		Use:                   "refresh [options] BUCKET DIST",
+		Short:                 "Update the dates of a distribution and sign it again",
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	refreshValidFor := refreshCmd.Flags().Duration("valid-for", 0, "set Valid-Until to this long after Date (default is to keep the current interval)")
+	refreshDryRun := refreshCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	refreshCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdRefresh(dryRunContext(cmd, *refreshDryRun), bucket, distribution(args[1]), *keyIDs, *refreshValidFor)
+	}
+	rootCmd.AddCommand(refreshCmd)
+	snapshotCmd := &cobra.Command{
+		Use:                   "snapshot [options] BUCKET DIST NAME",
+		Short:                 "Save the current state of a distribution",
+		Args:                  cobra.ExactArgs(3),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	snapshotDryRun := snapshotCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	snapshotCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdSnapshot(dryRunContext(cmd, *snapshotDryRun), bucket, distribution(args[1]), args[2])
+	}
+	snapshotCmd.AddCommand(&cobra.Command{
+		Use:                   "list [options] BUCKET DIST",
+		Short:                 "List the snapshots of a distribution",
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+			if err != nil {
+				return err
+			}
+			defer bucket.Close()
+			return cmdListSnapshots(cmd.Context(), bucket, distribution(args[1]), textOutput(os.Stdout))
+		},
+	})
+	rootCmd.AddCommand(snapshotCmd)
+	rollbackCmd := &cobra.Command{
+		Use:                   "rollback [options] BUCKET DIST NAME",
+		Short:                 "Restore a distribution from a snapshot",
+		Args:                  cobra.ExactArgs(3),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	rollbackDryRun := rollbackCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	rollbackCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdRollback(dryRunContext(cmd, *rollbackDryRun), bucket, distribution(args[1]), args[2], *keyIDs)
+	}
+	rootCmd.AddCommand(rollbackCmd)
+	freezeCmd := &cobra.Command{
+		Use:                   "freeze [options] BUCKET SRCDIST DSTDIST",
+		Short:                 "Create a frozen copy of a distribution",
+		Args:                  cobra.ExactArgs(3),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	var freezeOpts freezeOptions
+	freezeCmd.Flags().StringVar(&freezeOpts.snapshot, "snapshot", "", "freeze the named snapshot of SRCDIST instead of its current state")
+	freezeCmd.Flags().StringVar(&freezeOpts.suite, "suite", "", "Suite of the new distribution (default DSTDIST)")
+	freezeCmd.Flags().StringVar(&freezeOpts.codename, "codename", "", "Codename of the new distribution (default DSTDIST)")
+	freezeCmd.Flags().BoolVar(&freezeOpts.notAutomatic, "not-automatic", false, "set NotAutomatic: yes")
+	freezeCmd.Flags().BoolVar(&freezeOpts.butAutomaticUpgrades, "but-automatic-upgrades", false, "set ButAutomaticUpgrades: yes")
+	freezeDryRun := freezeCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	freezeCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdFreeze(dryRunContext(cmd, *freezeDryRun), bucket, distribution(args[1]), distribution(args[2]), *keyIDs, freezeOpts)
+	}
+	rootCmd.AddCommand(freezeCmd)
+	publishKeyCmd := &cobra.Command{
+		Use:                   "publish-key [options] BUCKET DIST",
+		Short:                 "Publish the signing keys and an APT sources file",
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	var publishKeyOpts publishKeyOptions
+	publishKeyCmd.Flags().StringVar(&publishKeyOpts.baseURL, "url", "", "URL of the repository root that clients use (required)")
+	publishKeyCmd.Flags().StringVar(&publishKeyOpts.name, "name", "", "name of the repository (default is the distribution name)")
+	publishKeyCmd.Flags().StringVar(&publishKeyOpts.keyPath, "key-path", "", "path in the bucket to write the keyring to, without extension (default NAME-archive-keyring)")
+	publishKeyCmd.Flags().StringVar(&publishKeyOpts.sourcesPath, "sources-path", "", "path in the bucket to write the sources file to (default NAME.sources)")
+	publishKeyCmd.Flags().StringVar(&publishKeyOpts.signedBy, "signed-by", "", "path of the keyring on clients (default /usr/share/keyrings/NAME-archive-keyring.gpg)")
+	publishKeyDryRun := publishKeyCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	publishKeyCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		bucket, err := openBucket(cmd.Context(), args[0], *prefix)
+		if err != nil {
+			return err
+		}
+		defer bucket.Close()
+		return cmdPublishKey(dryRunContext(cmd, *publishKeyDryRun), bucket, distribution(args[1]), *keyIDs, publishKeyOpts)
+	}
+	rootCmd.AddCommand(publishKeyCmd)
+	applyCmd := &cobra.Command{
+		Use:                   "apply [options] CONFIG",
+		Short:                 "Reconcile distributions with a configuration file",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	applyDryRun := applyCmd.Flags().Bool("dry-run", false, "print the changes that would be made without making them")
+	applyCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		configs, err := parseConfig(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		ctx := dryRunContext(cmd, *applyDryRun)
+		for _, cfg := range configs {
+			bucketPrefix := cfg.prefix
+			if bucketPrefix == "" {
+				bucketPrefix = *prefix
+			}
+			bucket, err := openBucket(ctx, cfg.bucketURL, bucketPrefix)
+			if err != nil {
+				return err
+			}
+			err = cmdApply(ctx, bucket, textOutput(os.Stdout), cfg, *keyIDs)
+			bucket.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", cfg.dist, err)
+			}
+		}
+		return nil
+	}
+	rootCmd.AddCommand(applyCmd)
+	err := rootCmd.ExecuteContext(withReport(context.Background(), rep))
+	if rep.enabled {
+		if writeErr := rep.write(os.Stdout, err); writeErr != nil {
+			fmt.Fprintln(os.Stderr, "aptblob:", writeErr)
+		}
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, "aptblob:", err)
-		os.Exit(1)
+	}
+	if err != nil {
+		os.Exit(exitCode(err))
 	}
 }
 
+// openBucket opens the bucket at the given URL. If prefix is not empty, then
+// all keys are relative to the prefix, so that the repository root does not
+// have to be the bucket root. The URL may also specify a prefix with a
+// "prefix" query parameter, in which case the two are joined.
+func openBucket(ctx context.Context, urlstr string, prefix string) (*blob.Bucket, error) {
+	bucket, err := blob.OpenBucket(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	prefix = normalizePrefix(prefix)
+	if prefix == "" {
+		return bucket, nil
+	}
+	return blob.PrefixedBucket(bucket, prefix), nil
+}
+
+// normalizePrefix returns the prefix with exactly one trailing slash
+// and no leading slash.
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// addToTokenSet adds s to the whitespace-separated set of tokens in the named
+// field. The tokens are kept sorted so that the result doesn't depend on the
+// order in which they were added.
 func addToTokenSet(para *deb.Paragraph, key string, s string) {
 	var f *deb.Field
 	for i := range *para {
@@ -408,6 +1090,16 @@ func addToTokenSet(para *deb.Paragraph, key string, s string) {
 		}
 	}
 	elems = append(elems, s)
+	sort.Strings(elems)
 	f.Value = strings.Join(elems, " ")
 	return
 }
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -26,9 +26,12 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -50,7 +53,7 @@ func TestInit(t *testing.T) {
 	ctx := context.Background()
 	bucket := memblob.OpenBucket(nil)
 	stdin := strings.NewReader(want.String())
-	err := cmdInit(ctx, bucket, stdin, ioutil.Discard, "stable", "")
+	err := cmdInit(ctx, bucket, stdin, ioutil.Discard, "stable", nil, 0)
 	if err != nil {
 		t.Error("init:", err)
 	}
@@ -66,13 +69,63 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestInitValidUntil(t *testing.T) {
+	tests := []struct {
+		name      string
+		stdin     deb.Paragraph
+		wantValid time.Duration
+	}{
+		{
+			name: "Rebased",
+			stdin: deb.Paragraph{
+				{Name: "Origin", Value: "stable"},
+				{Name: "Date", Value: "Mon, 06 Jan 2020 00:00:00 Z"},
+				{Name: "Valid-Until", Value: "Mon, 13 Jan 2020 00:00:00 Z"},
+			},
+			wantValid: 7 * 24 * time.Hour,
+		},
+		{
+			name: "NoDate",
+			stdin: deb.Paragraph{
+				{Name: "Origin", Value: "stable"},
+				{Name: "Valid-Until", Value: "Mon, 13 Jan 2020 00:00:00 Z"},
+			},
+			wantValid: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := memblob.OpenBucket(nil)
+			stdin := strings.NewReader(test.stdin.String())
+			err := cmdInit(ctx, bucket, stdin, ioutil.Discard, "stable", nil, 0)
+			if err != nil {
+				t.Fatal("init:", err)
+			}
+			release, err := downloadReleaseIndex(ctx, bucket, "stable")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantValid == 0 {
+				if v := release.Get("Valid-Until"); v != "" {
+					t.Errorf("Valid-Until = %q; want none", v)
+				}
+				return
+			}
+			if got := releaseValidity(release); got != test.wantValid {
+				t.Errorf("Valid-Until - Date = %v; want %v", got, test.wantValid)
+			}
+		})
+	}
+}
+
 func TestUpload(t *testing.T) {
 	ctx := context.Background()
 	bucket := memblob.OpenBucket(nil)
-	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, "", []string{
+	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
 		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
 		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
-	})
+	}, packageUploadOptions{})
 	if err != nil {
 		t.Error("upload:", err)
 	}
@@ -110,6 +163,28 @@ func TestUpload(t *testing.T) {
 		if err := checkFile(ctx, bucket, file, "nullpkg_1.0-1_amd64.deb"); err != nil {
 			t.Error(err)
 		}
+		wantOrder := []string{
+			"Package",
+			"Architecture",
+			"Version",
+			"Priority",
+			"Section",
+			"Maintainer",
+			"Installed-Size",
+			"Filename",
+			"Size",
+			"MD5sum",
+			"SHA1",
+			"SHA256",
+			"Description",
+		}
+		var gotOrder []string
+		for _, f := range gotPackages[0] {
+			gotOrder = append(gotOrder, f.Name)
+		}
+		if diff := cmp.Diff(wantOrder, gotOrder); diff != "" {
+			t.Errorf("%s field order (-want +got):\n%s", packagesKey, diff)
+		}
 	}
 
 	const sourcesFilename = "main/source/Sources"
@@ -194,6 +269,230 @@ func TestUpload(t *testing.T) {
 	}
 }
 
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	const validFor = 7 * 24 * time.Hour
+	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{validFor: validFor})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	release, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := releaseValidity(release); got != validFor {
+		t.Errorf("after upload, Valid-Until - Date = %v; want %v", got, validFor)
+	}
+
+	// Simulate the passage of time.
+	past := time.Now().Add(-30 * 24 * time.Hour)
+	setReleaseDates(&release, past, past, validFor)
+	if err := uploadReleaseIndex(ctx, bucket, "stable", release, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmdRefresh(ctx, bucket, "stable", nil, 0); err != nil {
+		t.Fatal("refresh:", err)
+	}
+	release, err = downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := releaseValidity(release); got != validFor {
+		t.Errorf("after refresh, Valid-Until - Date = %v; want %v", got, validFor)
+	}
+	validUntil, err := parseReleaseDate(release.Get("Valid-Until"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validUntil.After(time.Now()) {
+		t.Errorf("after refresh, Valid-Until = %v; want after now", validUntil)
+	}
+	if release.Get("SHA256") == "" {
+		t.Error("refresh dropped SHA256 field")
+	}
+}
+
+func TestRefreshSourceDateEpoch(t *testing.T) {
+	setSourceDateEpoch(t, "1596240000")
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	const validFor = 7 * 24 * time.Hour
+	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{validFor: validFor})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	release, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := release.Get("Date"), time.Unix(1596240000, 0).UTC().Format(releaseDateFormat); got != want {
+		t.Errorf("after upload, Date = %q; want %q", got, want)
+	}
+	if validUntil, err := parseReleaseDate(release.Get("Valid-Until")); err != nil {
+		t.Error(err)
+	} else if !validUntil.After(time.Now()) {
+		t.Errorf("after upload, Valid-Until = %v; want after now", validUntil)
+	}
+
+	start := time.Now().Add(-time.Second)
+	if err := cmdRefresh(ctx, bucket, "stable", nil, validFor); err != nil {
+		t.Fatal("refresh:", err)
+	}
+	release, err = downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if date, err := parseReleaseDate(release.Get("Date")); err != nil {
+		t.Error(err)
+	} else if date.Before(start) {
+		t.Errorf("after refresh, Date = %v; want the current time", date)
+	}
+	if validUntil, err := parseReleaseDate(release.Get("Valid-Until")); err != nil {
+		t.Error(err)
+	} else if !validUntil.After(time.Now()) {
+		t.Errorf("after refresh, Valid-Until = %v; want after now", validUntil)
+	}
+}
+
+func TestRefreshMissing(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	if err := cmdRefresh(ctx, bucket, "stable", nil, 24*time.Hour); err == nil {
+		t.Error("refresh of missing distribution did not return an error")
+	}
+}
+
+func TestUploadPrefix(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "aptblob_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bucketURL := "file://" + filepath.ToSlash(dir)
+	bucket, err := openBucket(ctx, bucketURL, "/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	err = cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+
+	root, err := openBucket(ctx, bucketURL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	const packagesKey = "repo/dists/stable/main/binary-amd64/Packages"
+	got, _, err := listParagraphs(ctx, root, packagesKey, deb.ControlFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("%s has %d paragraphs; want 1", packagesKey, len(got))
+	}
+	const wantFilename = "pool/nullpkg_1.0-1_amd64.deb"
+	if file := got[0].Get("Filename"); file != wantFilename {
+		t.Errorf("Filename = %q; want %q", file, wantFilename)
+	}
+	if err := checkFile(ctx, root, "repo/"+wantFilename, "nullpkg_1.0-1_amd64.deb"); err != nil {
+		t.Error(err)
+	}
+	if exists, err := root.Exists(ctx, testReleaseKey); err != nil {
+		t.Error(err)
+	} else if exists {
+		t.Errorf("%s exists outside of prefix", testReleaseKey)
+	}
+}
+
+func TestUploadSignedSources(t *testing.T) {
+	keys := newTestKeyring(t, "Maintainer", "Other")
+	if t.Failed() {
+		return
+	}
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not found:", err)
+	}
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "aptblob_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, name := range []string{"nullpkg_1.0.orig.tar.gz", "nullpkg_1.0-1.debian.tar.xz"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, name), data)
+	}
+	dscPath := filepath.Join(dir, "nullpkg_1.0-1.dsc")
+	dsc, err := ioutil.ReadFile(filepath.Join("testdata", "nullpkg_1.0-1.dsc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := exec.Command("gpg", "--batch", "--local-user", keys[0]+"!", "--clear-sign")
+	sign.Stdin = bytes.NewReader(dsc)
+	signedDSC, err := sign.Output()
+	if err != nil {
+		t.Fatal("sign .dsc:", err)
+	}
+	maintainerKeyring, err := exportPublicKeys(ctx, keys[:1], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maintainerKeyringPath := filepath.Join(dir, "maintainer.asc")
+	writeTestFile(t, maintainerKeyringPath, maintainerKeyring)
+	otherKeyring, err := exportPublicKeys(ctx, keys[1:], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyringPath := filepath.Join(dir, "other.gpg")
+	writeTestFile(t, otherKeyringPath, otherKeyring)
+
+	comp := component{dist: "stable", name: "main"}
+	opts := packageUploadOptions{sourceKeyringPath: maintainerKeyringPath}
+	writeTestFile(t, dscPath, dsc)
+	if err := cmdUpload(ctx, memblob.OpenBucket(nil), comp, []string{dscPath}, opts); err == nil {
+		t.Error("upload of unsigned source succeeded")
+	} else if got := errorCode(err); got != codeSignatureRequired {
+		t.Errorf("upload of unsigned source: errorCode(%v) = %q; want %q", err, got, codeSignatureRequired)
+	}
+
+	writeTestFile(t, dscPath, signedDSC)
+	otherOpts := packageUploadOptions{sourceKeyringPath: otherKeyringPath}
+	if err := cmdUpload(ctx, memblob.OpenBucket(nil), comp, []string{dscPath}, otherOpts); err == nil {
+		t.Error("upload of source signed by unknown key succeeded")
+	}
+
+	bucket := memblob.OpenBucket(nil)
+	if err := cmdUpload(ctx, bucket, comp, []string{dscPath}, opts); err != nil {
+		t.Fatal("upload of signed source:", err)
+	}
+	got, _, err := listParagraphs(ctx, bucket, comp.sourceIndexPath(), deb.SourceControlFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Get("Package") != "nullpkg" {
+		t.Errorf("%s = %v; want nullpkg", comp.sourceIndexPath(), got)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "nullpkg_1.0.orig.tar.gz"), []byte("tampered"))
+	if err := cmdUpload(ctx, memblob.OpenBucket(nil), comp, []string{dscPath}, opts); err == nil {
+		t.Error("upload of source with modified tarball succeeded")
+	}
+}
+
 func listParagraphs(ctx context.Context, b *blob.Bucket, key string, fields map[string]deb.FieldType) ([]deb.Paragraph, []byte, error) {
 	r, err := b.NewReader(ctx, key, nil)
 	if err != nil {
@@ -364,3 +663,146 @@ func TestDedupePackages(t *testing.T) {
 		})
 	}
 }
+
+func TestSortIndex(t *testing.T) {
+	packages := []deb.Paragraph{
+		{
+			{Name: "Version", Value: "1.10"},
+			{Name: "Package", Value: "libc6"},
+			{Name: "Architecture", Value: "amd64"},
+		},
+		{
+			{Name: "Package", Value: "libc6"},
+			{Name: "Version", Value: "1.9"},
+			{Name: "Architecture", Value: "arm64"},
+		},
+		{
+			{Name: "Package", Value: "libc6"},
+			{Name: "Version", Value: "1.9"},
+			{Name: "Architecture", Value: "amd64"},
+		},
+		{
+			{Name: "Package", Value: "git"},
+			{Name: "Version", Value: "1:2.20"},
+			{Name: "Architecture", Value: "amd64"},
+		},
+	}
+	sortIndex("dists/stable/main/binary-amd64/Packages", packages)
+	want := []deb.Paragraph{
+		{
+			{Name: "Package", Value: "git"},
+			{Name: "Architecture", Value: "amd64"},
+			{Name: "Version", Value: "1:2.20"},
+		},
+		{
+			{Name: "Package", Value: "libc6"},
+			{Name: "Architecture", Value: "amd64"},
+			{Name: "Version", Value: "1.9"},
+		},
+		{
+			{Name: "Package", Value: "libc6"},
+			{Name: "Architecture", Value: "arm64"},
+			{Name: "Version", Value: "1.9"},
+		},
+		{
+			{Name: "Package", Value: "libc6"},
+			{Name: "Architecture", Value: "amd64"},
+			{Name: "Version", Value: "1.10"},
+		},
+	}
+	if diff := cmp.Diff(want, packages); diff != "" {
+		t.Errorf("packages (-want +got):\n%s", diff)
+	}
+}
+
+func TestReproducibleUpload(t *testing.T) {
+	setSourceDateEpoch(t, "1596240000")
+	ctx := context.Background()
+	paths := []string{
+		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}
+	comp := component{dist: "stable", name: "main"}
+	together := memblob.OpenBucket(nil)
+	if err := cmdUpload(ctx, together, comp, paths, packageUploadOptions{}); err != nil {
+		t.Fatal("upload:", err)
+	}
+	separate := memblob.OpenBucket(nil)
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := cmdUpload(ctx, separate, comp, paths[i:i+1], packageUploadOptions{}); err != nil {
+			t.Fatal("upload:", err)
+		}
+	}
+
+	want, err := readAllObjects(ctx, together)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAllObjects(ctx, separate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("bucket contents (-together +separate):\n%s", diff)
+	}
+	release, err := deb.ParseReleaseIndex(strings.NewReader(want[testReleaseKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := release.Get("Date"), "Sat, 01 Aug 2020 00:00:00 Z"; got != want {
+		t.Errorf("Date = %q; want %q", got, want)
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	setSourceDateEpoch(t, "")
+	if _, ok, err := sourceDateEpoch(); ok || err != nil {
+		t.Errorf("sourceDateEpoch() with %s unset = _, %t, %v; want _, false, <nil>", sourceDateEpochEnv, ok, err)
+	}
+	setSourceDateEpoch(t, "1596240000")
+	if got, ok, err := sourceDateEpoch(); !ok || err != nil || got.Unix() != 1596240000 {
+		t.Errorf("sourceDateEpoch() = %v, %t, %v; want %v, true, <nil>", got, ok, err, time.Unix(1596240000, 0))
+	}
+	setSourceDateEpoch(t, "yesterday")
+	if _, _, err := sourceDateEpoch(); err == nil {
+		t.Error("sourceDateEpoch() with malformed value did not return an error")
+	}
+}
+
+// setSourceDateEpoch sets the SOURCE_DATE_EPOCH environment variable for the
+// duration of the test. An empty value unsets it.
+func setSourceDateEpoch(t *testing.T, value string) {
+	old, hadOld := os.LookupEnv(sourceDateEpochEnv)
+	if value == "" {
+		os.Unsetenv(sourceDateEpochEnv)
+	} else {
+		os.Setenv(sourceDateEpochEnv, value)
+	}
+	t.Cleanup(func() {
+		if hadOld {
+			os.Setenv(sourceDateEpochEnv, old)
+		} else {
+			os.Unsetenv(sourceDateEpochEnv)
+		}
+	})
+}
+
+// readAllObjects returns the content of every object in the bucket.
+func readAllObjects(ctx context.Context, b *blob.Bucket) (map[string]string, error) {
+	objects := make(map[string]string)
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := b.ReadAll(ctx, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		objects[obj.Key] = string(data)
+	}
+}
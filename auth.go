@@ -0,0 +1,316 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	slashpath "path"
+	"strings"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// accessPolicy is a set of rules that control who may read which parts of a
+// repository served by bucketHandler.
+type accessPolicy struct {
+	rules []accessRule
+}
+
+// accessRule grants a client access to a set of distributions and components.
+// A rule with neither login nor token applies to every client.
+type accessRule struct {
+	login    string
+	password string
+	token    string
+	// allow is a list of grants. A grant is "*" (the whole repository),
+	// a distribution name, or a distribution and component separated by a
+	// slash.
+	allow []string
+}
+
+// Access configuration fields.
+const (
+	authLoginField    = "Login"
+	authPasswordField = "Password"
+	authTokenField    = "Token"
+	authAllowField    = "Allow"
+)
+
+// parseAccessPolicy parses an access configuration file. An access
+// configuration file is a Debian control file where each paragraph is a rule:
+// a Login and Password (for HTTP Basic authentication, as sent by apt for
+// credentials in auth.conf) or a Token (for bearer authentication), and an
+// Allow field that lists the grants. A paragraph with only an Allow field
+// grants access to every client.
+func parseAccessPolicy(r io.Reader) (*accessPolicy, error) {
+	p := deb.NewParser(r)
+	policy := new(accessPolicy)
+	for p.Next() {
+		rule, err := parseAccessRule(p.Paragraph())
+		if err != nil {
+			return nil, fmt.Errorf("parse access config: rule #%d: %w", len(policy.rules)+1, withCode(codeParseError, err))
+		}
+		policy.rules = append(policy.rules, rule)
+	}
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("parse access config: %w", withCode(codeParseError, err))
+	}
+	return policy, nil
+}
+
+func parseAccessRule(para deb.Paragraph) (accessRule, error) {
+	var rule accessRule
+	for _, f := range para {
+		switch f.Name {
+		case authLoginField:
+			rule.login = f.Value
+		case authPasswordField:
+			rule.password = f.Value
+		case authTokenField:
+			rule.token = f.Value
+		case authAllowField:
+			rule.allow = strings.Fields(f.Value)
+		default:
+			return accessRule{}, fmt.Errorf("unknown field %s", f.Name)
+		}
+	}
+	switch {
+	case rule.login != "" && rule.token != "":
+		return accessRule{}, fmt.Errorf("%s and %s are mutually exclusive", authLoginField, authTokenField)
+	case rule.login != "" && rule.password == "":
+		return accessRule{}, fmt.Errorf("missing %s", authPasswordField)
+	case rule.login == "" && rule.password != "":
+		return accessRule{}, fmt.Errorf("%s without %s", authPasswordField, authLoginField)
+	case len(rule.allow) == 0:
+		return accessRule{}, fmt.Errorf("missing %s", authAllowField)
+	}
+	for _, grant := range rule.allow {
+		if grant != "*" && (strings.Count(grant, "/") > 1 || strings.HasPrefix(grant, "/") || strings.HasSuffix(grant, "/")) {
+			return accessRule{}, fmt.Errorf("%s: invalid grant %q", authAllowField, grant)
+		}
+	}
+	return rule, nil
+}
+
+// grants returns the grants for the credentials in the request.
+// ok is false if the request has credentials that don't match any rule.
+func (policy *accessPolicy) grants(r *http.Request) (grants []string, ok bool) {
+	login, password, hasBasic := r.BasicAuth()
+	token := bearerToken(r)
+	ok = !hasBasic && token == ""
+	for _, rule := range policy.rules {
+		switch {
+		case rule.login == "" && rule.token == "":
+			grants = append(grants, rule.allow...)
+		case rule.login != "" && hasBasic && secretEqual(login, rule.login) && secretEqual(password, rule.password):
+			grants = append(grants, rule.allow...)
+			ok = true
+		case rule.token != "" && token != "" && secretEqual(token, rule.token):
+			grants = append(grants, rule.allow...)
+			ok = true
+		}
+	}
+	return grants, ok
+}
+
+// bearerToken returns the bearer token in the request's Authorization header
+// or the empty string if there is none.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// secretEqual compares two strings in constant time.
+func secretEqual(a, b string) bool {
+	// Hashing first avoids leaking the length of the secret.
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// keyScope returns the distribution and component that a key belongs to.
+// Keys under pool/ have no distribution, since pool objects are shared.
+// comp is empty for keys that belong to the distribution as a whole, like the
+// Release file.
+func keyScope(key string) (dist distribution, comp string) {
+	parts := strings.Split(key, "/")
+	switch {
+	case parts[0] == "dists" && len(parts) >= 3:
+		dist = distribution(parts[1])
+		if len(parts) >= 4 {
+			comp = parts[2]
+		}
+	case parts[0] == "snapshots" && len(parts) >= 4:
+		dist = distribution(parts[1])
+		if len(parts) >= 5 {
+			comp = parts[3]
+		}
+	}
+	return dist, comp
+}
+
+// grantsAccess reports whether any of the grants permit reading the given
+// distribution and component. An empty component is permitted by a grant
+// for any component of the distribution.
+func grantsAccess(grants []string, dist distribution, comp string) bool {
+	for _, grant := range grants {
+		switch {
+		case grant == "*" || grant == string(dist):
+			return true
+		case comp != "" && grant == string(dist)+"/"+comp:
+			return true
+		case comp == "" && strings.HasPrefix(grant, string(dist)+"/"):
+			return true
+		}
+	}
+	return false
+}
+
+// authorize reports whether the client may read the object at key.
+// If not, it writes an error response.
+func (h *bucketHandler) authorize(w http.ResponseWriter, r *http.Request, key string) bool {
+	grants, ok := h.policy.grants(r)
+	if ok {
+		allowed, err := h.allowed(r.Context(), grants, key)
+		if err != nil {
+			h.logger.Printf("%s: %v", key, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return false
+		}
+		if allowed {
+			return true
+		}
+	}
+	if _, _, hasBasic := r.BasicAuth(); ok && (hasBasic || bearerToken(r) != "") {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="aptblob"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (h *bucketHandler) allowed(ctx context.Context, grants []string, key string) (bool, error) {
+	if containsString(grants, "*") {
+		return true, nil
+	}
+	if dist, comp := keyScope(key); dist != "" {
+		return grantsAccess(grants, dist, comp), nil
+	}
+	if !strings.HasPrefix(key, poolPath("")) {
+		// Files outside of distributions, like published keys,
+		// are readable by any client with a grant.
+		return len(grants) > 0, nil
+	}
+	// A pool object is readable if it is referenced by an index that the
+	// client can read.
+	for _, grant := range grants {
+		dist, comp := distribution(grant), ""
+		if i := strings.IndexByte(grant, '/'); i != -1 {
+			dist, comp = distribution(grant[:i]), grant[i+1:]
+		}
+		refs, err := h.poolRefs.get(ctx, h.bucket, dist)
+		if err != nil {
+			return false, err
+		}
+		comps, ok := refs[key]
+		if ok && (comp == "" || containsString(comps, comp)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// poolRefCache caches the pool objects referenced by each distribution.
+// The zero value is an empty cache.
+type poolRefCache struct {
+	mu    sync.Mutex
+	dists map[distribution]poolRefs
+}
+
+type poolRefs struct {
+	releaseModTime time.Time
+	// refs maps pool keys to the components that reference them.
+	refs map[string][]string
+}
+
+// get returns a map of pool keys referenced by the distribution to the
+// components that reference them. The result is cached until the
+// distribution's Release file changes.
+func (cache *poolRefCache) get(ctx context.Context, bucket *blob.Bucket, dist distribution) (map[string][]string, error) {
+	attr, err := bucket.Attributes(ctx, dist.indexPath())
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cached, ok := cache.dists[dist]
+	cache.mu.Unlock()
+	if ok && cached.releaseModTime.Equal(attr.ModTime) {
+		return cached.refs, nil
+	}
+
+	release, err := downloadReleaseIndex(ctx, bucket, dist)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := listedIndexes(release)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dist.indexPath(), err)
+	}
+	refs := make(map[string][]string)
+	for _, distPath := range indexes {
+		var files []deb.IndexSignature
+		key := dist.dir() + "/" + distPath
+		if slashpath.Base(distPath) == "Sources" {
+			files, err = sourcePoolFiles(ctx, bucket, key)
+		} else {
+			files, err = binaryPoolFiles(ctx, bucket, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		comp := distPath[:strings.IndexByte(distPath+"/", '/')]
+		for _, f := range files {
+			if !containsString(refs[f.Filename], comp) {
+				refs[f.Filename] = append(refs[f.Filename], comp)
+			}
+		}
+	}
+	cache.mu.Lock()
+	if cache.dists == nil {
+		cache.dists = make(map[distribution]poolRefs)
+	}
+	cache.dists[dist] = poolRefs{releaseModTime: attr.ModTime, refs: refs}
+	cache.mu.Unlock()
+	return refs, nil
+}
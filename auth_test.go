@@ -0,0 +1,234 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestParseAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []accessRule
+		wantErr bool
+	}{
+		{
+			name: "Rules",
+			config: "Login: alice\nPassword: secret\nAllow: stable unstable/main\n\n" +
+				"Token: abc123\nAllow: *\n\n" +
+				"Allow: public\n",
+			want: []accessRule{
+				{login: "alice", password: "secret", allow: []string{"stable", "unstable/main"}},
+				{token: "abc123", allow: []string{"*"}},
+				{allow: []string{"public"}},
+			},
+		},
+		{
+			name:    "MissingPassword",
+			config:  "Login: alice\nAllow: stable\n",
+			wantErr: true,
+		},
+		{
+			name:    "LoginAndToken",
+			config:  "Login: alice\nPassword: secret\nToken: abc123\nAllow: stable\n",
+			wantErr: true,
+		},
+		{
+			name:    "MissingAllow",
+			config:  "Token: abc123\n",
+			wantErr: true,
+		},
+		{
+			name:    "InvalidGrant",
+			config:  "Token: abc123\nAllow: stable/main/extra\n",
+			wantErr: true,
+		},
+		{
+			name:    "UnknownField",
+			config:  "Token: abc123\nAllow: stable\nColor: blue\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := parseAccessPolicy(strings.NewReader(test.config))
+			if err != nil {
+				if !test.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.wantErr {
+				t.Fatal("parseAccessPolicy did not return an error")
+			}
+			if diff := cmp.Diff(test.want, policy.rules, cmp.AllowUnexported(accessRule{})); diff != "" {
+				t.Errorf("rules (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServeAuth(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	for _, dist := range []distribution{"public", "private"} {
+		err := cmdUpload(ctx, bucket, component{dist: dist, name: "main"}, []string{
+			filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+		}, packageUploadOptions{})
+		if err != nil {
+			t.Fatal("upload:", err)
+		}
+	}
+	if err := bucket.WriteAll(ctx, poolPath("secret_1.0_amd64.deb"), []byte("unreferenced"), nil); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := parseAccessPolicy(strings.NewReader(
+		"Allow: public\n\n" +
+			"Login: alice\nPassword: secret\nAllow: private/main\n\n" +
+			"Login: bob\nPassword: hunter2\nAllow: other\n\n" +
+			"Token: abc123\nAllow: *\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&bucketHandler{
+		bucket: bucket,
+		logger: log.New(ioutil.Discard, "", 0),
+		policy: policy,
+	})
+	t.Cleanup(srv.Close)
+
+	const debPath = "/pool/nullpkg_1.0-1_amd64.deb"
+	tests := []struct {
+		name       string
+		path       string
+		login      string
+		password   string
+		token      string
+		wantStatus int
+	}{
+		{name: "AnonymousPublic", path: "/dists/public/Release", wantStatus: http.StatusOK},
+		{name: "AnonymousPrivate", path: "/dists/private/Release", wantStatus: http.StatusUnauthorized},
+		{name: "AnonymousPool", path: debPath, wantStatus: http.StatusOK},
+		{name: "AnonymousUnreferencedPool", path: "/pool/secret_1.0_amd64.deb", wantStatus: http.StatusUnauthorized},
+		{name: "ComponentRelease", path: "/dists/private/Release", login: "alice", password: "secret", wantStatus: http.StatusOK},
+		{name: "ComponentIndex", path: "/dists/private/main/binary-amd64/Packages", login: "alice", password: "secret", wantStatus: http.StatusOK},
+		{name: "OtherComponent", path: "/dists/private/contrib/binary-amd64/Packages", login: "alice", password: "secret", wantStatus: http.StatusForbidden},
+		{name: "WrongPassword", path: "/dists/private/Release", login: "alice", password: "hunter2", wantStatus: http.StatusUnauthorized},
+		{name: "ReferencedPool", path: debPath, login: "alice", password: "secret", wantStatus: http.StatusOK},
+		{name: "UnreferencedPool", path: "/pool/secret_1.0_amd64.deb", login: "alice", password: "secret", wantStatus: http.StatusForbidden},
+		{name: "NoAccess", path: "/dists/private/Release", login: "bob", password: "hunter2", wantStatus: http.StatusForbidden},
+		{name: "Token", path: "/pool/secret_1.0_amd64.deb", token: "abc123", wantStatus: http.StatusOK},
+		{name: "WrongToken", path: "/dists/public/Release", token: "xyz", wantStatus: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.login != "" {
+				req.SetBasicAuth(test.login, test.password)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("status = %d; want %d", resp.StatusCode, test.wantStatus)
+			}
+			if resp.StatusCode == http.StatusOK && !strings.HasPrefix(resp.Header.Get("Cache-Control"), "private") {
+				t.Errorf("Cache-Control = %q; want private", resp.Header.Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestServeSignedURL(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "aptblob_serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	signerURL, err := url.Parse("https://storage.example.com/signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := fileblob.OpenBucket(dir, &fileblob.Options{
+		URLSigner: fileblob.NewURLSignerHMAC(signerURL, []byte("secret")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	err = cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	srv := httptest.NewServer(&bucketHandler{
+		bucket:          bucket,
+		logger:          log.New(ioutil.Discard, "", 0),
+		signedURLExpiry: 15 * time.Minute,
+	})
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(srv.URL + "/pool/nullpkg_1.0-1_amd64.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("pool status = %d; want %d", resp.StatusCode, http.StatusTemporaryRedirect)
+	}
+	if loc := resp.Header.Get("Location"); !strings.HasPrefix(loc, signerURL.String()) {
+		t.Errorf("Location = %q; want prefix %q", loc, signerURL)
+	}
+
+	resp, err = client.Get(srv.URL + "/dists/stable/Release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Release status = %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+}
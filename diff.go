@@ -0,0 +1,270 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change in a
+// unified diff.
+const diffContext = 3
+
+// unifiedDiff returns a unified diff between two texts, or the empty string if
+// they are identical.
+func unifiedDiff(oldName, newName string, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	a := splitLines(oldText)
+	b := splitLines(newText)
+	edits := diffLines(a, b)
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(edits); {
+		// Find the next change.
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// Extend the hunk until there are enough unchanged lines to end it.
+		end := start
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].op == ' ' {
+				run++
+			}
+			if run == len(edits) || run-end > 2*diffContext {
+				break
+			}
+			end = run
+		}
+		hunkStart := start - diffContext
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + diffContext
+		if hunkEnd > len(edits) {
+			hunkEnd = len(edits)
+		}
+
+		var oldCount, newCount int
+		for _, e := range edits[hunkStart:hunkEnd] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		first := edits[hunkStart]
+		fmt.Fprintf(sb, "@@ -%s +%s @@\n",
+			hunkRange(first.oldLine, oldCount),
+			hunkRange(first.newLine, newCount))
+		for _, e := range edits[hunkStart:hunkEnd] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			sb.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+	return sb.String()
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		// By convention, empty ranges refer to the line before.
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprint(line + 1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineEdit is a single line of an edit script.
+type lineEdit struct {
+	// op is one of ' ' (unchanged), '-' (deleted), or '+' (inserted).
+	op   byte
+	line string
+	// oldLine and newLine are the zero-based line numbers in each text
+	// at which the edit occurs.
+	oldLine int
+	newLine int
+}
+
+// diffLines computes a shortest edit script from a to b using the
+// linear-space variant of Myers' algorithm: it finds the middle of the edit
+// script and recurses on each half, so memory use stays proportional to the
+// length of the inputs rather than to the number of edits.
+func diffLines(a, b []string) []lineEdit {
+	d := &lineDiffer{
+		a:     a,
+		b:     b,
+		edits: make([]lineEdit, 0, len(a)+len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+// lineDiffer accumulates the edit script for diffLines.
+type lineDiffer struct {
+	a, b  []string
+	edits []lineEdit
+}
+
+// compare appends the edits from a[aStart:aEnd] to b[bStart:bEnd].
+func (d *lineDiffer) compare(aStart, aEnd, bStart, bEnd int) {
+	for aStart < aEnd && bStart < bEnd && d.a[aStart] == d.b[bStart] {
+		d.keep(aStart, bStart)
+		aStart++
+		bStart++
+	}
+	aSuffix, bSuffix := aEnd, bEnd
+	for aSuffix > aStart && bSuffix > bStart && d.a[aSuffix-1] == d.b[bSuffix-1] {
+		aSuffix--
+		bSuffix--
+	}
+
+	if aStart == aSuffix || bStart == bSuffix {
+		d.replace(aStart, aSuffix, bStart, bSuffix)
+	} else if x, y, ok := d.split(aStart, aSuffix, bStart, bSuffix); ok {
+		d.compare(aStart, x, bStart, y)
+		d.compare(x, aSuffix, y, bSuffix)
+	} else {
+		d.replace(aStart, aSuffix, bStart, bSuffix)
+	}
+
+	for x, y := aSuffix, bSuffix; x < aEnd; x, y = x+1, y+1 {
+		d.keep(x, y)
+	}
+}
+
+// split finds a point on a shortest edit script from a[aStart:aEnd] to
+// b[bStart:bEnd] by searching forward from the start and backward from the end
+// until the two searches meet. Both ranges must be non-empty. ok is false if
+// the ranges have no lines in common.
+func (d *lineDiffer) split(aStart, aEnd, bStart, bEnd int) (x, y int, ok bool) {
+	n, m := aEnd-aStart, bEnd-bStart
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward[offset+k] is the furthest x reached on diagonal k = x - y from
+	// the start; backward is the same, measured from the end.
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+	delta := n - m
+	// If delta is odd, the searches meet during a forward step.
+	front := delta%2 != 0
+	// Diagonals that have left the grid are trimmed from later steps.
+	var fStart, fEnd, bTrimStart, bTrimEnd int
+	for step := 0; step < maxD; step++ {
+		for k := -step + fStart; k <= step-fEnd; k += 2 {
+			i := offset + k
+			var fx int
+			if k == -step || k != step && forward[i-1] < forward[i+1] {
+				fx = forward[i+1]
+			} else {
+				fx = forward[i-1] + 1
+			}
+			fy := fx - k
+			for fx < n && fy < m && d.a[aStart+fx] == d.b[bStart+fy] {
+				fx++
+				fy++
+			}
+			forward[i] = fx
+			switch {
+			case fx > n:
+				fEnd += 2
+			case fy > m:
+				fStart += 2
+			case front:
+				j := offset + delta - k
+				if j >= 0 && j < len(backward) && backward[j] != -1 && fx >= n-backward[j] {
+					return aStart + fx, bStart + fy, true
+				}
+			}
+		}
+		for k := -step + bTrimStart; k <= step-bTrimEnd; k += 2 {
+			i := offset + k
+			var bx int
+			if k == -step || k != step && backward[i-1] < backward[i+1] {
+				bx = backward[i+1]
+			} else {
+				bx = backward[i-1] + 1
+			}
+			by := bx - k
+			for bx < n && by < m && d.a[aEnd-bx-1] == d.b[bEnd-by-1] {
+				bx++
+				by++
+			}
+			backward[i] = bx
+			switch {
+			case bx > n:
+				bTrimEnd += 2
+			case by > m:
+				bTrimStart += 2
+			case !front:
+				j := offset + delta - k
+				if j >= 0 && j < len(forward) && forward[j] != -1 {
+					fx := forward[j]
+					fy := fx - (j - offset)
+					if fx >= n-bx {
+						return aStart + fx, bStart + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// keep appends an unchanged line.
+func (d *lineDiffer) keep(x, y int) {
+	d.edits = append(d.edits, lineEdit{op: ' ', line: d.a[x], oldLine: x, newLine: y})
+}
+
+// replace appends edits that delete a[aStart:aEnd] and insert b[bStart:bEnd].
+func (d *lineDiffer) replace(aStart, aEnd, bStart, bEnd int) {
+	for x := aStart; x < aEnd; x++ {
+		d.edits = append(d.edits, lineEdit{op: '-', line: d.a[x], oldLine: x, newLine: bStart})
+	}
+	for y := bStart; y < bEnd; y++ {
+		d.edits = append(d.edits, lineEdit{op: '+', line: d.b[y], oldLine: aEnd, newLine: y})
+	}
+}
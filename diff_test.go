@@ -0,0 +1,166 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{
+			name:    "Same",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			want:    "",
+		},
+		{
+			name:    "FromEmpty",
+			oldText: "",
+			newText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "ToEmpty",
+			oldText: "a\n",
+			newText: "",
+			want:    "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:    "Change",
+			oldText: "a\nb\nc\n",
+			newText: "a\nx\nc\n",
+			want:    "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name:    "SeparateHunks",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			newText: "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			want: "--- old\n+++ new\n" +
+				"@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n" +
+				"@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			name:    "MergedHunks",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n",
+			newText: "0\n1\n2\n3\n4\n5\n6\n",
+			want:    "--- old\n+++ new\n@@ -1,7 +1,7 @@\n+0\n 1\n 2\n 3\n 4\n 5\n 6\n-7\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := unifiedDiff("old", "new", test.oldText, test.newText)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unifiedDiff(...) (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(3)))
+		}
+		return lines
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		edits := diffLines(a, b)
+		var gotA, gotB []string
+		changes := 0
+		for _, e := range edits {
+			if e.oldLine != len(gotA) || e.newLine != len(gotB) {
+				t.Fatalf("diffLines(%q, %q) = %v; edit %+v has wrong line numbers", a, b, edits, e)
+			}
+			if e.op != '+' {
+				gotA = append(gotA, e.line)
+			}
+			if e.op != '-' {
+				gotB = append(gotB, e.line)
+			}
+			if e.op != ' ' {
+				changes++
+			}
+		}
+		if !cmp.Equal(a, gotA, cmpopts.EquateEmpty()) || !cmp.Equal(b, gotB, cmpopts.EquateEmpty()) {
+			t.Fatalf("diffLines(%q, %q) = %v; does not reproduce inputs", a, b, edits)
+		}
+		if want := len(a) + len(b) - 2*longestCommonSubsequence(a, b); changes != want {
+			t.Fatalf("diffLines(%q, %q) has %d changes; want %d", a, b, changes, want)
+		}
+	}
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+	const n = 50000
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("Package: pkg%d", i)
+	}
+	text := strings.Join(lines, "\n") + "\n"
+
+	got := unifiedDiff("old", "new", "", text)
+	if want := fmt.Sprintf("@@ -0,0 +1,%d @@\n", n); !strings.Contains(got, want) {
+		t.Errorf("diff from empty does not contain %q", want)
+	}
+	if gotLines := strings.Count(got, "\n+Package: "); gotLines != n {
+		t.Errorf("diff from empty adds %d lines; want %d", gotLines, n)
+	}
+
+	// Change every 100th line.
+	changed := append([]string(nil), lines...)
+	for i := 0; i < n; i += 100 {
+		changed[i] += " (changed)"
+	}
+	got = unifiedDiff("old", "new", text, strings.Join(changed, "\n")+"\n")
+	if gotLines, want := strings.Count(got, "\n-Package: "), n/100; gotLines != want {
+		t.Errorf("diff with changes removes %d lines; want %d", gotLines, want)
+	}
+}
+
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
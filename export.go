@@ -0,0 +1,377 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// exportOptions is the set of options to cmdExport.
+type exportOptions struct {
+	// dest is the path of the directory to export to, or of a tar file if it
+	// ends in ".tar".
+	dest string
+	// components is the list of components to export.
+	// If empty, all components are exported.
+	components []string
+	// archs is the list of architectures to export, where "source" selects
+	// the source indexes. If empty, all architectures are exported.
+	// Architecture-independent (binary-all) indexes are always exported.
+	archs []string
+}
+
+// cmdExport copies a distribution's Release file, its signatures, the indexes
+// it lists, and every pool file that the indexes reference to a local
+// directory or tar file. Every file is checked against its checksum as it is
+// copied.
+func cmdExport(ctx context.Context, bucket *blob.Bucket, dist distribution, opts exportOptions) (err error) {
+	release, err := downloadReleaseIndex(ctx, bucket, dist)
+	if err != nil {
+		return fmt.Errorf("export %s: %w", dist, err)
+	}
+	if release == nil {
+		return withCode(codeNotFound, fmt.Errorf("export %s: %s not found", dist, dist.indexPath()))
+	}
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
+	if err != nil {
+		return fmt.Errorf("export %s: %s: SHA256: %w", dist, dist.indexPath(), err)
+	}
+	indexes, err := listedIndexes(release)
+	if err != nil {
+		return fmt.Errorf("export %s: %s: %w", dist, dist.indexPath(), err)
+	}
+
+	var dst exportDest
+	if strings.HasSuffix(opts.dest, ".tar") {
+		dst, err = newTarExportDest(opts.dest)
+	} else {
+		dst, err = newDirExportDest(opts.dest)
+	}
+	if err != nil {
+		return fmt.Errorf("export %s: %w", dist, err)
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("export %s: %w", dist, closeErr)
+		}
+		if err != nil {
+			dst.abort()
+		}
+	}()
+
+	for _, key := range []string{dist.indexPath(), dist.signedIndexPath(), dist.indexSignaturePath()} {
+		err := exportObject(ctx, bucket, dst, key, nil)
+		if gcerrors.Code(err) == gcerrors.NotFound && key != dist.indexPath() {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("export %s: %w", dist, err)
+		}
+	}
+	for _, sig := range sigs {
+		if !opts.includesIndex(sig.Filename) {
+			continue
+		}
+		sig := sig
+		if err := exportObject(ctx, bucket, dst, dist.dir()+"/"+sig.Filename, &sig); err != nil {
+			return fmt.Errorf("export %s: %w", dist, err)
+		}
+	}
+	exported := make(map[string]bool)
+	for _, distPath := range indexes {
+		if !opts.includesIndex(distPath) {
+			continue
+		}
+		var files []deb.IndexSignature
+		if slashpath.Base(distPath) == "Sources" {
+			files, err = sourcePoolFiles(ctx, bucket, dist.dir()+"/"+distPath)
+		} else {
+			files, err = binaryPoolFiles(ctx, bucket, dist.dir()+"/"+distPath)
+		}
+		if err != nil {
+			return fmt.Errorf("export %s: %w", dist, err)
+		}
+		for _, sig := range files {
+			if exported[sig.Filename] {
+				continue
+			}
+			exported[sig.Filename] = true
+			sig := sig
+			if len(sig.Checksum) == 0 {
+				// Not listed in the index, so there's nothing to check it against.
+				err = exportObject(ctx, bucket, dst, sig.Filename, nil)
+				if gcerrors.Code(err) == gcerrors.NotFound {
+					continue
+				}
+			} else {
+				err = exportObject(ctx, bucket, dst, sig.Filename, &sig)
+			}
+			if err != nil {
+				return fmt.Errorf("export %s: %w", dist, err)
+			}
+		}
+	}
+	return nil
+}
+
+// includesIndex reports whether the index at distPath (relative to the
+// distribution directory) matches the component and architecture filters.
+func (opts exportOptions) includesIndex(distPath string) bool {
+	parts := strings.Split(distPath, "/")
+	if len(parts) < 2 {
+		return true
+	}
+	if len(opts.components) > 0 && !containsString(opts.components, parts[0]) {
+		return false
+	}
+	if len(opts.archs) == 0 {
+		return true
+	}
+	switch {
+	case parts[1] == "source":
+		return containsString(opts.archs, "source")
+	case parts[1] == "binary-all":
+		return true
+	case strings.HasPrefix(parts[1], "binary-"):
+		return containsString(opts.archs, strings.TrimPrefix(parts[1], "binary-"))
+	default:
+		return true
+	}
+}
+
+// binaryPoolFiles returns the pool files referenced by a Packages index.
+func binaryPoolFiles(ctx context.Context, bucket *blob.Bucket, key string) ([]deb.IndexSignature, error) {
+	packages, err := downloadIndex(ctx, bucket, key, deb.ControlFields)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]deb.IndexSignature, 0, len(packages))
+	for _, para := range packages {
+		var pkg deb.BinaryPackage
+		if err := deb.Unmarshal(para, &pkg); err != nil {
+			return nil, fmt.Errorf("%s: package %s: %w", key, para.Get("Package"), err)
+		}
+		checksum, err := hex.DecodeString(pkg.SHA256)
+		if err != nil || len(checksum) != sha256.Size {
+			return nil, fmt.Errorf("%s: package %s: missing or invalid SHA256", key, pkg.Package)
+		}
+		files = append(files, deb.IndexSignature{
+			Checksum: checksum,
+			Size:     pkg.Size,
+			Filename: pkg.Filename,
+		})
+	}
+	return files, nil
+}
+
+// sourcePoolFiles returns the pool files referenced by a Sources index.
+func sourcePoolFiles(ctx context.Context, bucket *blob.Bucket, key string) ([]deb.IndexSignature, error) {
+	packages, err := downloadIndex(ctx, bucket, key, deb.SourceControlFields)
+	if err != nil {
+		return nil, err
+	}
+	var files []deb.IndexSignature
+	for _, para := range packages {
+		var pkg deb.SourcePackage
+		if err := deb.Unmarshal(para, &pkg); err != nil {
+			return nil, fmt.Errorf("%s: source package %s: %w", key, para.Get("Package"), err)
+		}
+		dir := pkg.Directory
+		sigs := pkg.ChecksumsSha256
+		if len(sigs) == 0 {
+			sigs = pkg.Files
+		}
+		hasDSC := false
+		for _, sig := range sigs {
+			hasDSC = hasDSC || strings.HasSuffix(sig.Filename, ".dsc")
+			sig.Filename = dir + "/" + sig.Filename
+			files = append(files, sig)
+		}
+		if !hasDSC {
+			// aptblob's Sources indexes don't list the .dsc file,
+			// which is named after its directory.
+			files = append(files, deb.IndexSignature{Filename: dir + "/" + slashpath.Base(dir) + ".dsc"})
+		}
+	}
+	return files, nil
+}
+
+// exportObject copies the object at key to dst. If sig is not nil, then the
+// object's content is checked against it.
+func exportObject(ctx context.Context, bucket *blob.Bucket, dst exportDest, key string, sig *deb.IndexSignature) error {
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var content io.Reader = r
+	if sig != nil {
+		content = newCheckedReader(r, *sig)
+	}
+	if err := dst.writeFile(key, r.Size(), r.ModTime(), content); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	reportFrom(ctx).addCopy(key, "", r.Size(), false)
+	return nil
+}
+
+// checkedReader is an io.Reader that returns an error instead of io.EOF if
+// the content it read does not match a signature.
+type checkedReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+	sig  deb.IndexSignature
+}
+
+func newCheckedReader(r io.Reader, sig deb.IndexSignature) *checkedReader {
+	cr := &checkedReader{r: r, sig: sig}
+	if len(sig.Checksum) == md5.Size {
+		cr.hash = md5.New()
+	} else {
+		cr.hash = sha256.New()
+	}
+	return cr
+}
+
+func (cr *checkedReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.hash.Write(p[:n])
+	cr.n += int64(n)
+	if err == io.EOF {
+		if cr.n != cr.sig.Size {
+			return n, fmt.Errorf("size is %d (expected %d)", cr.n, cr.sig.Size)
+		}
+		if sum := cr.hash.Sum(nil); !bytes.Equal(sum, cr.sig.Checksum) {
+			return n, fmt.Errorf("checksum is %x (expected %x)", sum, cr.sig.Checksum)
+		}
+	}
+	return n, err
+}
+
+// exportDest is a destination for exported files.
+type exportDest interface {
+	// writeFile writes a file with the given slash-separated name.
+	writeFile(name string, size int64, modTime time.Time, r io.Reader) error
+	// abort removes any files written after a failed export.
+	// It is called after Close.
+	abort()
+	Close() error
+}
+
+// dirExportDest writes exported files to a directory.
+type dirExportDest struct {
+	dir     string
+	written []string
+}
+
+func newDirExportDest(dir string) (*dirExportDest, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+	return &dirExportDest{dir: dir}, nil
+}
+
+func (d *dirExportDest) writeFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	d.written = append(d.written, path)
+	_, copyErr := io.Copy(f, r)
+	closeErr := f.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Chtimes(path, modTime, modTime)
+}
+
+func (d *dirExportDest) abort() {
+	for _, path := range d.written {
+		os.Remove(path)
+	}
+}
+
+func (d *dirExportDest) Close() error {
+	return nil
+}
+
+// tarExportDest writes exported files to a tar file.
+type tarExportDest struct {
+	f *os.File
+	w *tar.Writer
+}
+
+func newTarExportDest(path string) (*tarExportDest, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tarExportDest{f: f, w: tar.NewWriter(f)}, nil
+}
+
+func (t *tarExportDest) writeFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	err := t.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(t.w, r)
+	return err
+}
+
+func (t *tarExportDest) abort() {
+	os.Remove(t.f.Name())
+}
+
+func (t *tarExportDest) Close() error {
+	err := t.w.Close()
+	if closeErr := t.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
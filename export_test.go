@@ -0,0 +1,160 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	dir, err := ioutil.TempDir("", "aptblob_export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	t.Run("Directory", func(t *testing.T) {
+		dest := filepath.Join(dir, "repo")
+		if err := cmdExport(ctx, bucket, "stable", exportOptions{dest: dest}); err != nil {
+			t.Fatal("export:", err)
+		}
+		for _, name := range []string{
+			"dists/stable/Release",
+			"dists/stable/main/binary-amd64/Packages",
+			"dists/stable/main/source/Sources.gz",
+			"pool/nullpkg_1.0-1_amd64.deb",
+			"pool/nullpkg_1.0-1/nullpkg_1.0-1.dsc",
+			"pool/nullpkg_1.0-1/nullpkg_1.0.orig.tar.gz",
+			"pool/nullpkg_1.0-1/nullpkg_1.0-1.debian.tar.xz",
+		} {
+			if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("Tar", func(t *testing.T) {
+		dest := filepath.Join(dir, "repo.tar")
+		if err := cmdExport(ctx, bucket, "stable", exportOptions{dest: dest, archs: []string{"amd64"}}); err != nil {
+			t.Fatal("export:", err)
+		}
+		f, err := os.Open(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var got []string
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, hdr.Name)
+		}
+		sort.Strings(got)
+		want := []string{
+			"dists/stable/Release",
+			"dists/stable/main/binary-amd64/Packages",
+			"dists/stable/main/binary-amd64/Packages.gz",
+			"pool/nullpkg_1.0-1_amd64.deb",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("tar contents (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		if err := bucket.WriteAll(ctx, poolPath("nullpkg_1.0-1_amd64.deb"), []byte("corrupt"), nil); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(dir, "corrupt.tar")
+		if err := cmdExport(ctx, bucket, "stable", exportOptions{dest: dest}); err == nil {
+			t.Error("export of corrupt package succeeded")
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("after failed export, os.Stat(%q) = _, %v; want not exist", dest, err)
+		}
+	})
+}
+
+func TestPoolFilesLegacyRelations(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	const packages = "Package: foo\n" +
+		"Version: 1.0\n" +
+		"Architecture: all\n" +
+		"Depends: bar (< 1), baz (> 2)\n" +
+		"Filename: pool/foo_1.0_all.deb\n" +
+		"Size: 42\n" +
+		"SHA256: 0000000000000000000000000000000000000000000000000000000000000000\n"
+	const sources = "Package: foo\n" +
+		"Version: 1.0\n" +
+		"Build-Depends: bar (< 1)\n" +
+		"Directory: pool/foo_1.0\n" +
+		"Files:\n" +
+		" 00000000000000000000000000000000 7 foo_1.0.tar.gz\n"
+	if err := bucket.WriteAll(ctx, "Packages", []byte(packages), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.WriteAll(ctx, "Sources", []byte(sources), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	binFiles, err := binaryPoolFiles(ctx, bucket, "Packages")
+	if err != nil {
+		t.Fatal("binaryPoolFiles:", err)
+	}
+	if len(binFiles) != 1 || binFiles[0].Filename != "pool/foo_1.0_all.deb" || binFiles[0].Size != 42 {
+		t.Errorf("binaryPoolFiles(...) = %v; want pool/foo_1.0_all.deb with size 42", binFiles)
+	}
+	srcFiles, err := sourcePoolFiles(ctx, bucket, "Sources")
+	if err != nil {
+		t.Fatal("sourcePoolFiles:", err)
+	}
+	var srcNames []string
+	for _, f := range srcFiles {
+		srcNames = append(srcNames, f.Filename)
+	}
+	want := []string{"pool/foo_1.0/foo_1.0.tar.gz", "pool/foo_1.0/foo_1.0.dsc"}
+	if diff := cmp.Diff(want, srcNames); diff != "" {
+		t.Errorf("sourcePoolFiles(...) filenames (-want +got):\n%s", diff)
+	}
+}
@@ -20,9 +20,11 @@ go 1.15
 
 require (
 	github.com/google/go-cmp v0.4.1
+	github.com/klauspost/compress v1.11.3
 	github.com/laher/argo v0.0.0-20140722103944-11d91c83cc0f
 	github.com/spf13/cobra v1.0.0
 	github.com/ulikunitz/xz v0.5.8
 	gocloud.dev v0.20.0
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,340 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// importDroppedReleaseFields is the set of Release fields that are not copied
+// from an imported Release file, since they describe files that aptblob rewrites
+// or features that it doesn't implement.
+var importDroppedReleaseFields = []string{
+	"MD5Sum",
+	"SHA1",
+	"SHA256",
+	"SHA512",
+	"Acquire-By-Hash",
+}
+
+// cmdImport copies distributions from a repository in a local directory (like
+// one published by reprepro or aptly) into the bucket. If dists is empty, every
+// distribution in the directory is imported. Every index and package is
+// checked against its checksums before it is written, and the indexes are
+// rewritten to point at aptblob's pool layout.
+func cmdImport(ctx context.Context, bucket *blob.Bucket, root string, dists []distribution, keyIDs []string, validFor time.Duration) error {
+	if len(dists) == 0 {
+		infos, err := ioutil.ReadDir(filepath.Join(root, "dists"))
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		for _, info := range infos {
+			if info.IsDir() {
+				dists = append(dists, distribution(info.Name()))
+			}
+		}
+		if len(dists) == 0 {
+			return fmt.Errorf("import: no distributions found in %s", root)
+		}
+	}
+	for _, dist := range dists {
+		if err := importDistribution(ctx, bucket, root, dist, keyIDs, validFor); err != nil {
+			return fmt.Errorf("import %s: %w", dist, err)
+		}
+	}
+	return nil
+}
+
+func importDistribution(ctx context.Context, bucket *blob.Bucket, root string, dist distribution, keyIDs []string, validFor time.Duration) error {
+	if err := checkSigningKeys(ctx, bucket, dist, keyIDs); err != nil {
+		return err
+	}
+	if exists, err := bucket.Exists(ctx, dist.indexPath()); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%s already exists in bucket", dist.indexPath())
+	}
+	distDir := filepath.Join(root, filepath.FromSlash(dist.dir()))
+	release, err := readLocalRelease(distDir)
+	if err != nil {
+		return err
+	}
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
+	if err != nil {
+		return fmt.Errorf("Release: SHA256: %w", err)
+	}
+	if len(sigs) == 0 {
+		return errors.New("Release: no SHA256 checksums")
+	}
+	// Check every file that the Release file lists, even ones that won't be
+	// imported, to catch a stale or corrupt tree early.
+	for _, sig := range sigs {
+		data, err := ioutil.ReadFile(filepath.Join(distDir, filepath.FromSlash(sig.Filename)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkSHA256(data, sig); err != nil {
+			return fmt.Errorf("%s: %w", sig.Filename, err)
+		}
+	}
+	indexes, err := listedIndexes(release)
+	if err != nil {
+		return fmt.Errorf("Release: %w", err)
+	}
+
+	newRelease := make(deb.Paragraph, 0, len(release))
+	for _, f := range release {
+		if !containsString(importDroppedReleaseFields, f.Name) {
+			newRelease = append(newRelease, f)
+		}
+	}
+	for _, distPath := range indexes {
+		fields := deb.ControlFields
+		importParagraph := importBinaryPackage
+		if slashpath.Base(distPath) == "Sources" {
+			fields = deb.SourceControlFields
+			importParagraph = importSourcePackage
+		}
+		packages, err := readLocalIndex(distDir, distPath, sigs, fields)
+		if err != nil {
+			return err
+		}
+		for i := range packages {
+			if err := importParagraph(ctx, bucket, root, &packages[i]); err != nil {
+				return fmt.Errorf("%s: %w", distPath, err)
+			}
+		}
+		hashes, err := uploadIndex(ctx, bucket, dist.dir()+"/"+distPath, packages, listedIndexCompressions(release, distPath))
+		if err != nil {
+			return err
+		}
+		if err := updateIndexSignatures(&newRelease, distPath, hashes); err != nil {
+			return err
+		}
+	}
+
+	if validFor == 0 {
+		validFor = releaseValidity(release)
+	}
+	setReleaseDates(&newRelease, releaseNow(), time.Now(), validFor)
+	return uploadReleaseIndex(ctx, bucket, dist, newRelease, keyIDs)
+}
+
+// readLocalRelease reads the Release file in a local distribution directory,
+// falling back to the InRelease file.
+func readLocalRelease(distDir string) (deb.Paragraph, error) {
+	data, err := ioutil.ReadFile(filepath.Join(distDir, "Release"))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(filepath.Join(distDir, "InRelease"))
+		data = maybeClearSigned(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	release, err := deb.ParseReleaseIndex(bytes.NewReader(data))
+	if err != nil {
+		return nil, withCode(codeParseError, err)
+	}
+	return release, nil
+}
+
+// readLocalIndex parses the first variant of the index at distPath that is
+// listed in sigs and present in distDir.
+func readLocalIndex(distDir string, distPath string, sigs []deb.IndexSignature, fields map[string]deb.FieldType) ([]deb.Paragraph, error) {
+	for _, ext := range indexCompressions {
+		if _, ok := findIndexSignature(sigs, distPath+ext); !ok {
+			continue
+		}
+		f, err := os.Open(filepath.Join(distDir, filepath.FromSlash(distPath+ext)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		packages, err := readIndex(f, ext, fields)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", distPath+ext, err)
+		}
+		return packages, nil
+	}
+	return nil, withCode(codeNotFound, fmt.Errorf("%s: listed in Release but not found", distPath))
+}
+
+// importBinaryPackage uploads the package file referenced by a Packages
+// paragraph and points the paragraph's Filename field at the uploaded object.
+func importBinaryPackage(ctx context.Context, bucket *blob.Bucket, root string, pkg *deb.Paragraph) error {
+	filename := pkg.Get("Filename")
+	path, err := localPoolFile(root, filename)
+	if err != nil {
+		return fmt.Errorf("package %s: %w", pkg.Get("Package"), err)
+	}
+	size, err := strconv.ParseInt(pkg.Get("Size"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("package %s: invalid Size", pkg.Get("Package"))
+	}
+	checksumHex := pkg.Get("SHA256")
+	if checksumHex == "" {
+		checksumHex = pkg.Get("MD5sum")
+	}
+	checksum, err := hex.DecodeString(checksumHex)
+	if err != nil || len(checksum) == 0 {
+		return fmt.Errorf("package %s: missing or invalid checksum", pkg.Get("Package"))
+	}
+	key := poolPath(slashpath.Base(filename))
+	sig := deb.IndexSignature{Checksum: checksum, Size: size, Filename: filename}
+	err = importFile(ctx, bucket, path, key, sig, uploadOptions{
+		contentType:  "application/vnd.debian.binary-package",
+		cacheControl: immutable,
+	})
+	if err != nil {
+		return err
+	}
+	pkg.Set("Filename", key)
+	return nil
+}
+
+// importSourcePackage uploads the files referenced by a Sources paragraph and
+// points the paragraph's Directory field at the uploaded objects.
+func importSourcePackage(ctx context.Context, bucket *blob.Bucket, root string, pkg *deb.Paragraph) error {
+	files, err := deb.ParseIndexSignatures(pkg.Get("Checksums-Sha256"), sha256.Size)
+	if err != nil {
+		return fmt.Errorf("source package %s: Checksums-Sha256: %w", pkg.Get("Package"), err)
+	}
+	if len(files) == 0 {
+		files, err = deb.ParseIndexSignatures(pkg.Get("Files"), md5.Size)
+		if err != nil {
+			return fmt.Errorf("source package %s: Files: %w", pkg.Get("Package"), err)
+		}
+	}
+	for _, sig := range files {
+		if !isPoolFilename(sig.Filename) {
+			return fmt.Errorf("source package %s: invalid file name %q", pkg.Get("Package"), sig.Filename)
+		}
+	}
+	var dir string
+	for _, sig := range files {
+		if strings.HasSuffix(sig.Filename, ".dsc") {
+			dir = poolPath(strings.TrimSuffix(sig.Filename, ".dsc"))
+			break
+		}
+	}
+	if dir == "" {
+		return fmt.Errorf("source package %s: no .dsc file listed", pkg.Get("Package"))
+	}
+	for _, sig := range files {
+		path, err := localPoolFile(root, pkg.Get("Directory")+"/"+sig.Filename)
+		if err != nil {
+			return fmt.Errorf("source package %s: %w", pkg.Get("Package"), err)
+		}
+		contentType := mime.TypeByExtension(slashpath.Ext(sig.Filename))
+		switch {
+		case strings.HasSuffix(sig.Filename, ".dsc"):
+			contentType = "text/plain; charset=utf-8"
+		case contentType == "":
+			contentType = "application/octet-stream"
+		}
+		err = importFile(ctx, bucket, path, dir+"/"+sig.Filename, sig, uploadOptions{
+			contentType:  contentType,
+			cacheControl: immutable,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	pkg.Set("Directory", dir)
+	return nil
+}
+
+// isPoolFilename reports whether name is safe to use in a pool key: a clean,
+// relative path that doesn't refer to a parent directory.
+func isPoolFilename(name string) bool {
+	if name == "" || slashpath.Clean(name) != name || slashpath.IsAbs(name) {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// localPoolFile returns the local path of a file referenced by an index,
+// rejecting paths that would escape the repository root.
+func localPoolFile(root string, name string) (string, error) {
+	cleaned := slashpath.Clean(name)
+	if name == "" || slashpath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return filepath.Join(root, filepath.FromSlash(cleaned)), nil
+}
+
+// importFile checks the file at path against sig, then uploads it to key.
+// The checksum algorithm is inferred from the length of the checksum.
+func importFile(ctx context.Context, bucket *blob.Bucket, path string, key string, sig deb.IndexSignature, opts uploadOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := hashContent(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if h.size != sig.Size {
+		return fmt.Errorf("%s: size is %d (expected %d)", path, h.size, sig.Size)
+	}
+	var sum []byte
+	switch len(sig.Checksum) {
+	case md5.Size:
+		sum = h.md5[:]
+	case sha1.Size:
+		sum = h.sha1[:]
+	case sha256.Size:
+		sum = h.sha256[:]
+	default:
+		return fmt.Errorf("%s: unknown checksum %x", path, sig.Checksum)
+	}
+	if !bytes.Equal(sum, sig.Checksum) {
+		return fmt.Errorf("%s: checksum is %x (expected %x)", path, sum, sig.Checksum)
+	}
+	_, err = upload(ctx, bucket, key, f, opts)
+	return err
+}
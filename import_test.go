@@ -0,0 +1,199 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob/memblob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "aptblob_import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	const poolDir = "pool/main/n/nullpkg"
+	for _, name := range []string{
+		"nullpkg_1.0-1_amd64.deb",
+		"nullpkg_1.0-1.dsc",
+		"nullpkg_1.0.orig.tar.gz",
+		"nullpkg_1.0-1.debian.tar.xz",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(root, filepath.FromSlash(poolDir), name), data)
+	}
+
+	// Write indexes like the ones reprepro produces.
+	debData, err := ioutil.ReadFile(filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	control, err := deb.ExtractControl(bytes.NewReader(debData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := parseTestParagraph(t, control, deb.ControlFields)
+	debSum := sha256.Sum256(debData)
+	binary.Set("Filename", poolDir+"/nullpkg_1.0-1_amd64.deb")
+	binary.Set("Size", strconv.Itoa(len(debData)))
+	binary.Set("SHA256", hex.EncodeToString(debSum[:]))
+	dscData, err := ioutil.ReadFile(filepath.Join("testdata", "nullpkg_1.0-1.dsc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := parseTestParagraph(t, dscData, deb.SourceControlFields)
+	transformSourceControl(&source, poolDir)
+	dscMD5 := md5.Sum(dscData)
+	dscSHA256 := sha256.Sum256(dscData)
+	source.Set("Files", fmt.Sprintf("\n %x %d nullpkg_1.0-1.dsc", dscMD5[:], len(dscData))+source.Get("Files"))
+	source.Set("Checksums-Sha256", fmt.Sprintf("\n %x %d nullpkg_1.0-1.dsc", dscSHA256[:], len(dscData))+source.Get("Checksums-Sha256"))
+
+	release := deb.Paragraph{
+		{Name: "Origin", Value: "Example"},
+		{Name: "Suite", Value: "stable"},
+		{Name: "Components", Value: "main"},
+		{Name: "Architectures", Value: "amd64 source"},
+	}
+	distDir := filepath.Join(root, "dists", "stable")
+	var sha256Sums string
+	for _, index := range []struct {
+		distPath string
+		para     deb.Paragraph
+	}{
+		{"main/binary-amd64/Packages", binary},
+		{"main/source/Sources", source},
+	} {
+		buf := new(bytes.Buffer)
+		if err := deb.Save(buf, []deb.Paragraph{index.para}); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(distDir, filepath.FromSlash(index.distPath)), buf.Bytes())
+		sum := sha256.Sum256(buf.Bytes())
+		sha256Sums += fmt.Sprintf("\n %x %d %s", sum[:], buf.Len(), index.distPath)
+	}
+	release.Set("SHA256", sha256Sums)
+	writeTestFile(t, filepath.Join(distDir, "Release"), []byte(release.String()))
+
+	bucket := memblob.OpenBucket(nil)
+	if err := cmdImport(ctx, bucket, root, nil, nil, 0); err != nil {
+		t.Fatal("import:", err)
+	}
+	for _, name := range []string{
+		"nullpkg_1.0-1_amd64.deb",
+		"nullpkg_1.0-1/nullpkg_1.0-1.dsc",
+		"nullpkg_1.0-1/nullpkg_1.0.orig.tar.gz",
+		"nullpkg_1.0-1/nullpkg_1.0-1.debian.tar.xz",
+	} {
+		if err := checkFile(ctx, bucket, poolPath(name), filepath.Base(name)); err != nil {
+			t.Error(err)
+		}
+	}
+	comp := component{dist: "stable", name: "main"}
+	packages, _, err := listParagraphs(ctx, bucket, comp.binaryIndexPath("amd64"), deb.ControlFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].Get("Filename") != poolPath("nullpkg_1.0-1_amd64.deb") {
+		t.Errorf("imported Packages = %v; want Filename: %s", packages, poolPath("nullpkg_1.0-1_amd64.deb"))
+	}
+	sources, _, err := listParagraphs(ctx, bucket, comp.sourceIndexPath(), deb.SourceControlFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Get("Directory") != poolPath("nullpkg_1.0-1") {
+		t.Errorf("imported Sources = %v; want Directory: %s", sources, poolPath("nullpkg_1.0-1"))
+	}
+	newRelease, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := newRelease.Get("Origin"); got != "Example" {
+		t.Errorf("Origin = %q; want \"Example\"", got)
+	}
+	indexes, err := listedIndexes(newRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main/binary-amd64/Packages", "main/source/Sources"}; fmt.Sprint(indexes) != fmt.Sprint(want) {
+		t.Errorf("Release lists %q; want %q", indexes, want)
+	}
+
+	// A package that doesn't match its checksum must not be imported.
+	writeTestFile(t, filepath.Join(root, filepath.FromSlash(poolDir), "nullpkg_1.0-1_amd64.deb"), append(debData, 0))
+	if err := cmdImport(ctx, memblob.OpenBucket(nil), root, nil, nil, 0); err == nil {
+		t.Error("import with corrupt package succeeded")
+	}
+}
+
+func parseTestParagraph(t *testing.T, data []byte, fields map[string]deb.FieldType) deb.Paragraph {
+	t.Helper()
+	p := deb.NewParser(bytes.NewReader(data))
+	p.Fields = fields
+	if !p.Single() {
+		t.Fatal(p.Err())
+	}
+	return p.Paragraph()
+}
+
+func TestImportSourcePackageRejectsUnsafeNames(t *testing.T) {
+	names := []string{
+		"../nullpkg_1.0-1.dsc",
+		"/nullpkg_1.0-1.dsc",
+		"a/../nullpkg_1.0-1.dsc",
+		"./nullpkg_1.0-1.dsc",
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := memblob.OpenBucket(nil)
+			pkg := deb.Paragraph{
+				{Name: "Package", Value: "nullpkg"},
+				{Name: "Directory", Value: "pool/main/n/nullpkg"},
+				{Name: "Checksums-Sha256", Value: fmt.Sprintf("\n %x 0 %s", sha256.Sum256(nil), name)},
+			}
+			err := importSourcePackage(ctx, bucket, t.TempDir(), &pkg)
+			if err == nil || !strings.Contains(err.Error(), "invalid file name") {
+				t.Fatalf("importSourcePackage(...) = %v; want invalid file name error", err)
+			}
+			keys, err := listKeys(ctx, bucket, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) > 0 {
+				t.Errorf("bucket has objects %q; want none", keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	slashpath "path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/laher/argo/ar"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is a compression format of a member of a binary package.
+type Compression int
+
+// Compression formats.
+const (
+	NoCompression Compression = iota
+	Gzip
+	XZ
+	Zstd
+	Bzip2
+)
+
+// String returns the name of the compression format, like "gzip".
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case XZ:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	case Bzip2:
+		return "bzip2"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// Extension returns the file name extension of the compression format,
+// like ".gz". It returns the empty string for NoCompression.
+func (c Compression) Extension() string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case XZ:
+		return ".xz"
+	case Zstd:
+		return ".zst"
+	case Bzip2:
+		return ".bz2"
+	default:
+		return ""
+	}
+}
+
+// splitMemberName splits the name of a tar member of a binary package
+// (like "data.tar.xz") into its base name (like "data") and compression.
+func splitMemberName(name string) (base string, c Compression, err error) {
+	for _, c := range []Compression{Gzip, XZ, Zstd, Bzip2, NoCompression} {
+		if base := strings.TrimSuffix(name, ".tar"+c.Extension()); base != name {
+			return base, c, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unexpected member %q", name)
+}
+
+// ArchiveMember describes a member of a binary package's ar archive.
+type ArchiveMember struct {
+	// Name is the name of the member, like "data.tar.xz".
+	Name string
+	// Compression is the compression format given by the name's extension.
+	Compression Compression
+	// Size is the size of the member in bytes, as stored in the package.
+	Size int64
+}
+
+// Maintainer script names.
+var maintainerScripts = []string{"preinst", "postinst", "prerm", "postrm", "config"}
+
+// ControlArchive is the content of a binary package's control archive,
+// along with a description of the package's other members.
+// https://www.debian.org/doc/debian-policy/ch-binary.html
+type ControlArchive struct {
+	// Version is the format version from the debian-binary member, like "2.0".
+	Version string
+	// Member describes the control archive member, like "control.tar.xz".
+	Member ArchiveMember
+
+	// Control is the content of the control file.
+	Control []byte
+	// MD5Sums is the content of the md5sums file or nil if not present.
+	MD5Sums []byte
+	// Conffiles is the content of the conffiles file or nil if not present.
+	Conffiles []byte
+	// Shlibs is the content of the shlibs file or nil if not present.
+	Shlibs []byte
+	// Symbols is the content of the symbols file or nil if not present.
+	Symbols []byte
+	// Triggers is the content of the triggers file or nil if not present.
+	Triggers []byte
+	// Scripts maps the names of the maintainer scripts in the control archive
+	// (preinst, postinst, prerm, postrm, and config) to their content.
+	Scripts map[string][]byte
+	// Other maps the names of any other files in the control archive to their
+	// content.
+	Other map[string][]byte
+
+	// Data describes the members after the control archive.
+	// A valid package has exactly one, a data.tar member.
+	Data []ArchiveMember
+}
+
+// ReadControlArchive reads the files in a binary package's control archive.
+// It reads r to the end to list the members after the control archive,
+// but does not decompress them.
+func ReadControlArchive(r io.Reader) (*ControlArchive, error) {
+	arr, version, err := openDeb(r)
+	if err != nil {
+		return nil, fmt.Errorf("read deb control archive: %w", err)
+	}
+	hdr, err := nextMember(arr)
+	if err != nil {
+		return nil, fmt.Errorf("read deb control archive: %w", err)
+	}
+	base, compression, err := splitMemberName(hdr.Name)
+	if err != nil || base != "control" {
+		return nil, fmt.Errorf("read deb control archive: unexpected member %q", hdr.Name)
+	}
+	ca := &ControlArchive{
+		Version: version,
+		Member: ArchiveMember{
+			Name:        hdr.Name,
+			Compression: compression,
+			Size:        hdr.Size,
+		},
+	}
+	err = walkControlArchive(arr, compression, func(name string, tr *tar.Reader) (bool, error) {
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return false, err
+		}
+		ca.setFile(name, data)
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read deb control archive: %s: %w", hdr.Name, err)
+	}
+	if ca.Control == nil {
+		return nil, fmt.Errorf("read deb control archive: %s: does not contain \"control\"", hdr.Name)
+	}
+	for {
+		hdr, err := arr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read deb control archive: %w", err)
+		}
+		if strings.HasPrefix(hdr.Name, "_") {
+			// Reserved for other tools, like the signatures added by dpkg-sig.
+			continue
+		}
+		_, compression, err := splitMemberName(hdr.Name)
+		if err != nil {
+			return nil, fmt.Errorf("read deb control archive: %w", err)
+		}
+		ca.Data = append(ca.Data, ArchiveMember{
+			Name:        hdr.Name,
+			Compression: compression,
+			Size:        hdr.Size,
+		})
+	}
+	return ca, nil
+}
+
+func (ca *ControlArchive) setFile(name string, data []byte) {
+	switch name {
+	case "control":
+		ca.Control = data
+	case "md5sums":
+		ca.MD5Sums = data
+	case "conffiles":
+		ca.Conffiles = data
+	case "shlibs":
+		ca.Shlibs = data
+	case "symbols":
+		ca.Symbols = data
+	case "triggers":
+		ca.Triggers = data
+	default:
+		m := &ca.Other
+		for _, script := range maintainerScripts {
+			if name == script {
+				m = &ca.Scripts
+				break
+			}
+		}
+		if *m == nil {
+			*m = make(map[string][]byte)
+		}
+		(*m)[name] = data
+	}
+}
+
+// openDeb starts reading a binary package, returning the ar reader positioned
+// after the debian-binary member and the format version from that member.
+func openDeb(r io.Reader) (*ar.Reader, string, error) {
+	arr, err := ar.NewReader(r)
+	if err != nil {
+		return nil, "", err
+	}
+	hdr, err := nextMember(arr)
+	if err != nil {
+		return nil, "", err
+	}
+	if hdr.Name != "debian-binary" {
+		return nil, "", errors.New("unknown format")
+	}
+	format, err := ioutil.ReadAll(arr)
+	if err != nil {
+		return nil, "", err
+	}
+	if string(format) != "2.0\n" {
+		return nil, "", fmt.Errorf("unknown format %q", format)
+	}
+	return arr, strings.TrimSuffix(string(format), "\n"), nil
+}
+
+// nextMember advances to the next member of an ar archive,
+// treating the end of the archive as an error.
+func nextMember(arr *ar.Reader) (*ar.Header, error) {
+	hdr, err := arr.Next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// decompress returns a reader for the decompressed content of r.
+func decompress(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case NoCompression:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case XZ:
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xzr), nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case Bzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	default:
+		return nil, fmt.Errorf("%v compression not supported", c)
+	}
+}
+
+// walkControlArchive calls f for each regular file in a control archive with
+// the file's name, stopping early if f returns false.
+func walkControlArchive(r io.Reader, c Compression, f func(name string, tr *tar.Reader) (bool, error)) error {
+	dr, err := decompress(r, c)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := slashpath.Clean(hdr.Name)
+		if cont, err := f(name, tr); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		} else if !cont {
+			return nil
+		}
+	}
+}
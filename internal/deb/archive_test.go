@@ -0,0 +1,218 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/laher/argo/ar"
+)
+
+const testDebPath = "../../testdata/nullpkg_1.0-1_amd64.deb"
+
+func TestReadControlArchive(t *testing.T) {
+	t.Run("Testdata", func(t *testing.T) {
+		f, err := os.Open(filepath.FromSlash(testDebPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		ca, err := ReadControlArchive(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ca.Version != "2.0" {
+			t.Errorf("Version = %q; want \"2.0\"", ca.Version)
+		}
+		if want := (ArchiveMember{Name: "control.tar.xz", Compression: XZ, Size: 484}); ca.Member != want {
+			t.Errorf("Member = %+v; want %+v", ca.Member, want)
+		}
+		if !bytes.HasPrefix(ca.Control, []byte("Package: nullpkg\n")) {
+			t.Errorf("Control = %q; want to start with Package: nullpkg", ca.Control)
+		}
+		const wantMD5Sums = "c96512b7e1438e4cab094c7d7ff80a04  usr/share/doc/nullpkg/changelog.Debian.gz\n" +
+			"d41d8cd98f00b204e9800998ecf8427e  usr/share/doc/nullpkg/copyright\n"
+		if string(ca.MD5Sums) != wantMD5Sums {
+			t.Errorf("MD5Sums = %q; want %q", ca.MD5Sums, wantMD5Sums)
+		}
+		if ca.Conffiles != nil || ca.Scripts != nil || ca.Other != nil {
+			t.Errorf("Conffiles = %q, Scripts = %q, Other = %q; want nil", ca.Conffiles, ca.Scripts, ca.Other)
+		}
+		wantData := []ArchiveMember{{Name: "data.tar.xz", Compression: XZ, Size: 448}}
+		if diff := cmp.Diff(wantData, ca.Data); diff != "" {
+			t.Errorf("Data (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("AllFiles", func(t *testing.T) {
+		files := map[string]string{
+			"./control":   "Package: foo\n",
+			"./md5sums":   "d41d8cd98f00b204e9800998ecf8427e  etc/foo.conf\n",
+			"./conffiles": "/etc/foo.conf\n",
+			"./shlibs":    "libfoo 1 libfoo1\n",
+			"./symbols":   "libfoo.so.1 libfoo1 #MINVER#\n",
+			"./triggers":  "activate-noawait ldconfig\n",
+			"./postinst":  "#!/bin/sh\nset -e\n",
+			"./prerm":     "#!/bin/sh\n",
+			"./templates": "Template: foo/question\n",
+		}
+		deb := buildTestDeb(t, files, "data.tar.zst", nil)
+		ca, err := ReadControlArchive(bytes.NewReader(deb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{
+			"./control":   string(ca.Control),
+			"./md5sums":   string(ca.MD5Sums),
+			"./conffiles": string(ca.Conffiles),
+			"./shlibs":    string(ca.Shlibs),
+			"./symbols":   string(ca.Symbols),
+			"./triggers":  string(ca.Triggers),
+		}
+		for name, data := range ca.Scripts {
+			got["./"+name] = string(data)
+		}
+		for name, data := range ca.Other {
+			got["./"+name] = string(data)
+		}
+		if diff := cmp.Diff(files, got); diff != "" {
+			t.Errorf("files (-want +got):\n%s", diff)
+		}
+		if ca.Member.Compression != Gzip {
+			t.Errorf("Member.Compression = %v; want %v", ca.Member.Compression, Gzip)
+		}
+		if len(ca.Data) != 1 || ca.Data[0].Name != "data.tar.zst" || ca.Data[0].Compression != Zstd {
+			t.Errorf("Data = %+v; want data.tar.zst with %v compression", ca.Data, Zstd)
+		}
+	})
+
+	t.Run("SignatureMember", func(t *testing.T) {
+		deb := buildTestDeb(t, map[string]string{"./control": "Package: foo\n"}, "data.tar.xz", nil)
+		deb = appendArMember(t, deb, "_gpgorigin", []byte("-----BEGIN PGP SIGNATURE-----\n"))
+		ca, err := ReadControlArchive(bytes.NewReader(deb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ca.Data) != 1 || ca.Data[0].Name != "data.tar.xz" {
+			t.Errorf("Data = %+v; want only data.tar.xz", ca.Data)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			deb  []byte
+		}{
+			{"Empty", nil},
+			{"NoControl", buildTestDeb(t, map[string]string{"./md5sums": ""}, "data.tar.xz", nil)},
+			{"BadDataMember", buildTestDeb(t, map[string]string{"./control": "Package: foo\n"}, "data.zip", nil)},
+		}
+		for _, test := range tests {
+			if _, err := ReadControlArchive(bytes.NewReader(test.deb)); err == nil {
+				t.Errorf("%s: ReadControlArchive did not return an error", test.name)
+			}
+		}
+	})
+}
+
+// buildTestDeb returns a binary package with a gzip-compressed control
+// archive containing the given files and a data member with the given name
+// and content. If data is nil, the data member's content is not a valid
+// archive.
+func buildTestDeb(t *testing.T, controlFiles map[string]string, dataName string, data []byte) []byte {
+	t.Helper()
+	control := new(bytes.Buffer)
+	zw := gzip.NewWriter(control)
+	tw := tar.NewWriter(zw)
+	for name, content := range controlFiles {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data == nil {
+		data = []byte(strings.Repeat("\x00", 7))
+	}
+	deb := new(bytes.Buffer)
+	aw := ar.NewWriter(deb)
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", control.Bytes()},
+		{dataName, data},
+	}
+	for _, m := range members {
+		err := aw.WriteHeader(&ar.Header{
+			Name:    m.name,
+			ModTime: time.Unix(0, 0),
+			Mode:    644,
+			Size:    int64(len(m.data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := aw.Write(m.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return deb.Bytes()
+}
+
+// appendArMember appends a member to the end of an ar archive, like dpkg-sig
+// does with its signatures.
+func appendArMember(t *testing.T, archive []byte, name string, data []byte) []byte {
+	t.Helper()
+	if len(name) > 16 {
+		t.Fatalf("ar member name %q too long", name)
+	}
+	buf := bytes.NewBuffer(append([]byte(nil), archive...))
+	fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, 0, 0, 0, "100644", len(data))
+	buf.Write(data)
+	if len(data)%2 != 0 {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
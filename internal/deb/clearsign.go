@@ -0,0 +1,123 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// ErrNotClearSigned is returned by VerifyClearSigned
+// if the data is not an OpenPGP clear-signed message.
+var ErrNotClearSigned = errors.New("not clear-signed")
+
+// VerifyClearSigned checks that data is an OpenPGP clear-signed message
+// (like an InRelease or .dsc file) signed by a key in the keyring at
+// keyringPath. It returns the signed text and the fingerprint of the signer's
+// primary key. The keyring may be either binary or ASCII-armored.
+//
+// The golang.org/x/crypto/openpgp package can't check signatures from newer
+// key types, so VerifyClearSigned runs gpgv, which must be installed.
+func VerifyClearSigned(ctx context.Context, data []byte, keyringPath string) (plaintext []byte, fingerprint string, err error) {
+	if block, _ := clearsign.Decode(data); block == nil {
+		return nil, "", fmt.Errorf("verify signature: %w", ErrNotClearSigned)
+	}
+	keyring, err := ioutil.ReadFile(keyringPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("verify signature: %w", err)
+	}
+	// gpgv only reads binary keyrings.
+	if block, err := armor.Decode(bytes.NewReader(keyring)); err == nil {
+		keyring, err = ioutil.ReadAll(block.Body)
+		if err != nil {
+			return nil, "", fmt.Errorf("verify signature: %s: %w", keyringPath, err)
+		}
+	}
+	f, err := ioutil.TempFile("", "aptblob_keyring*.gpg")
+	if err != nil {
+		return nil, "", fmt.Errorf("verify signature: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, writeErr := f.Write(keyring)
+	closeErr := f.Close()
+	if writeErr != nil {
+		return nil, "", fmt.Errorf("verify signature: %w", writeErr)
+	}
+	if closeErr != nil {
+		return nil, "", fmt.Errorf("verify signature: %w", closeErr)
+	}
+
+	// Status lines are written to file descriptor 3.
+	statusReader, statusWriter, err := os.Pipe()
+	if err != nil {
+		return nil, "", fmt.Errorf("verify signature: %w", err)
+	}
+	defer statusReader.Close()
+	c := exec.CommandContext(ctx, "gpgv", "--quiet", "--status-fd", "3", "--keyring", f.Name(), "--output", "-", "-")
+	c.Stdin = bytes.NewReader(data)
+	out := new(bytes.Buffer)
+	c.Stdout = out
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	c.ExtraFiles = []*os.File{statusWriter}
+	if err := c.Start(); err != nil {
+		statusWriter.Close()
+		return nil, "", fmt.Errorf("verify signature: %w", err)
+	}
+	statusWriter.Close()
+	status, readErr := ioutil.ReadAll(statusReader)
+	if err := c.Wait(); err != nil {
+		return nil, "", fmt.Errorf("verify signature: %w\n%s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if readErr != nil {
+		return nil, "", fmt.Errorf("verify signature: read status: %w", readErr)
+	}
+	fingerprint = validSignature(status)
+	if fingerprint == "" {
+		return nil, "", errors.New("verify signature: gpgv did not report a valid signature")
+	}
+	return out.Bytes(), fingerprint, nil
+}
+
+// validSignature returns the primary key fingerprint from the first VALIDSIG
+// line in gpgv's status output or the empty string if there is none.
+func validSignature(status []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(status))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		// VALIDSIG's arguments are the signing key's fingerprint followed by
+		// signature details, ending with the primary key's fingerprint.
+		if len(fields) >= 12 {
+			return fields[11]
+		}
+		return fields[2]
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestValidSignature(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{status: "", want: ""},
+		{
+			status: "[GNUPG:] NEWSIG\n" +
+				"[GNUPG:] GOODSIG 1234567890ABCDEF Jane Doe <jane@example.com>\n" +
+				"[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 2020-08-01 1596240000 0 4 0 22 8 01 FEDCBA9876543210FEDCBA9876543210FEDCBA98\n",
+			want: "FEDCBA9876543210FEDCBA9876543210FEDCBA98",
+		},
+		{
+			status: "[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567\n",
+			want:   "0123456789ABCDEF0123456789ABCDEF01234567",
+		},
+		{
+			status: "[GNUPG:] ERRSIG 1234567890ABCDEF 22 8 01 1596240000 9\n" +
+				"[GNUPG:] NO_PUBKEY 1234567890ABCDEF\n",
+			want: "",
+		},
+	}
+	for _, test := range tests {
+		if got := validSignature([]byte(test.status)); got != test.want {
+			t.Errorf("validSignature(%q) = %q; want %q", test.status, got, test.want)
+		}
+	}
+}
+
+func TestVerifyClearSignedUnsigned(t *testing.T) {
+	_, _, err := VerifyClearSigned(context.Background(), []byte("Source: nullpkg\n"), "keyring.gpg")
+	if !errors.Is(err, ErrNotClearSigned) {
+		t.Errorf("VerifyClearSigned(unsigned) = _, _, %v; want %v", err, ErrNotClearSigned)
+	}
+}
@@ -27,53 +27,71 @@ import (
 
 // A Parser reads fields from a control file.
 // The syntax is documented at https://www.debian.org/doc/debian-policy/ch-controlfields.html#syntax-of-control-files
+//
+// Paragraphs and lines may be of any length: the parser holds one paragraph
+// in memory at a time, so it can read indexes larger than memory.
 type Parser struct {
 	// Fields specifies the type of possible fields.
 	Fields map[string]FieldType
 
-	scan   *bufio.Scanner
+	r      *bufio.Reader
 	lineno int
+	buf    []byte
 	para   Paragraph
 	err    error
 }
 
 // NewParser returns a new parser that reads from r.
 func NewParser(r io.Reader) *Parser {
-	p := &Parser{
-		scan:   bufio.NewScanner(r),
+	return &Parser{
+		r:      bufio.NewReader(r),
 		lineno: 1,
 	}
-	// Split by paragraph.
-	p.scan.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		for advance < len(data) {
-			start := advance
-			var line []byte
-			if i := bytes.IndexByte(data[advance:], '\n'); i != -1 {
-				line = data[advance : advance+i]
-				advance += i + 1
-			} else if atEOF {
-				line = data[advance:]
-				advance = len(data)
-			} else {
-				// Not enough buffered for complete line.
-				return 0, nil, nil
+}
+
+// readParagraph reads the next paragraph into p.buf without its trailing
+// newline, skipping any empty lines before it. It returns false if there are
+// no more paragraphs. sep is true if the paragraph ended in an empty line,
+// which has been consumed.
+func (p *Parser) readParagraph() (ok bool, sep bool, err error) {
+	p.buf = p.buf[:0]
+	for {
+		start := len(p.buf)
+		atEOF := false
+		for {
+			chunk, err := p.r.ReadSlice('\n')
+			p.buf = append(p.buf, chunk...)
+			if err == bufio.ErrBufferFull {
+				// Line is longer than the reader's buffer. Keep reading.
+				continue
+			}
+			if err == io.EOF {
+				atEOF = true
+				break
 			}
-			if isEmptyLine(line) {
-				if token == nil {
-					// Advance lineno for leading empty lines.
-					p.lineno++
-				}
-				return
+			if err != nil {
+				return false, false, err
 			}
-			token = data[:start+len(line)]
+			break
 		}
-		if !atEOF {
-			// Not enough buffered.
-			return 0, nil, nil
+		if isEmptyLine(p.buf[start:]) {
+			p.buf = p.buf[:start]
+			if start > 0 {
+				p.buf = bytes.TrimSuffix(p.buf, []byte{'\n'})
+				return true, true, nil
+			}
+			if atEOF {
+				return false, false, nil
+			}
+			// Advance lineno for leading empty lines.
+			p.lineno++
+			continue
 		}
-		return
-	})
-	return p
+		if atEOF {
+			p.buf = bytes.TrimSuffix(p.buf, []byte{'\n'})
+			return true, false, nil
+		}
+	}
 }
 
 // Single parses a single-paragraph control file, which will then be available
@@ -98,14 +116,15 @@ func (p *Parser) Single() bool {
 	}
 
 	// Check for trailing data.
-	if p.scan.Scan() {
+	more, _, err := p.readParagraph()
+	if err != nil {
 		p.clear()
-		p.err = fmt.Errorf("parse debian control file: line %d: multiple paragraphs encountered", p.lineno)
+		p.err = fmt.Errorf("parse debian control file: line %d: %w", p.lineno, err)
 		return false
 	}
-	if err := p.scan.Err(); err != nil {
+	if more {
 		p.clear()
-		p.err = fmt.Errorf("parse debian control file: line %d: %w", p.lineno, err)
+		p.err = fmt.Errorf("parse debian control file: line %d: multiple paragraphs encountered", p.lineno)
 		return false
 	}
 	return true
@@ -119,13 +138,15 @@ func (p *Parser) Next() bool {
 		return false
 	}
 	p.clear()
-	if !p.scan.Scan() {
-		if err := p.scan.Err(); err != nil {
-			p.err = fmt.Errorf("parse debian control file: line %d: %w", p.lineno, err)
-		}
+	ok, sep, err := p.readParagraph()
+	if err != nil {
+		p.err = fmt.Errorf("parse debian control file: line %d: %w", p.lineno, err)
+		return false
+	}
+	if !ok {
 		return false
 	}
-	text := p.scan.Text()
+	text := string(p.buf)
 	for len(text) > 0 {
 		valueEnd := len(text)
 		if i := strings.IndexByte(text, '\n'); i != -1 {
@@ -201,6 +222,9 @@ func (p *Parser) Next() bool {
 		text = strings.TrimPrefix(text[valueEnd:], "\n")
 		p.lineno++
 	}
+	if sep {
+		p.lineno++
+	}
 	return true
 }
 
@@ -219,6 +219,85 @@ func TestParser(t *testing.T) {
 	})
 }
 
+func TestParserLargeParagraphs(t *testing.T) {
+	// Each paragraph is much larger than bufio.Scanner's default limit of
+	// 64 KiB, and the Package field is a single line longer than the parser's
+	// read buffer.
+	longName := strings.Repeat("x", 1<<20)
+	description := new(strings.Builder)
+	description.WriteString("Long description")
+	for description.Len() < 4<<20 {
+		description.WriteString("\n ")
+		description.WriteString(strings.Repeat("lorem ipsum ", 10) + "dolor")
+	}
+	want := []Paragraph{
+		{
+			{Name: "Package", Value: longName},
+			{Name: "Description", Value: description.String()},
+		},
+		{
+			{Name: "Package", Value: "short"},
+		},
+		{
+			{Name: "Package", Value: longName + "2"},
+			{Name: "Description", Value: description.String()},
+		},
+	}
+	source := new(strings.Builder)
+	if err := Save(source, want); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewParser(strings.NewReader(source.String()))
+	p.Fields = map[string]FieldType{"Description": Multiline}
+	var got []Paragraph
+	for p.Next() {
+		got = append(got, append(Paragraph(nil), p.Paragraph()...))
+	}
+	if err := p.Err(); err != nil {
+		t.Error("Err() =", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		// The full diff would be megabytes.
+		t.Errorf("got %d paragraphs that differ from the %d saved", len(got), len(want))
+	}
+}
+
+func TestParserErrorLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "FirstParagraph",
+			source: "Package: libc6\nbogus\n",
+			want:   "line 2:",
+		},
+		{
+			name:   "SecondParagraph",
+			source: "Package: libc6\n\nPackage: git\nbogus\n",
+			want:   "line 4:",
+		},
+		{
+			name:   "ExtraSeparators",
+			source: "\nPackage: libc6\n\n\n\nbogus\n",
+			want:   "line 6:",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewParser(strings.NewReader(test.source))
+			for p.Next() {
+			}
+			err := p.Err()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Err() = %v; want error at %s", err, strings.TrimSuffix(test.want, ":"))
+			}
+		})
+	}
+}
+
 func TestSave(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -18,103 +18,123 @@ package deb
 
 import (
 	"archive/tar"
-	"compress/gzip"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	slashpath "path"
-
-	"github.com/laher/argo/ar"
-	"github.com/ulikunitz/xz"
 )
 
 // ExtractControl reads the control file from a binary package.
+// It stops reading once it finds the control file.
+// Use ReadControlArchive to read the other files in the control archive.
 func ExtractControl(r io.Reader) ([]byte, error) {
-	arr, err := ar.NewReader(r)
+	arr, _, err := openDeb(r)
 	if err != nil {
 		return nil, fmt.Errorf("extract deb control: %w", err)
 	}
-
-	hdr, err := arr.Next()
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			err = io.ErrUnexpectedEOF
-		}
-		return nil, fmt.Errorf("extract deb control: %w", err)
-	}
-	if hdr.Name != "debian-binary" {
-		return nil, fmt.Errorf("extract deb control: unknown format")
-	}
-	format, err := ioutil.ReadAll(arr)
+	hdr, err := nextMember(arr)
 	if err != nil {
 		return nil, fmt.Errorf("extract deb control: %w", err)
 	}
-	if string(format) != "2.0\n" {
-		return nil, fmt.Errorf("extract deb control: unknown format %q", format)
+	base, compression, err := splitMemberName(hdr.Name)
+	if err != nil || base != "control" {
+		return nil, fmt.Errorf("extract deb control: unexpected member %q", hdr.Name)
 	}
-
-	hdr, err = arr.Next()
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			err = io.ErrUnexpectedEOF
+	var data []byte
+	err = walkControlArchive(arr, compression, func(name string, tr *tar.Reader) (bool, error) {
+		if name != "control" {
+			return true, nil
 		}
-		return nil, fmt.Errorf("extract deb control: %w", err)
-	}
-	var controlReader io.ReadCloser
-	switch hdr.Name {
-	case "control.tar":
-		controlReader = ioutil.NopCloser(arr)
-	case "control.tar.gz":
 		var err error
-		controlReader, err = gzip.NewReader(arr)
-		if err != nil {
-			return nil, fmt.Errorf("extract deb control: control.tar.gz: %w", err)
-		}
-	case "control.tar.xz":
-		xzr, err := xz.NewReader(arr)
-		if err != nil {
-			return nil, fmt.Errorf("extract deb control: control.tar.xz: %w", err)
-		}
-		controlReader = ioutil.NopCloser(xzr)
-	default:
-		return nil, fmt.Errorf("extract deb control: unexpected member %q", hdr.Name)
+		data, err = ioutil.ReadAll(tr)
+		return false, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extract deb control: %s: %w", hdr.Name, err)
 	}
-	controlArchiveName := hdr.Name
-	defer controlReader.Close()
-
-	tarr := tar.NewReader(controlReader)
-	for {
-		hdr, err := tarr.Next()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil, fmt.Errorf("extract deb control: %s: does not contain \"control\"", controlArchiveName)
-			}
-			return nil, fmt.Errorf("extract deb control: %s: %w", controlArchiveName, err)
-		}
-		name := slashpath.Clean(hdr.Name)
-		if name == "control" {
-			data, err := ioutil.ReadAll(tarr)
-			if err != nil {
-				return nil, fmt.Errorf("extract deb control: %s: control: %w", controlArchiveName, err)
-			}
-			return data, nil
-		}
+	if data == nil {
+		return nil, fmt.Errorf("extract deb control: %s: does not contain \"control\"", hdr.Name)
 	}
+	return data, nil
 }
 
 // ControlFields is the set of fields in the binary package control file.
-var ControlFields = map[string]FieldType{
-	"Description": Multiline,
-}
+var ControlFields = FieldTypes(BinaryPackage{})
 
 // SourceControlFields is the set of fields in the source package control file.
-var SourceControlFields = map[string]FieldType{
-	"Binary":           Folded,
-	"Checksums-Sha1":   Multiline,
-	"Checksums-Sha256": Multiline,
-	"Dgit":             Folded,
-	"Files":            Multiline,
-	"Package-List":     Multiline,
-	"Uploaders":        Folded,
+var SourceControlFields = FieldTypes(SourcePackage{})
+
+// BinaryPackage is a binary package control file or a paragraph in a Packages
+// index. It can be used with Marshal and Unmarshal.
+// https://www.debian.org/doc/debian-policy/ch-controlfields.html#binary-package-control-files-debian-control
+type BinaryPackage struct {
+	Package       string    `deb:"Package"`
+	Source        string    `deb:"Source"`
+	Version       string    `deb:"Version"`
+	Architecture  string    `deb:"Architecture"`
+	Essential     string    `deb:"Essential"`
+	MultiArch     string    `deb:"Multi-Arch"`
+	Maintainer    string    `deb:"Maintainer"`
+	InstalledSize int64     `deb:"Installed-Size"`
+	PreDepends    Relations `deb:"Pre-Depends,folded"`
+	Depends       Relations `deb:"Depends,folded"`
+	Recommends    Relations `deb:"Recommends,folded"`
+	Suggests      Relations `deb:"Suggests,folded"`
+	Enhances      Relations `deb:"Enhances,folded"`
+	Breaks        Relations `deb:"Breaks,folded"`
+	Conflicts     Relations `deb:"Conflicts,folded"`
+	Provides      Relations `deb:"Provides,folded"`
+	Replaces      Relations `deb:"Replaces,folded"`
+	BuiltUsing    Relations `deb:"Built-Using,folded"`
+	Section       string    `deb:"Section"`
+	Priority      string    `deb:"Priority"`
+	Homepage      string    `deb:"Homepage"`
+	Description   string    `deb:"Description,multiline"`
+
+	// Fields added by Packages indexes.
+	Filename string `deb:"Filename"`
+	Size     int64  `deb:"Size"`
+	MD5sum   string `deb:"MD5sum"`
+	SHA1     string `deb:"SHA1"`
+	SHA256   string `deb:"SHA256"`
+
+	// Rest holds any other fields.
+	Rest Paragraph `deb:",rest"`
+}
+
+// SourcePackage is a source package control (.dsc) file or a paragraph in a
+// Sources index. In a .dsc file, the package name is in a Source field, which
+// Unmarshal puts in Rest.
+// https://www.debian.org/doc/debian-policy/ch-controlfields.html#debian-source-control-files-dsc
+type SourcePackage struct {
+	Package             string           `deb:"Package"`
+	Format              string           `deb:"Format"`
+	Binary              []string         `deb:"Binary,folded,comma"`
+	Architecture        []string         `deb:"Architecture"`
+	Version             string           `deb:"Version"`
+	Maintainer          string           `deb:"Maintainer"`
+	Uploaders           []string         `deb:"Uploaders,folded,comma"`
+	Homepage            string           `deb:"Homepage"`
+	VcsBrowser          string           `deb:"Vcs-Browser"`
+	VcsGit              string           `deb:"Vcs-Git"`
+	Dgit                string           `deb:"Dgit,folded"`
+	StandardsVersion    string           `deb:"Standards-Version"`
+	BuildDepends        Relations        `deb:"Build-Depends,folded"`
+	BuildDependsIndep   Relations        `deb:"Build-Depends-Indep,folded"`
+	BuildDependsArch    Relations        `deb:"Build-Depends-Arch,folded"`
+	BuildConflicts      Relations        `deb:"Build-Conflicts,folded"`
+	BuildConflictsIndep Relations        `deb:"Build-Conflicts-Indep,folded"`
+	BuildConflictsArch  Relations        `deb:"Build-Conflicts-Arch,folded"`
+	Testsuite           string           `deb:"Testsuite"`
+	PackageList         string           `deb:"Package-List,multiline"`
+	ChecksumsSha1       []IndexSignature `deb:"Checksums-Sha1,multiline"`
+	ChecksumsSha256     []IndexSignature `deb:"Checksums-Sha256,multiline"`
+	Files               []IndexSignature `deb:"Files,multiline"`
+
+	// Fields added by Sources indexes.
+	Directory string `deb:"Directory"`
+	Priority  string `deb:"Priority"`
+	Section   string `deb:"Section"`
+
+	// Rest holds any other fields.
+	Rest Paragraph `deb:",rest"`
 }
@@ -0,0 +1,281 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Document is a control file that can be edited and written back without
+// reformatting it. Unlike Parser, ParseDocument keeps comments, blank lines,
+// whitespace, line folding, and the spelling of field names, so that
+// ParseDocument followed by Save writes the same bytes, and Set only changes
+// the lines of the field it sets. It is meant for files that people edit,
+// like debian/control.
+type Document struct {
+	Paragraphs []*DocumentParagraph
+
+	// trailer is the blank and comment lines after the last paragraph.
+	trailer []string
+	// noFinalNewline is true if the last line was not terminated.
+	noFinalNewline bool
+}
+
+// DocumentParagraph is a paragraph in a Document.
+type DocumentParagraph struct {
+	// leading is the blank and comment lines before the paragraph.
+	leading []string
+	fields  []*documentField
+	// trailing is the comment lines after the last field of the paragraph.
+	trailing []string
+}
+
+// documentField is a field in a DocumentParagraph.
+type documentField struct {
+	// comments is the comment lines before the field.
+	comments []string
+	// name is the name of the field as spelled in the file.
+	name string
+	// lines is the lines of the field, including any comment lines between
+	// its continuation lines.
+	lines []string
+}
+
+// ParseDocument parses a control file into a Document.
+func ParseDocument(r io.Reader) (*Document, error) {
+	doc := new(Document)
+	br := bufio.NewReader(r)
+	var para *DocumentParagraph
+	var field *documentField
+	var pending []string // blank and comment lines not yet assigned
+	for lineno := 1; ; lineno++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parse debian control file: line %d: %w", lineno, err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		if !strings.HasSuffix(line, "\n") {
+			doc.noFinalNewline = true
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case isEmptyLine([]byte(line)):
+			if para != nil {
+				para.trailing = pending
+				pending = nil
+			}
+			para, field = nil, nil
+			pending = append(pending, line)
+		case line[0] == '#':
+			pending = append(pending, line)
+		case line[0] == ' ' || line[0] == '\t':
+			if field == nil {
+				return nil, fmt.Errorf("parse debian control file: line %d: continuation line outside of field", lineno)
+			}
+			field.lines = append(field.lines, pending...)
+			field.lines = append(field.lines, line)
+			pending = nil
+		default:
+			colon := strings.IndexByte(line, ':')
+			if colon == -1 {
+				return nil, fmt.Errorf("parse debian control file: line %d: missing colon", lineno)
+			}
+			name := line[:colon]
+			if err := validateFieldName(name); err != nil {
+				return nil, fmt.Errorf("parse debian control file: line %d: %w", lineno, err)
+			}
+			if para == nil {
+				para = &DocumentParagraph{leading: pending}
+				pending = nil
+				doc.Paragraphs = append(doc.Paragraphs, para)
+			} else if para.find(name) != -1 {
+				return nil, fmt.Errorf("parse debian control file: line %d: multiple fields for %q", lineno, name)
+			}
+			field = &documentField{comments: pending, name: name, lines: []string{line}}
+			pending = nil
+			para.fields = append(para.fields, field)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if para != nil {
+		para.trailing = pending
+	} else {
+		doc.trailer = pending
+	}
+	return doc, nil
+}
+
+// Save writes the document to w.
+func (doc *Document) Save(w io.Writer) error {
+	var lines []string
+	for _, para := range doc.Paragraphs {
+		lines = append(lines, para.leading...)
+		for _, f := range para.fields {
+			lines = append(lines, f.comments...)
+			lines = append(lines, f.lines...)
+		}
+		lines = append(lines, para.trailing...)
+	}
+	lines = append(lines, doc.trailer...)
+	if len(lines) == 0 {
+		return nil
+	}
+	text := strings.Join(lines, "\n")
+	if !doc.noFinalNewline {
+		text += "\n"
+	}
+	if _, err := io.WriteString(w, text); err != nil {
+		return fmt.Errorf("save debian control file: %w", err)
+	}
+	return nil
+}
+
+// Append adds a paragraph with the given fields to the end of the document
+// and returns it.
+func (doc *Document) Append(fields Paragraph) *DocumentParagraph {
+	// Lines after the last paragraph now precede the new one,
+	// followed by a blank line if they don't already end with one.
+	para := &DocumentParagraph{leading: doc.trailer}
+	doc.trailer = nil
+	if n := len(para.leading); n > 0 && !isEmptyLine([]byte(para.leading[n-1])) || n == 0 && len(doc.Paragraphs) > 0 {
+		para.leading = append(para.leading, "")
+	}
+	for _, f := range fields {
+		para.Set(f.Name, f.Value)
+	}
+	doc.Paragraphs = append(doc.Paragraphs, para)
+	// Keep the appended paragraph's final newline.
+	doc.noFinalNewline = false
+	return para
+}
+
+func (para *DocumentParagraph) find(name string) int {
+	for i, f := range para.fields {
+		if strings.EqualFold(f.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of the named field or the empty string if the field
+// is not present in the paragraph. Field names are matched
+// case-insensitively. The value is trimmed and any continuation lines are
+// kept, as for a Multiline field, but comment lines are removed.
+func (para *DocumentParagraph) Get(name string) string {
+	i := para.find(name)
+	if i == -1 {
+		return ""
+	}
+	return para.fields[i].value()
+}
+
+func (f *documentField) value() string {
+	sb := new(strings.Builder)
+	sb.WriteString(f.lines[0][len(f.name)+1:])
+	for _, line := range f.lines[1:] {
+		if line[0] == '#' {
+			continue
+		}
+		sb.WriteString("\n")
+		sb.WriteString(line)
+	}
+	return strings.Trim(sb.String(), " \t")
+}
+
+// Set sets the value of the named field. If the field is present, then only
+// its lines are replaced, keeping its position, the comments before it, and
+// the spelling of its name. Otherwise, the field is appended to the
+// paragraph.
+func (para *DocumentParagraph) Set(name, value string) {
+	if i := para.find(name); i != -1 {
+		f := para.fields[i]
+		f.lines = strings.Split(Field{Name: f.name, Value: value}.String(), "\n")
+		return
+	}
+	para.fields = append(para.fields, &documentField{
+		name:  name,
+		lines: strings.Split(Field{Name: name, Value: value}.String(), "\n"),
+	})
+}
+
+// Delete removes the named field and the comments before it from the
+// paragraph. It does nothing if the field is not present.
+func (para *DocumentParagraph) Delete(name string) {
+	if i := para.find(name); i != -1 {
+		para.fields = append(para.fields[:i], para.fields[i+1:]...)
+	}
+}
+
+// Names returns the names of the fields in the paragraph as they are spelled.
+func (para *DocumentParagraph) Names() []string {
+	names := make([]string, 0, len(para.fields))
+	for _, f := range para.fields {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// Paragraph returns the fields of the paragraph. Field values are
+// interpreted according to types, as Parser does with its Fields, except that
+// field names are matched case-insensitively.
+func (para *DocumentParagraph) Paragraph(types map[string]FieldType) (Paragraph, error) {
+	p := make(Paragraph, 0, len(para.fields))
+	for _, f := range para.fields {
+		value := f.value()
+		switch lookupFieldType(types, f.name) {
+		case Simple:
+			if strings.Contains(value, "\n") {
+				return nil, fmt.Errorf("field %q must be a single line", f.name)
+			}
+		case Folded:
+			value = strings.ReplaceAll(value, "\n", "")
+		}
+		if value == "" {
+			return nil, fmt.Errorf("empty field %q", f.name)
+		}
+		p = append(p, Field{Name: f.name, Value: value})
+	}
+	if len(p) == 0 {
+		return nil, errors.New("empty paragraph")
+	}
+	return p, nil
+}
+
+// lookupFieldType returns the type of the named field, matching the name
+// case-insensitively. Like a map lookup, it returns Simple for fields that
+// aren't in types.
+func lookupFieldType(types map[string]FieldType, name string) FieldType {
+	if typ, ok := types[name]; ok {
+		return typ
+	}
+	for k, typ := range types {
+		if strings.EqualFold(k, name) {
+			return typ
+		}
+	}
+	return Simple
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testDebianControl = `# This file is maintained by hand.
+Source: nullpkg
+section:  misc
+Maintainer: Jane Doe <jane@example.com>
+Build-Depends: debhelper-compat (= 13),
+# Needed for the tests:
+               python3,
+	       libfoo-dev
+Standards-Version: 4.5.0
+
+# The only binary package.
+Package: nullpkg
+Architecture: any
+Depends: ${misc:Depends}
+# TODO: better description
+Description: does nothing
+ Really, it does nothing.
+ .
+ Nothing at all.
+# trailing comment
+
+`
+
+func TestDocument(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, source := range []string{
+			"",
+			"\n\n",
+			testDebianControl,
+			"Package: nullpkg",
+			"# just a comment\n",
+		} {
+			doc, err := ParseDocument(strings.NewReader(source))
+			if err != nil {
+				t.Errorf("ParseDocument(%q): %v", source, err)
+				continue
+			}
+			got := new(strings.Builder)
+			if err := doc.Save(got); err != nil {
+				t.Errorf("Save: %v", err)
+				continue
+			}
+			if diff := cmp.Diff(source, got.String()); diff != "" {
+				t.Errorf("round trip (-want +got):\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		doc, err := ParseDocument(strings.NewReader(testDebianControl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(doc.Paragraphs) != 2 {
+			t.Fatalf("len(doc.Paragraphs) = %d; want 2", len(doc.Paragraphs))
+		}
+		src := doc.Paragraphs[0]
+		tests := []struct {
+			name string
+			want string
+		}{
+			{"Source", "nullpkg"},
+			{"Section", "misc"},
+			{"build-depends", "debhelper-compat (= 13),\n               python3,\n\t       libfoo-dev"},
+			{"Homepage", ""},
+		}
+		for _, test := range tests {
+			if got := src.Get(test.name); got != test.want {
+				t.Errorf("Get(%q) = %q; want %q", test.name, got, test.want)
+			}
+		}
+		if diff := cmp.Diff([]string{"Source", "section", "Maintainer", "Build-Depends", "Standards-Version"}, src.Names()); diff != "" {
+			t.Errorf("Names() (-want +got):\n%s", diff)
+		}
+		para, err := src.Paragraph(SourceControlFields)
+		if err != nil {
+			t.Fatal("Paragraph:", err)
+		}
+		if got, want := para.Get("Build-Depends"), "debhelper-compat (= 13),               python3,\t       libfoo-dev"; got != want {
+			t.Errorf("Paragraph(SourceControlFields).Get(%q) = %q; want %q", "Build-Depends", got, want)
+		}
+	})
+
+	t.Run("LowercaseFieldTypes", func(t *testing.T) {
+		doc, err := ParseDocument(strings.NewReader("source: foo\nbuild-depends: debhelper-compat (= 13),\n libfoo-dev\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		para, err := doc.Paragraphs[0].Paragraph(SourceControlFields)
+		if err != nil {
+			t.Fatal("Paragraph:", err)
+		}
+		if got, want := para.Get("build-depends"), "debhelper-compat (= 13), libfoo-dev"; got != want {
+			t.Errorf("Paragraph(SourceControlFields).Get(%q) = %q; want %q", "build-depends", got, want)
+		}
+
+		doc, err = ParseDocument(strings.NewReader("source: foo\nversion: 1.0\n 2.0\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if para, err := doc.Paragraphs[0].Paragraph(SourceControlFields); err == nil {
+			t.Errorf("Paragraph(SourceControlFields) = %v, <nil>; want error for multi-line version", para)
+		}
+	})
+
+	t.Run("Edit", func(t *testing.T) {
+		doc, err := ParseDocument(strings.NewReader(testDebianControl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.Paragraphs[0].Set("SECTION", "utils")
+		doc.Paragraphs[0].Set("Homepage", "https://example.com/")
+		doc.Paragraphs[1].Set("Description", "does nothing\n Still nothing.")
+		doc.Paragraphs[1].Delete("Depends")
+		doc.Append(Paragraph{
+			{Name: "Package", Value: "nullpkg-doc"},
+			{Name: "Architecture", Value: "all"},
+		})
+		got := new(strings.Builder)
+		if err := doc.Save(got); err != nil {
+			t.Fatal(err)
+		}
+		want := `# This file is maintained by hand.
+Source: nullpkg
+section: utils
+Maintainer: Jane Doe <jane@example.com>
+Build-Depends: debhelper-compat (= 13),
+# Needed for the tests:
+               python3,
+	       libfoo-dev
+Standards-Version: 4.5.0
+Homepage: https://example.com/
+
+# The only binary package.
+Package: nullpkg
+Architecture: any
+# TODO: better description
+Description: does nothing
+ Still nothing.
+# trailing comment
+
+Package: nullpkg-doc
+Architecture: all
+`
+		if diff := cmp.Diff(want, got.String()); diff != "" {
+			t.Errorf("after edits (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, source := range []string{
+			" continuation\n",
+			"Package: nullpkg\nno colon\n",
+			"Package: nullpkg\npackage: other\n",
+			"-Package: nullpkg\n",
+		} {
+			if _, err := ParseDocument(strings.NewReader(source)); err == nil {
+				t.Errorf("ParseDocument(%q) did not return an error", source)
+			}
+		}
+	})
+}
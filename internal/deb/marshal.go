@@ -0,0 +1,348 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Marshal returns a paragraph with the fields of the struct that v is or
+// points to.
+//
+// Each exported struct field becomes a control field named by its "deb" tag,
+// or by the Go field name if it has no tag. A tag of "-" skips the field.
+// The name may be followed by comma-separated options:
+//
+//	multiline  the field may span multiple lines (see Multiline)
+//	folded     the field may be folded onto multiple lines (see Folded)
+//	comma      a []string is separated by commas instead of whitespace
+//
+// A Paragraph struct field tagged with the "rest" option and no name holds
+// the fields that don't correspond to any other struct field.
+//
+// Struct fields may be strings, integers, []string (a whitespace-separated
+// set of tokens), []IndexSignature (a checksum list, as in the Files or SHA256
+// fields), or Relations. Control files can't have empty fields, so struct
+// fields with zero values are omitted.
+func Marshal(v interface{}) (Paragraph, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("marshal paragraph: %v is not a struct", rv.Type())
+	}
+	fields, err := structFields(rv.Type())
+	if err != nil {
+		return nil, fmt.Errorf("marshal paragraph: %w", err)
+	}
+	var para Paragraph
+	var rest Paragraph
+	for _, sf := range fields {
+		fv := rv.FieldByIndex(sf.index)
+		if sf.rest {
+			rest = fv.Interface().(Paragraph)
+			continue
+		}
+		value, err := sf.encode(fv)
+		if err != nil {
+			return nil, fmt.Errorf("marshal paragraph: %s: %w", sf.name, err)
+		}
+		if value != "" {
+			para = append(para, Field{Name: sf.name, Value: value})
+		}
+	}
+	for _, f := range rest {
+		if para.find(f.Name) == -1 {
+			para = append(para, f)
+		}
+	}
+	return para, nil
+}
+
+// Unmarshal sets the fields of the struct that v points to from a paragraph.
+// See Marshal for how struct fields correspond to control fields. Struct
+// fields that are not present in the paragraph are set to their zero values.
+func Unmarshal(para Paragraph, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unmarshal paragraph: %T is not a pointer to a struct", v)
+	}
+	rv = rv.Elem()
+	fields, err := structFields(rv.Type())
+	if err != nil {
+		return fmt.Errorf("unmarshal paragraph: %w", err)
+	}
+	rv.Set(reflect.Zero(rv.Type()))
+	var rest *structField
+	for i := range fields {
+		if fields[i].rest {
+			rest = &fields[i]
+		}
+	}
+	for _, f := range para {
+		sf := findStructField(fields, f.Name)
+		if sf == nil {
+			if rest != nil {
+				fv := rv.FieldByIndex(rest.index)
+				fv.Set(reflect.Append(fv, reflect.ValueOf(f)))
+			}
+			continue
+		}
+		if err := sf.decode(rv.FieldByIndex(sf.index), f.Value); err != nil {
+			return fmt.Errorf("unmarshal paragraph: %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// FieldTypes returns the types of the fields of the struct that v is or
+// points to, as given by the multiline and folded options in its tags. Simple
+// fields are omitted. The result is suitable for Parser.Fields. FieldTypes
+// panics if v is not a struct with valid tags.
+func FieldTypes(v interface{}) map[string]FieldType {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields, err := structFields(t)
+	if err != nil {
+		panic(err)
+	}
+	m := make(map[string]FieldType)
+	for _, sf := range fields {
+		if !sf.rest && sf.typ != Simple {
+			m[sf.name] = sf.typ
+		}
+	}
+	return m
+}
+
+// structField is a struct field that corresponds to a control field.
+type structField struct {
+	name  string
+	index []int
+	typ   FieldType
+	comma bool
+	rest  bool
+}
+
+var (
+	paragraphType       = reflect.TypeOf(Paragraph(nil))
+	indexSignaturesType = reflect.TypeOf([]IndexSignature(nil))
+	relationsType       = reflect.TypeOf(Relations(nil))
+	stringsType         = reflect.TypeOf([]string(nil))
+)
+
+var structFieldCache sync.Map // reflect.Type -> []structField
+
+func structFields(t reflect.Type) ([]structField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%v is not a struct", t)
+	}
+	if fields, ok := structFieldCache.Load(t); ok {
+		return fields.([]structField), nil
+	}
+	var fields []structField
+	hasRest := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		tag := f.Tag.Get("deb")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		sf := structField{name: opts[0], index: f.Index}
+		if sf.name == "" {
+			sf.name = f.Name
+		}
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "multiline":
+				sf.typ = Multiline
+			case "folded":
+				sf.typ = Folded
+			case "comma":
+				sf.comma = true
+			case "rest":
+				sf.rest = true
+			default:
+				return nil, fmt.Errorf("%v.%s: unknown option %q", t, f.Name, opt)
+			}
+		}
+		switch {
+		case sf.rest && (opts[0] != "" || f.Type != paragraphType):
+			return nil, fmt.Errorf("%v.%s: rest field must be an unnamed Paragraph", t, f.Name)
+		case sf.rest && hasRest:
+			return nil, fmt.Errorf("%v.%s: multiple rest fields", t, f.Name)
+		case sf.rest:
+			hasRest = true
+		case sf.comma && f.Type != stringsType:
+			return nil, fmt.Errorf("%v.%s: comma option on %v", t, f.Name, f.Type)
+		case !isMarshalableType(f.Type):
+			return nil, fmt.Errorf("%v.%s: unsupported type %v", t, f.Name, f.Type)
+		default:
+			if err := validateFieldName(sf.name); err != nil {
+				return nil, fmt.Errorf("%v.%s: %w", t, f.Name, err)
+			}
+			if findStructField(fields, sf.name) != nil {
+				return nil, fmt.Errorf("%v.%s: multiple fields named %q", t, f.Name, sf.name)
+			}
+		}
+		fields = append(fields, sf)
+	}
+	structFieldCache.Store(t, fields)
+	return fields, nil
+}
+
+func findStructField(fields []structField, name string) *structField {
+	for i := range fields {
+		if !fields[i].rest && fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func isMarshalableType(t reflect.Type) bool {
+	switch t {
+	case indexSignaturesType, relationsType, stringsType:
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// encode formats a struct field's value,
+// returning the empty string for a zero value.
+func (sf *structField) encode(v reflect.Value) (string, error) {
+	switch v.Type() {
+	case indexSignaturesType:
+		sb := new(strings.Builder)
+		for _, sig := range v.Interface().([]IndexSignature) {
+			sb.WriteString("\n ")
+			sb.WriteString(sig.String())
+		}
+		return sb.String(), nil
+	case relationsType:
+		return v.Interface().(Relations).String(), nil
+	case stringsType:
+		sep := " "
+		if sf.comma {
+			sep = ", "
+		}
+		return strings.Join(v.Interface().([]string), sep), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if sf.typ == Simple && strings.Contains(v.String(), "\n") {
+			return "", errors.New("value contains newline")
+		}
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() == 0 {
+			return "", nil
+		}
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() == 0 {
+			return "", nil
+		}
+		return strconv.FormatUint(v.Uint(), 10), nil
+	default:
+		panic("unreachable")
+	}
+}
+
+// decode parses a field value into a struct field.
+func (sf *structField) decode(v reflect.Value, value string) error {
+	switch v.Type() {
+	case indexSignaturesType:
+		sigs, err := parseIndexSignaturesAnySize(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(sigs))
+		return nil
+	case relationsType:
+		rels, err := ParseRelations(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(rels))
+		return nil
+	case stringsType:
+		var tokens []string
+		if sf.comma {
+			for _, tok := range strings.Split(value, ",") {
+				if tok = strings.TrimSpace(tok); tok != "" {
+					tokens = append(tokens, tok)
+				}
+			}
+		} else {
+			tokens = strings.Fields(value)
+		}
+		v.Set(reflect.ValueOf(tokens))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	default:
+		panic("unreachable")
+	}
+	return nil
+}
+
+// parseIndexSignaturesAnySize parses a checksum list whose checksum size is
+// determined by the first checksum in the list.
+func parseIndexSignaturesAnySize(value string) ([]IndexSignature, error) {
+	for _, line := range strings.Split(value, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			return ParseIndexSignatures(value, hex.DecodedLen(len(fields[0])))
+		}
+	}
+	return nil, nil
+}
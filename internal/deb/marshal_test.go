@@ -0,0 +1,195 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnmarshal(t *testing.T) {
+	t.Run("BinaryPackage", func(t *testing.T) {
+		const source = "Package: nullpkg\n" +
+			"Version: 1.0-1\n" +
+			"Architecture: amd64\n" +
+			"Installed-Size: 8\n" +
+			"Depends: libc6 (>= 2.3),\n libfoo | libbar\n" +
+			"X-Custom: hello\n" +
+			"Description: Does nothing\n Really nothing.\n" +
+			"Size: 1234\n"
+		p := NewParser(strings.NewReader(source))
+		p.Fields = ControlFields
+		if !p.Single() {
+			t.Fatal(p.Err())
+		}
+		var got BinaryPackage
+		if err := Unmarshal(p.Paragraph(), &got); err != nil {
+			t.Fatal("Unmarshal:", err)
+		}
+		want := BinaryPackage{
+			Package:       "nullpkg",
+			Version:       "1.0-1",
+			Architecture:  "amd64",
+			InstalledSize: 8,
+			Depends: Relations{
+				{{Package: "libc6", Op: ">=", Version: "2.3"}},
+				{{Package: "libfoo"}, {Package: "libbar"}},
+			},
+			Description: "Does nothing\n Really nothing.",
+			Size:        1234,
+			Rest:        Paragraph{{Name: "X-Custom", Value: "hello"}},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Unmarshal (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		const source = "Suite: stable\n" +
+			"Architectures: amd64 arm64\n" +
+			"Components: main\n" +
+			"MD5Sum:\n" +
+			" d41d8cd98f00b204e9800998ecf8427e 0 main/binary-amd64/Packages\n" +
+			"SHA256:\n" +
+			" e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 0 main/binary-amd64/Packages\n"
+		got, err := ParseReleaseIndex(strings.NewReader(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var release Release
+		if err := Unmarshal(got, &release); err != nil {
+			t.Fatal("Unmarshal:", err)
+		}
+		if diff := cmp.Diff([]string{"amd64", "arm64"}, release.Architectures); diff != "" {
+			t.Errorf("Architectures (-want +got):\n%s", diff)
+		}
+		if len(release.MD5Sum) != 1 || len(release.MD5Sum[0].Checksum) != 16 {
+			t.Errorf("MD5Sum = %v; want one 16-byte checksum", release.MD5Sum)
+		}
+		if len(release.SHA256) != 1 || release.SHA256[0].Filename != "main/binary-amd64/Packages" {
+			t.Errorf("SHA256 = %v; want one checksum for main/binary-amd64/Packages", release.SHA256)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			para Paragraph
+		}{
+			{"InvalidInt", Paragraph{{Name: "Size", Value: "big"}}},
+			{"InvalidRelation", Paragraph{{Name: "Depends", Value: "libc6 (~ 2)"}}},
+		}
+		for _, test := range tests {
+			var pkg BinaryPackage
+			if err := Unmarshal(test.para, &pkg); err == nil {
+				t.Errorf("%s: Unmarshal did not return an error", test.name)
+			}
+		}
+		if err := Unmarshal(Paragraph{}, BinaryPackage{}); err == nil {
+			t.Error("Unmarshal into non-pointer did not return an error")
+		}
+	})
+}
+
+func TestMarshal(t *testing.T) {
+	pkg := &BinaryPackage{
+		Package:      "nullpkg",
+		Version:      "1.0-1",
+		Architecture: "amd64",
+		Depends: Relations{
+			{{Package: "libc6", Op: ">=", Version: "2.3"}},
+		},
+		Description: "Does nothing\n Really nothing.",
+		Size:        1234,
+		Rest:        Paragraph{{Name: "X-Custom", Value: "hello"}},
+	}
+	got, err := Marshal(pkg)
+	if err != nil {
+		t.Fatal("Marshal:", err)
+	}
+	want := Paragraph{
+		{Name: "Package", Value: "nullpkg"},
+		{Name: "Version", Value: "1.0-1"},
+		{Name: "Architecture", Value: "amd64"},
+		{Name: "Depends", Value: "libc6 (>= 2.3)"},
+		{Name: "Description", Value: "Does nothing\n Really nothing."},
+		{Name: "Size", Value: "1234"},
+		{Name: "X-Custom", Value: "hello"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Marshal (-want +got):\n%s", diff)
+	}
+
+	var roundTrip BinaryPackage
+	if err := Unmarshal(got, &roundTrip); err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if diff := cmp.Diff(pkg, &roundTrip); diff != "" {
+		t.Errorf("round trip (-want +got):\n%s", diff)
+	}
+
+	t.Run("Signatures", func(t *testing.T) {
+		got, err := Marshal(Release{
+			Components: []string{"main", "contrib"},
+			MD5Sum: []IndexSignature{{
+				Checksum: make([]byte, 16),
+				Size:     42,
+				Filename: "main/binary-amd64/Packages",
+			}},
+		})
+		if err != nil {
+			t.Fatal("Marshal:", err)
+		}
+		want := "Components: main contrib\n" +
+			"MD5Sum:\n 00000000000000000000000000000000 42 main/binary-amd64/Packages"
+		if got.String() != want {
+			t.Errorf("Marshal(...).String() = %q; want %q", got.String(), want)
+		}
+	})
+
+	t.Run("InvalidTag", func(t *testing.T) {
+		var v struct {
+			Foo string `deb:"Foo,bogus"`
+		}
+		if _, err := Marshal(v); err == nil {
+			t.Error("Marshal did not return an error")
+		}
+	})
+}
+
+func TestFieldTypes(t *testing.T) {
+	want := map[string]FieldType{
+		"Binary":           Folded,
+		"Checksums-Sha1":   Multiline,
+		"Checksums-Sha256": Multiline,
+		"Dgit":             Folded,
+		"Files":            Multiline,
+		"Package-List":     Multiline,
+		"Uploaders":        Folded,
+	}
+	got := FieldTypes(SourcePackage{})
+	for name, typ := range want {
+		if got[name] != typ {
+			t.Errorf("FieldTypes(SourcePackage{})[%q] = %v; want %v", name, got[name], typ)
+		}
+	}
+	if got, want := FieldTypes(Release{})["SHA256"], Multiline; got != want {
+		t.Errorf("FieldTypes(Release{})[\"SHA256\"] = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"sort"
+	"strings"
+)
+
+// PackagesFieldOrder is the canonical order of fields in a Packages index
+// paragraph, as written by apt-ftparchive.
+var PackagesFieldOrder = []string{
+	"Package",
+	"Package-Type",
+	"Architecture",
+	"Subarchitecture",
+	"Version",
+	"Revision",
+	"Package-Revision",
+	"Package_Revision",
+	"Kernel-Version",
+	"Built-Using",
+	"Static-Built-Using",
+	"Built-For-Profiles",
+	"Auto-Built-Package",
+	"Multi-Arch",
+	"Status",
+	"Priority",
+	"Class",
+	"Build-Essential",
+	"Important",
+	"Essential",
+	"Protected",
+	"Installer-Menu-Item",
+	"Section",
+	"Source",
+	"Origin",
+	"Maintainer",
+	"Original-Maintainer",
+	"Bugs",
+	"Config-Version",
+	"Conffiles",
+	"Triggers-Awaited",
+	"Triggers-Pending",
+	"Installed-Size",
+	"Provides",
+	"Pre-Depends",
+	"Depends",
+	"Recommends",
+	"Recommended",
+	"Suggests",
+	"Optional",
+	"Conflicts",
+	"Breaks",
+	"Replaces",
+	"Enhances",
+	"Filename",
+	"MSDOS-Filename",
+	"Size",
+	"MD5sum",
+	"SHA1",
+	"SHA256",
+	"SHA512",
+	"Homepage",
+	"Description",
+	"Description-md5",
+	"Tag",
+	"Task",
+}
+
+// SourcesFieldOrder is the canonical order of fields in a Sources index
+// paragraph, as written by apt-ftparchive.
+var SourcesFieldOrder = []string{
+	"Package",
+	"Source",
+	"Format",
+	"Binary",
+	"Architecture",
+	"Version",
+	"Priority",
+	"Class",
+	"Section",
+	"Origin",
+	"Maintainer",
+	"Original-Maintainer",
+	"Uploaders",
+	"Dm-Upload-Allowed",
+	"Standards-Version",
+	"Build-Depends",
+	"Build-Depends-Arch",
+	"Build-Depends-Indep",
+	"Build-Conflicts",
+	"Build-Conflicts-Arch",
+	"Build-Conflicts-Indep",
+	"Testsuite",
+	"Testsuite-Triggers",
+	"Homepage",
+	"Description",
+	"Vcs-Browser",
+	"Vcs-Browse",
+	"Vcs-Arch",
+	"Vcs-Bzr",
+	"Vcs-Cvs",
+	"Vcs-Darcs",
+	"Vcs-Git",
+	"Vcs-Hg",
+	"Vcs-Mtn",
+	"Vcs-Svn",
+	"Directory",
+	"Package-List",
+	"Files",
+	"Checksums-Md5",
+	"Checksums-Sha1",
+	"Checksums-Sha256",
+	"Checksums-Sha512",
+}
+
+// ReleaseFieldOrder is the canonical order of fields in a Release file,
+// as written by apt-ftparchive.
+var ReleaseFieldOrder = []string{
+	"Origin",
+	"Label",
+	"Suite",
+	"Version",
+	"Codename",
+	"Date",
+	"NotAutomatic",
+	"ButAutomaticUpgrades",
+	"Acquire-By-Hash",
+	"Valid-Until",
+	"Signed-By",
+	"Architectures",
+	"Components",
+	"Description",
+	"MD5Sum",
+	"SHA1",
+	"SHA256",
+	"SHA512",
+}
+
+// SortFields reorders the fields of the paragraph in-place to follow the given
+// order. Field names are matched case-insensitively. Fields that are not in
+// the order come after the others, sorted case-insensitively by name, so the
+// result does not depend on the paragraph's original order.
+func SortFields(para Paragraph, order []string) {
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		rank[strings.ToLower(name)] = i
+	}
+	sort.SliceStable(para, func(i, j int) bool {
+		ni, nj := strings.ToLower(para[i].Name), strings.ToLower(para[j].Name)
+		ri, knownI := rank[ni]
+		rj, knownJ := rank[nj]
+		switch {
+		case knownI && knownJ:
+			return ri < rj
+		case knownI != knownJ:
+			return knownI
+		case ni != nj:
+			return ni < nj
+		default:
+			return para[i].Name < para[j].Name
+		}
+	})
+}
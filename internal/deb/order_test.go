@@ -0,0 +1,82 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSortFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		para  Paragraph
+		order []string
+		want  []string
+	}{
+		{
+			name: "Packages",
+			para: Paragraph{
+				{Name: "SHA256", Value: "x"},
+				{Name: "Description", Value: "x"},
+				{Name: "X-Zeta", Value: "x"},
+				{Name: "Version", Value: "x"},
+				{Name: "md5sum", Value: "x"},
+				{Name: "x-alpha", Value: "x"},
+				{Name: "Filename", Value: "x"},
+				{Name: "Package", Value: "x"},
+				{Name: "X-Beta", Value: "x"},
+			},
+			order: PackagesFieldOrder,
+			want:  []string{"Package", "Version", "Filename", "md5sum", "SHA256", "Description", "x-alpha", "X-Beta", "X-Zeta"},
+		},
+		{
+			name: "Sources",
+			para: Paragraph{
+				{Name: "Files", Value: "x"},
+				{Name: "Directory", Value: "x"},
+				{Name: "Format", Value: "x"},
+				{Name: "Package", Value: "x"},
+			},
+			order: SourcesFieldOrder,
+			want:  []string{"Package", "Format", "Directory", "Files"},
+		},
+		{
+			name: "Release",
+			para: Paragraph{
+				{Name: "SHA256", Value: "x"},
+				{Name: "Components", Value: "x"},
+				{Name: "Date", Value: "x"},
+				{Name: "Origin", Value: "x"},
+				{Name: "MD5Sum", Value: "x"},
+			},
+			order: ReleaseFieldOrder,
+			want:  []string{"Origin", "Date", "Components", "MD5Sum", "SHA256"},
+		},
+	}
+	for _, test := range tests {
+		SortFields(test.para, test.order)
+		var got []string
+		for _, f := range test.para {
+			got = append(got, f.Name)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: field names after SortFields (-want +got):\n%s", test.name, diff)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	slashpath "path"
+	"strings"
+
+	"github.com/laher/argo/ar"
+)
+
+// A Reader provides sequential access to the files in a binary package's
+// control and data archives, in the order they are stored: first the files of
+// the control archive, then the files of the data archive. As with
+// archive/tar.Reader, Next advances to the next file, and then Read reads
+// the file's content. Use Package to look up files by name.
+type Reader struct {
+	arr     *ar.Reader
+	version string
+	member  ArchiveMember
+	// members is the number of tar members opened so far.
+	members int
+	dr      io.ReadCloser
+	tr      *tar.Reader
+	err     error
+}
+
+// NewReader returns a Reader that reads the binary package from r.
+// It reads the package's debian-binary member before returning.
+func NewReader(r io.Reader) (*Reader, error) {
+	arr, version, err := openDeb(r)
+	if err != nil {
+		return nil, fmt.Errorf("read deb: %w", err)
+	}
+	return &Reader{arr: arr, version: version}, nil
+}
+
+// Version returns the format version from the package's debian-binary
+// member, like "2.0".
+func (r *Reader) Version() string {
+	return r.version
+}
+
+// Member describes the ar member that contains the current file: a
+// control.tar member for control files or a data.tar member for the files
+// that the package installs.
+func (r *Reader) Member() ArchiveMember {
+	return r.member
+}
+
+// IsData reports whether the current file is in the data archive.
+func (r *Reader) IsData() bool {
+	return r.members == 2
+}
+
+// Next advances to the next file in the package. The header's Name is as
+// stored in the archive, usually with a leading "./". Next returns io.EOF at
+// the end of the package.
+func (r *Reader) Next() (*tar.Header, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for {
+		if r.tr != nil {
+			hdr, err := r.tr.Next()
+			if err == nil {
+				return hdr, nil
+			}
+			if !errors.Is(err, io.EOF) {
+				r.err = fmt.Errorf("read deb: %s: %w", r.member.Name, err)
+				return nil, r.err
+			}
+			r.dr.Close()
+			r.dr, r.tr = nil, nil
+		}
+		if err := r.nextMember(); err != nil {
+			r.err = err
+			return nil, err
+		}
+	}
+}
+
+// nextMember opens the next tar member of the package.
+func (r *Reader) nextMember() error {
+	for {
+		hdr, err := r.arr.Next()
+		if errors.Is(err, io.EOF) {
+			if r.members < 2 {
+				return fmt.Errorf("read deb: missing data archive: %w", io.ErrUnexpectedEOF)
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return fmt.Errorf("read deb: %w", err)
+		}
+		if strings.HasPrefix(hdr.Name, "_") {
+			// Members with leading underscores are reserved for other tools,
+			// such as package signatures, and dpkg ignores them.
+			continue
+		}
+		base, compression, err := splitMemberName(hdr.Name)
+		if err != nil {
+			return fmt.Errorf("read deb: %w", err)
+		}
+		switch {
+		case r.members == 0 && base == "control":
+		case r.members == 1 && base == "data":
+		default:
+			return fmt.Errorf("read deb: unexpected member %q", hdr.Name)
+		}
+		dr, err := decompress(r.arr, compression)
+		if err != nil {
+			return fmt.Errorf("read deb: %s: %w", hdr.Name, err)
+		}
+		r.members++
+		r.member = ArchiveMember{
+			Name:        hdr.Name,
+			Compression: compression,
+			Size:        hdr.Size,
+		}
+		r.dr = dr
+		r.tr = tar.NewReader(dr)
+		return nil
+	}
+}
+
+// Read reads from the current file in the package.
+// It returns (0, io.EOF) when it reaches the end of that file.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.tr == nil {
+		return 0, io.EOF
+	}
+	return r.tr.Read(p)
+}
+
+// A Package provides random access to the files in a binary package's data
+// archive. OpenPackage indexes the data archive once, so that Stat is a map
+// lookup and Open reads only the data archive up to the requested file.
+// Uncompressed data archives are read directly at the file's offset;
+// compressed ones are decompressed from the start of the archive, since none
+// of the compression formats support seeking.
+type Package struct {
+	version string
+	data    ArchiveMember
+	// dataSection is the content of the data member in the package.
+	dataSection *io.SectionReader
+	files       []*packageFile
+	byName      map[string]*packageFile
+}
+
+// packageFile is a file in a package's data archive.
+type packageFile struct {
+	hdr *tar.Header
+	// offset is the offset of the file's content in the decompressed data
+	// archive.
+	offset int64
+}
+
+// OpenPackage reads the index of the data archive of the binary package in r,
+// which is size bytes long. The Package reads from r as long as it is in use.
+func OpenPackage(r io.ReaderAt, size int64) (*Package, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	arr, version, err := openDeb(sr)
+	if err != nil {
+		return nil, fmt.Errorf("open deb: %w", err)
+	}
+	p := &Package{
+		version: version,
+		byName:  make(map[string]*packageFile),
+	}
+	members := 0
+	for members < 2 {
+		hdr, err := nextMember(arr)
+		if err != nil {
+			return nil, fmt.Errorf("open deb: %w", err)
+		}
+		if strings.HasPrefix(hdr.Name, "_") {
+			continue
+		}
+		base, compression, err := splitMemberName(hdr.Name)
+		if err != nil {
+			return nil, fmt.Errorf("open deb: %w", err)
+		}
+		switch {
+		case members == 0 && base == "control":
+		case members == 1 && base == "data":
+			// The ar reader reads exactly the member header, so the section
+			// reader is now at the start of the member's content.
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, fmt.Errorf("open deb: %w", err)
+			}
+			p.data = ArchiveMember{
+				Name:        hdr.Name,
+				Compression: compression,
+				Size:        hdr.Size,
+			}
+			p.dataSection = io.NewSectionReader(r, offset, hdr.Size)
+		default:
+			return nil, fmt.Errorf("open deb: unexpected member %q", hdr.Name)
+		}
+		members++
+	}
+	if err := p.index(); err != nil {
+		return nil, fmt.Errorf("open deb: %s: %w", p.data.Name, err)
+	}
+	return p, nil
+}
+
+// index reads the headers of the files in the data archive.
+func (p *Package) index() error {
+	dr, err := decompress(io.NewSectionReader(p.dataSection, 0, p.data.Size), p.data.Compression)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	cr := &countingReader{r: dr}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// tar.Reader reads exactly up to the file's content.
+		f := &packageFile{hdr: hdr, offset: cr.n}
+		p.files = append(p.files, f)
+		// As when extracting, later entries replace earlier ones.
+		p.byName[slashpath.Clean("/"+hdr.Name)] = f
+	}
+}
+
+// Version returns the format version from the package's debian-binary
+// member, like "2.0".
+func (p *Package) Version() string {
+	return p.version
+}
+
+// DataMember describes the ar member that contains the data archive.
+func (p *Package) DataMember() ArchiveMember {
+	return p.data
+}
+
+// Files returns the headers of the files in the data archive, in the order
+// they are stored.
+func (p *Package) Files() []*tar.Header {
+	hdrs := make([]*tar.Header, 0, len(p.files))
+	for _, f := range p.files {
+		hdr := *f.hdr
+		hdrs = append(hdrs, &hdr)
+	}
+	return hdrs
+}
+
+// Stat returns the header of the file in the data archive with the given path,
+// like "/usr/share/doc/foo/copyright". Paths are compared after cleaning, so
+// "./usr/share/doc/foo/copyright" in the archive matches. Stat returns an error
+// wrapping os.ErrNotExist if there is no such file.
+func (p *Package) Stat(name string) (*tar.Header, error) {
+	f, err := p.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	hdr := *f.hdr
+	return &hdr, nil
+}
+
+// Open opens the regular file in the data archive with the given path.
+// See Stat for how paths are matched.
+func (p *Package) Open(name string) (io.ReadCloser, error) {
+	f, err := p.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.hdr.Typeflag != tar.TypeReg && f.hdr.Typeflag != tar.TypeRegA {
+		return nil, fmt.Errorf("read deb: %s: not a regular file", name)
+	}
+	if p.data.Compression == NoCompression {
+		return ioutil.NopCloser(io.NewSectionReader(p.dataSection, f.offset, f.hdr.Size)), nil
+	}
+	dr, err := decompress(io.NewSectionReader(p.dataSection, 0, p.data.Size), p.data.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("read deb: %s: %w", name, err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, dr, f.offset); err != nil {
+		dr.Close()
+		return nil, fmt.Errorf("read deb: %s: %w", name, err)
+	}
+	return &packageFileReader{Reader: io.LimitReader(dr, f.hdr.Size), Closer: dr}, nil
+}
+
+// ReadFile returns the content of the regular file in the data archive with
+// the given path. See Stat for how paths are matched.
+func (p *Package) ReadFile(name string) ([]byte, error) {
+	rc, err := p.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read deb: %s: %w", name, err)
+	}
+	return data, nil
+}
+
+func (p *Package) lookup(name string) (*packageFile, error) {
+	f := p.byName[slashpath.Clean("/"+name)]
+	if f == nil {
+		return nil, fmt.Errorf("read deb: %s: %w", name, os.ErrNotExist)
+	}
+	return f, nil
+}
+
+// packageFileReader reads a file from a compressed data archive and closes
+// the decompressor when done.
+type packageFileReader struct {
+	io.Reader
+	io.Closer
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestReader(t *testing.T) {
+	testDeb, err := ioutil.ReadFile(filepath.FromSlash(testDebPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Walk", func(t *testing.T) {
+		r, err := NewReader(bytes.NewReader(testDeb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Version(); got != "2.0" {
+			t.Errorf("Version() = %q; want \"2.0\"", got)
+		}
+		type entry struct {
+			Member string
+			IsData bool
+			Name   string
+			Size   int64
+		}
+		var got []entry
+		for {
+			hdr, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read %s: %v", hdr.Name, err)
+			}
+			if int64(len(data)) != hdr.Size {
+				t.Errorf("read %d bytes from %s; want %d", len(data), hdr.Name, hdr.Size)
+			}
+			got = append(got, entry{r.Member().Name, r.IsData(), hdr.Name, hdr.Size})
+		}
+		want := []entry{
+			{"control.tar.xz", false, "./", 0},
+			{"control.tar.xz", false, "./control", 204},
+			{"control.tar.xz", false, "./md5sums", 142},
+			{"data.tar.xz", true, "./", 0},
+			{"data.tar.xz", true, "./usr/", 0},
+			{"data.tar.xz", true, "./usr/share/", 0},
+			{"data.tar.xz", true, "./usr/share/doc/", 0},
+			{"data.tar.xz", true, "./usr/share/doc/nullpkg/", 0},
+			{"data.tar.xz", true, "./usr/share/doc/nullpkg/changelog.Debian.gz", 160},
+			{"data.tar.xz", true, "./usr/share/doc/nullpkg/copyright", 0},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("entries (-want +got):\n%s", diff)
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("Next() after end = _, %v; want io.EOF", err)
+		}
+	})
+
+	t.Run("Package", func(t *testing.T) {
+		p, err := OpenPackage(bytes.NewReader(testDeb), int64(len(testDeb)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Version(); got != "2.0" {
+			t.Errorf("Version() = %q; want \"2.0\"", got)
+		}
+		if want := (ArchiveMember{Name: "data.tar.xz", Compression: XZ, Size: 448}); p.DataMember() != want {
+			t.Errorf("DataMember() = %+v; want %+v", p.DataMember(), want)
+		}
+		var names []string
+		for _, hdr := range p.Files() {
+			names = append(names, hdr.Name)
+		}
+		wantNames := []string{
+			"./",
+			"./usr/",
+			"./usr/share/",
+			"./usr/share/doc/",
+			"./usr/share/doc/nullpkg/",
+			"./usr/share/doc/nullpkg/changelog.Debian.gz",
+			"./usr/share/doc/nullpkg/copyright",
+		}
+		if diff := cmp.Diff(wantNames, names); diff != "" {
+			t.Errorf("Files() names (-want +got):\n%s", diff)
+		}
+
+		// Read the files out of order to check that lookups don't depend on
+		// the position of an earlier one.
+		if data, err := p.ReadFile("usr/share/doc/nullpkg/copyright"); err != nil {
+			t.Error(err)
+		} else if len(data) != 0 {
+			t.Errorf("copyright = %q; want empty", data)
+		}
+		data, err := p.ReadFile("/usr/share/doc/nullpkg/changelog.Debian.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := md5.Sum(data)
+		if got, want := hex.EncodeToString(sum[:]), "c96512b7e1438e4cab094c7d7ff80a04"; got != want {
+			t.Errorf("MD5 of changelog.Debian.gz = %s; want %s (from md5sums)", got, want)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		changelog, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(changelog, []byte("nullpkg (1.0-1)")) {
+			t.Errorf("changelog = %q; want to start with \"nullpkg (1.0-1)\"", changelog)
+		}
+		if hdr, err := p.Stat("./usr/share/doc/nullpkg/changelog.Debian.gz"); err != nil {
+			t.Error(err)
+		} else if hdr.Size != 160 {
+			t.Errorf("Stat(changelog.Debian.gz).Size = %d; want 160", hdr.Size)
+		}
+
+		if _, err := p.ReadFile("/usr/share/doc/nullpkg/NEWS"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("ReadFile(missing) error = %v; want %v", err, os.ErrNotExist)
+		}
+		// Control files aren't in the data archive.
+		if _, err := p.Stat("control"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Stat(\"control\") error = %v; want %v", err, os.ErrNotExist)
+		}
+		if _, err := p.Open("/usr/share/doc"); err == nil {
+			t.Error("Open(directory) did not return an error")
+		}
+	})
+
+	t.Run("Zstd", func(t *testing.T) {
+		const content = "Hello, World!\n"
+		dataTar := new(bytes.Buffer)
+		zw, err := zstd.NewWriter(dataTar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(zw)
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./usr/share/foo/hello.txt",
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		deb := buildTestDeb(t, map[string]string{"./control": "Package: foo\n"}, "data.tar.zst", dataTar.Bytes())
+		p, err := OpenPackage(bytes.NewReader(deb), int64(len(deb)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.ReadFile("usr/share/foo/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("hello.txt = %q; want %q", got, content)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			deb  []byte
+		}{
+			{"Truncated", testDeb[:len(testDeb)-100]},
+			{"CorruptData", buildTestDeb(t, map[string]string{"./control": "Package: foo\n"}, "data.tar.xz", nil)},
+			{"UnknownMember", buildTestDeb(t, map[string]string{"./control": "Package: foo\n"}, "extra.tar", nil)},
+		}
+		for _, test := range tests {
+			r, err := NewReader(bytes.NewReader(test.deb))
+			if err != nil {
+				continue
+			}
+			for {
+				_, err = r.Next()
+				if err != nil {
+					break
+				}
+				if _, err = io.Copy(ioutil.Discard, r); err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				t.Errorf("%s: read entire package without error", test.name)
+			}
+		}
+		if _, err := NewReader(strings.NewReader("!<arch>\n")); err == nil {
+			t.Error("NewReader(empty ar archive) did not return an error")
+		}
+		for _, test := range tests {
+			if _, err := OpenPackage(bytes.NewReader(test.deb), int64(len(test.deb))); err == nil {
+				t.Errorf("%s: OpenPackage did not return an error", test.name)
+			}
+		}
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Relations is the value of a package relationship field like Depends: a list
+// of requirements that must all be satisfied, where each requirement is a list
+// of alternatives.
+// The syntax is documented at https://www.debian.org/doc/debian-policy/ch-relationships.html
+type Relations [][]Relation
+
+// Relation is a single package in a relationship field, like
+// "libc6:amd64 (>= 2.3) [amd64] <!nocheck>".
+type Relation struct {
+	Package string
+	// Arch is the architecture qualifier after a colon, if any.
+	Arch string
+	// Op is one of "<<", "<=", "=", ">=", or ">>",
+	// or empty if the relation has no version restriction.
+	Op      string
+	Version string
+	// Architectures is the architecture restriction list, if any.
+	Architectures []string
+	// Profiles is the list of build profile restriction formulas, if any,
+	// each without its angle brackets.
+	Profiles []string
+}
+
+// ParseRelations parses the value of a package relationship field.
+func ParseRelations(s string) (Relations, error) {
+	var rels Relations
+	for _, group := range strings.Split(s, ",") {
+		if strings.TrimSpace(group) == "" {
+			continue
+		}
+		var alts []Relation
+		for _, alt := range strings.Split(group, "|") {
+			rel, err := parseRelation(alt)
+			if err != nil {
+				return nil, fmt.Errorf("parse relation %q: %w", strings.TrimSpace(alt), err)
+			}
+			alts = append(alts, rel)
+		}
+		rels = append(rels, alts)
+	}
+	return rels, nil
+}
+
+func parseRelation(s string) (Relation, error) {
+	s = strings.TrimSpace(s)
+	end := strings.IndexAny(s, " \t([<")
+	if end == -1 {
+		end = len(s)
+	}
+	var rel Relation
+	rel.Package = s[:end]
+	if i := strings.IndexByte(rel.Package, ':'); i != -1 {
+		rel.Package, rel.Arch = rel.Package[:i], rel.Package[i+1:]
+	}
+	if rel.Package == "" {
+		return Relation{}, errors.New("missing package name")
+	}
+	s = strings.TrimLeft(s[end:], " \t")
+	for s != "" {
+		var close byte
+		switch s[0] {
+		case '(':
+			close = ')'
+		case '[':
+			close = ']'
+		case '<':
+			close = '>'
+		default:
+			return Relation{}, fmt.Errorf("unexpected %q", s)
+		}
+		end := strings.IndexByte(s, close)
+		if end == -1 {
+			return Relation{}, fmt.Errorf("missing %q", close)
+		}
+		inner := strings.TrimSpace(s[1:end])
+		switch s[0] {
+		case '(':
+			if rel.Op != "" {
+				return Relation{}, errors.New("multiple version restrictions")
+			}
+			opEnd := strings.IndexFunc(inner, func(c rune) bool {
+				return c != '<' && c != '>' && c != '='
+			})
+			if opEnd == -1 {
+				opEnd = len(inner)
+			}
+			rel.Op = inner[:opEnd]
+			rel.Version = strings.TrimSpace(inner[opEnd:])
+			switch rel.Op {
+			case "<<", "<=", "=", ">=", ">>":
+			case "<", ">":
+				// Obsolete forms that mean <= and >=. Old packages still use them.
+				rel.Op += "="
+			default:
+				return Relation{}, fmt.Errorf("invalid version relation %q", rel.Op)
+			}
+			if rel.Version == "" {
+				return Relation{}, errors.New("missing version")
+			}
+		case '[':
+			if rel.Architectures != nil {
+				return Relation{}, errors.New("multiple architecture restrictions")
+			}
+			rel.Architectures = strings.Fields(inner)
+			if len(rel.Architectures) == 0 {
+				return Relation{}, errors.New("empty architecture restriction")
+			}
+		case '<':
+			if inner == "" {
+				return Relation{}, errors.New("empty build profile restriction")
+			}
+			rel.Profiles = append(rel.Profiles, inner)
+		}
+		s = strings.TrimLeft(s[end+1:], " \t")
+	}
+	return rel, nil
+}
+
+// String formats the relations as a field value.
+func (rels Relations) String() string {
+	sb := new(strings.Builder)
+	for i, alts := range rels {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		for j, rel := range alts {
+			if j > 0 {
+				sb.WriteString(" | ")
+			}
+			sb.WriteString(rel.String())
+		}
+	}
+	return sb.String()
+}
+
+// String formats the relation as it appears in a field value.
+func (rel Relation) String() string {
+	sb := new(strings.Builder)
+	sb.WriteString(rel.Package)
+	if rel.Arch != "" {
+		sb.WriteString(":")
+		sb.WriteString(rel.Arch)
+	}
+	if rel.Op != "" {
+		fmt.Fprintf(sb, " (%s %s)", rel.Op, rel.Version)
+	}
+	if len(rel.Architectures) > 0 {
+		fmt.Fprintf(sb, " [%s]", strings.Join(rel.Architectures, " "))
+	}
+	for _, p := range rel.Profiles {
+		fmt.Fprintf(sb, " <%s>", p)
+	}
+	return sb.String()
+}
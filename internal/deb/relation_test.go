@@ -0,0 +1,93 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRelations(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Relations
+		str     string
+		wantErr bool
+	}{
+		{s: "", want: nil},
+		{
+			s:    "libc6",
+			want: Relations{{{Package: "libc6"}}},
+		},
+		{
+			s: "libc6 (>= 2.3), libfoo | libbar (<< 2~)",
+			want: Relations{
+				{{Package: "libc6", Op: ">=", Version: "2.3"}},
+				{{Package: "libfoo"}, {Package: "libbar", Op: "<<", Version: "2~"}},
+			},
+		},
+		{
+			s:    "python3:any (>=3.5)",
+			want: Relations{{{Package: "python3", Arch: "any", Op: ">=", Version: "3.5"}}},
+			str:  "python3:any (>= 3.5)",
+		},
+		{
+			s: "debhelper-compat (= 13), libfoo-dev [amd64 !i386] <!nocheck> <cross>",
+			want: Relations{
+				{{Package: "debhelper-compat", Op: "=", Version: "13"}},
+				{{Package: "libfoo-dev", Architectures: []string{"amd64", "!i386"}, Profiles: []string{"!nocheck", "cross"}}},
+			},
+		},
+		{
+			s: "libc6 (< 2.3), libfoo (> 1)",
+			want: Relations{
+				{{Package: "libc6", Op: "<=", Version: "2.3"}},
+				{{Package: "libfoo", Op: ">=", Version: "1"}},
+			},
+			str: "libc6 (<= 2.3), libfoo (>= 1)",
+		},
+		{s: "libc6 (~ 2)", wantErr: true},
+		{s: "libc6 (>= )", wantErr: true},
+		{s: "libc6 (>= 2", wantErr: true},
+		{s: "(>= 2)", wantErr: true},
+		{s: "libc6 garbage", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseRelations(test.s)
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("ParseRelations(%q): %v", test.s, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("ParseRelations(%q) = %v, <nil>; want error", test.s, got)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("ParseRelations(%q) (-want +got):\n%s", test.s, diff)
+		}
+		wantStr := test.str
+		if wantStr == "" {
+			wantStr = test.s
+		}
+		if s := got.String(); s != wantStr {
+			t.Errorf("ParseRelations(%q).String() = %q; want %q", test.s, s, wantStr)
+		}
+	}
+}
@@ -36,10 +36,32 @@ func ParseReleaseIndex(r io.Reader) (Paragraph, error) {
 }
 
 // ReleaseFields is the set of fields in the release information file.
-var ReleaseFields = map[string]FieldType{
-	"MD5Sum": Multiline,
-	"SHA1":   Multiline,
-	"SHA256": Multiline,
+var ReleaseFields = FieldTypes(Release{})
+
+// Release is a Release file. It can be used with Marshal and Unmarshal.
+// https://wiki.debian.org/DebianRepository/Format#A.22Release.22_files
+type Release struct {
+	Origin               string           `deb:"Origin"`
+	Label                string           `deb:"Label"`
+	Suite                string           `deb:"Suite"`
+	Version              string           `deb:"Version"`
+	Codename             string           `deb:"Codename"`
+	Date                 string           `deb:"Date"`
+	ValidUntil           string           `deb:"Valid-Until"`
+	NotAutomatic         string           `deb:"NotAutomatic"`
+	ButAutomaticUpgrades string           `deb:"ButAutomaticUpgrades"`
+	AcquireByHash        string           `deb:"Acquire-By-Hash"`
+	SignedBy             []string         `deb:"Signed-By,comma"`
+	Architectures        []string         `deb:"Architectures"`
+	Components           []string         `deb:"Components"`
+	Description          string           `deb:"Description"`
+	MD5Sum               []IndexSignature `deb:"MD5Sum,multiline"`
+	SHA1                 []IndexSignature `deb:"SHA1,multiline"`
+	SHA256               []IndexSignature `deb:"SHA256,multiline"`
+	SHA512               []IndexSignature `deb:"SHA512,multiline"`
+
+	// Rest holds any other fields.
+	Rest Paragraph `deb:",rest"`
 }
 
 type IndexSignature struct {
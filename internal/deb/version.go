@@ -0,0 +1,138 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two Debian package version strings, returning -1 if
+// a sorts before b, 1 if a sorts after b, or 0 if they are equal.
+// The algorithm is documented at https://www.debian.org/doc/debian-policy/ch-controlfields.html#version
+func CompareVersions(a, b string) int {
+	aEpoch, aUpstream, aRevision := splitVersion(a)
+	bEpoch, bUpstream, bRevision := splitVersion(b)
+	if aEpoch != bEpoch {
+		if aEpoch < bEpoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareVersionPart(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+	return compareVersionPart(aRevision, bRevision)
+}
+
+// splitVersion splits a version string into its epoch, upstream version, and
+// Debian revision. A missing or malformed epoch is treated as zero.
+func splitVersion(v string) (epoch int, upstream, revision string) {
+	v = strings.TrimSpace(v)
+	if i := strings.IndexByte(v, ':'); i != -1 {
+		epoch, _ = strconv.Atoi(v[:i])
+		v = v[i+1:]
+	}
+	if i := strings.LastIndexByte(v, '-'); i != -1 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+// compareVersionPart compares an upstream version or Debian revision by
+// alternating between non-digit and digit substrings.
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		var aText, bText string
+		aText, a = splitFunc(a, isNotDigit)
+		bText, b = splitFunc(b, isNotDigit)
+		if c := compareVersionText(aText, bText); c != 0 {
+			return c
+		}
+
+		var aNum, bNum string
+		aNum, a = splitFunc(a, isDigit)
+		bNum, b = splitFunc(b, isDigit)
+		if c := compareVersionNumber(aNum, bNum); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareVersionText compares non-digit substrings of a version. Letters sort
+// before non-letters, and a tilde sorts before anything, even the end of the
+// string.
+func compareVersionText(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ac, bc int
+		if i < len(a) {
+			ac = versionCharOrder(a[i])
+		}
+		if i < len(b) {
+			bc = versionCharOrder(b[i])
+		}
+		if ac != bc {
+			if ac < bc {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionCharOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+// compareVersionNumber compares digit substrings of a version numerically.
+// An empty string is treated as zero.
+func compareVersionNumber(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func splitFunc(s string, f func(byte) bool) (prefix, rest string) {
+	i := 0
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isNotDigit(c byte) bool {
+	return !isDigit(c)
+}
@@ -0,0 +1,451 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	slashpath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/laher/argo/ar"
+	"github.com/ulikunitz/xz"
+)
+
+// WriterOptions is the set of options for NewWriter.
+type WriterOptions struct {
+	// Compression is the compression format of the control and data archives.
+	// Bzip2 is not supported for writing. The zero value is NoCompression.
+	Compression Compression
+
+	// Scripts maps maintainer script names (preinst, postinst, prerm, postrm,
+	// or config) to their content.
+	Scripts map[string][]byte
+
+	// ControlFiles maps the names of other files to add to the control
+	// archive (like conffiles, triggers, shlibs, or templates) to their
+	// content. If it has an md5sums file, it is used instead of the one that
+	// Writer generates.
+	ControlFiles map[string][]byte
+
+	// ModTime is the modification time of the package's members and of any
+	// files and directories that don't have one. If zero, the current time is
+	// used.
+	ModTime time.Time
+}
+
+// A Writer creates a binary package. Files written with WriteHeader and Write
+// go in the package's data archive, much like with archive/tar.Writer. Close
+// writes the package to the underlying writer. The data archive is buffered
+// in memory until then, because its size must be known before it is written.
+//
+// Writer generates the md5sums control file from the regular files written,
+// and sets the Installed-Size control field if it is not given. It adds any
+// parent directories that were not written, so each directory's header must
+// be written before any of its contents to take effect.
+type Writer struct {
+	w       io.Writer
+	control Paragraph
+	opts    WriterOptions
+
+	data          bytes.Buffer
+	zw            io.WriteCloser
+	tw            *tar.Writer
+	dirs          map[string]bool
+	md5sums       bytes.Buffer
+	installedSize int64 // in KiB
+
+	// name and md5 are set while writing a regular file.
+	name string
+	md5  hash.Hash
+
+	err error
+}
+
+// NewWriter returns a Writer that writes a binary package with the given
+// control fields to w. The control fields must include Package, Version, and
+// Architecture.
+func NewWriter(w io.Writer, control Paragraph, opts *WriterOptions) (*Writer, error) {
+	if err := validateBinaryControl(control); err != nil {
+		return nil, fmt.Errorf("write deb: %w", err)
+	}
+	bw := &Writer{
+		w:       w,
+		control: append(Paragraph(nil), control...),
+		dirs:    make(map[string]bool),
+	}
+	if opts != nil {
+		bw.opts = *opts
+	}
+	if bw.opts.ModTime.IsZero() {
+		bw.opts.ModTime = time.Now()
+	}
+	for name := range bw.opts.Scripts {
+		if !isMaintainerScript(name) {
+			return nil, fmt.Errorf("write deb: %q is not a maintainer script", name)
+		}
+	}
+	for name := range bw.opts.ControlFiles {
+		if name == "control" || isMaintainerScript(name) || name == "" || strings.ContainsAny(name, "/\x00") {
+			return nil, fmt.Errorf("write deb: invalid control file name %q", name)
+		}
+	}
+	var err error
+	bw.zw, err = compress(&bw.data, bw.opts.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("write deb: %w", err)
+	}
+	bw.tw = tar.NewWriter(bw.zw)
+	if err := bw.writeDir(""); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// validateBinaryControl checks the fields that dpkg-deb requires.
+func validateBinaryControl(control Paragraph) error {
+	for _, name := range []string{"Package", "Version", "Architecture"} {
+		if control.Get(name) == "" {
+			return fmt.Errorf("control: missing %s", name)
+		}
+	}
+	if pkg := control.Get("Package"); !isValidPackageName(pkg) {
+		return fmt.Errorf("control: invalid Package %q", pkg)
+	}
+	if v := control.Get("Version"); !isValidVersion(v) {
+		return fmt.Errorf("control: invalid Version %q", v)
+	}
+	return nil
+}
+
+// isValidPackageName reports whether s is a valid package name:
+// at least two characters of lowercase letters, digits, '+', '-', or '.',
+// starting with a letter or digit.
+func isValidPackageName(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z' || isDigit(c):
+		case i > 0 && (c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isValidVersion reports whether s is a package version whose upstream
+// version starts with a digit and that has no whitespace.
+func isValidVersion(s string) bool {
+	if strings.ContainsAny(s, " \t\n") {
+		return false
+	}
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		s = s[i+1:]
+	}
+	return s != "" && isDigit(s[0])
+}
+
+func isMaintainerScript(name string) bool {
+	for _, script := range maintainerScripts {
+		if name == script {
+			return true
+		}
+	}
+	return false
+}
+
+// compress returns a writer that compresses to w.
+func compress(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case NoCompression:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case XZ:
+		return xz.NewWriter(w)
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%v compression not supported for writing", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// WriteHeader writes hdr and prepares to accept the file's content. The
+// header's name is the path where the file is installed, like "/usr/bin/foo"
+// or "usr/bin/foo". Headers without a modification time or owner get the
+// package's modification time and root ownership.
+func (bw *Writer) WriteHeader(hdr *tar.Header) error {
+	if bw.err != nil {
+		return bw.err
+	}
+	bw.finishFile()
+	name := strings.TrimPrefix(slashpath.Clean("/"+hdr.Name), "/")
+	if hdr.Typeflag == tar.TypeDir {
+		if bw.dirs[name] {
+			// Already written, possibly as a parent of an earlier file.
+			return nil
+		}
+		if err := bw.writeParents(name); err != nil {
+			return err
+		}
+		bw.dirs[name] = true
+	} else {
+		if name == "" {
+			bw.err = fmt.Errorf("write deb: %q is not a directory", hdr.Name)
+			return bw.err
+		}
+		if bw.dirs[name] {
+			bw.err = fmt.Errorf("write deb: %s: already written as a directory", hdr.Name)
+			return bw.err
+		}
+		if err := bw.writeParents(name); err != nil {
+			return err
+		}
+	}
+	h := *hdr
+	if err := bw.writeTarHeader(&h, name); err != nil {
+		return err
+	}
+	switch h.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		bw.name = name
+		bw.md5 = md5.New()
+		bw.installedSize += (h.Size + 1023) / 1024
+	default:
+		bw.installedSize++
+	}
+	return nil
+}
+
+// writeParents writes headers for any parent directories of the file at name
+// that have not been written.
+func (bw *Writer) writeParents(name string) error {
+	dir := slashpath.Dir(name)
+	if dir == "." || bw.dirs[dir] {
+		return nil
+	}
+	if err := bw.writeParents(dir); err != nil {
+		return err
+	}
+	return bw.writeDir(dir)
+}
+
+// writeDir writes a header for the directory at name, or the root directory
+// if name is empty.
+func (bw *Writer) writeDir(name string) error {
+	bw.dirs[name] = true
+	if name != "" {
+		bw.installedSize++
+	}
+	return bw.writeTarHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Mode:     0o755,
+	}, name)
+}
+
+// writeTarHeader writes a header to the data archive for the file at name,
+// filling in defaults.
+func (bw *Writer) writeTarHeader(hdr *tar.Header, name string) error {
+	hdr.Name = "./" + name
+	if hdr.Typeflag == tar.TypeDir && name != "" {
+		hdr.Name += "/"
+	}
+	bw.fillHeader(hdr)
+	if err := bw.tw.WriteHeader(hdr); err != nil {
+		bw.err = fmt.Errorf("write deb: %s: %w", hdr.Name, err)
+		return bw.err
+	}
+	return nil
+}
+
+// fillHeader fills in the defaults for a tar header in the package.
+func (bw *Writer) fillHeader(hdr *tar.Header) {
+	if hdr.ModTime.IsZero() {
+		hdr.ModTime = bw.opts.ModTime
+	}
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	if hdr.Uid == 0 && hdr.Uname == "" {
+		hdr.Uname = "root"
+	}
+	if hdr.Gid == 0 && hdr.Gname == "" {
+		hdr.Gname = "root"
+	}
+	// dpkg has supported GNU tar archives the longest.
+	hdr.Format = tar.FormatGNU
+}
+
+// finishFile records the checksum of the regular file being written, if any.
+func (bw *Writer) finishFile() {
+	if bw.md5 == nil {
+		return
+	}
+	fmt.Fprintf(&bw.md5sums, "%x  %s\n", bw.md5.Sum(nil), bw.name)
+	bw.name, bw.md5 = "", nil
+}
+
+// Write writes to the current file in the package.
+func (bw *Writer) Write(p []byte) (int, error) {
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	n, err := bw.tw.Write(p)
+	if bw.md5 != nil {
+		bw.md5.Write(p[:n])
+	}
+	if err != nil {
+		return n, fmt.Errorf("write deb: %w", err)
+	}
+	return n, nil
+}
+
+// Close writes the package to the underlying writer.
+// It does not close the underlying writer.
+func (bw *Writer) Close() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	bw.err = errors.New("write deb: writer closed")
+	bw.finishFile()
+	if err := bw.tw.Close(); err != nil {
+		return fmt.Errorf("write deb: data archive: %w", err)
+	}
+	if err := bw.zw.Close(); err != nil {
+		return fmt.Errorf("write deb: data archive: %w", err)
+	}
+	control, err := bw.controlArchive()
+	if err != nil {
+		return fmt.Errorf("write deb: control archive: %w", err)
+	}
+
+	aw := ar.NewWriter(bw.w)
+	ext := bw.opts.Compression.Extension()
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar" + ext, control},
+		{"data.tar" + ext, bw.data.Bytes()},
+	}
+	for _, m := range members {
+		err := aw.WriteHeader(&ar.Header{
+			Name:    m.name,
+			ModTime: bw.opts.ModTime,
+			Mode:    644, // Formatted in decimal.
+			Size:    int64(len(m.data)),
+		})
+		if err != nil {
+			return fmt.Errorf("write deb: %w", err)
+		}
+		if _, err := aw.Write(m.data); err != nil {
+			return fmt.Errorf("write deb: %w", err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("write deb: %w", err)
+	}
+	return nil
+}
+
+// controlArchive returns the compressed control archive.
+func (bw *Writer) controlArchive() ([]byte, error) {
+	control := append(Paragraph(nil), bw.control...)
+	if control.Get("Installed-Size") == "" {
+		control.Set("Installed-Size", strconv.FormatInt(bw.installedSize, 10))
+	}
+	SortFields(control, PackagesFieldOrder)
+	type controlFile struct {
+		name string
+		mode int64
+		data []byte
+	}
+	files := []controlFile{{"control", 0o644, []byte(control.String() + "\n")}}
+	if _, ok := bw.opts.ControlFiles["md5sums"]; !ok && bw.md5sums.Len() > 0 {
+		files = append(files, controlFile{"md5sums", 0o644, bw.md5sums.Bytes()})
+	}
+	for _, name := range sortedKeys(bw.opts.ControlFiles) {
+		files = append(files, controlFile{name, 0o644, bw.opts.ControlFiles[name]})
+	}
+	for _, name := range sortedKeys(bw.opts.Scripts) {
+		files = append(files, controlFile{name, 0o755, bw.opts.Scripts[name]})
+	}
+
+	buf := new(bytes.Buffer)
+	zw, err := compress(buf, bw.opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+	root := &tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0o755}
+	bw.fillHeader(root)
+	if err := tw.WriteHeader(root); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./" + f.name,
+			Mode:     f.mode,
+			Size:     int64(len(f.data)),
+		}
+		bw.fillHeader(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriter(t *testing.T) {
+	control := Paragraph{
+		{Name: "Description", Value: "Test package\n A package for testing."},
+		{Name: "Package", Value: "foo"},
+		{Name: "Version", Value: "1.0-1"},
+		{Name: "Architecture", Value: "all"},
+		{Name: "Maintainer", Value: "Jane Doe <jane@example.com>"},
+	}
+	const (
+		hello     = "#!/bin/sh\necho Hello, World!\n"
+		conf      = "greeting=hello\n"
+		postinst  = "#!/bin/sh\nset -e\n"
+		conffiles = "/etc/foo.conf\n"
+	)
+	files := []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{tar.Header{Typeflag: tar.TypeReg, Name: "/usr/bin/hello", Mode: 0o755, Size: int64(len(hello))}, hello},
+		{tar.Header{Typeflag: tar.TypeDir, Name: "etc", Mode: 0o755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/foo.conf", Mode: 0o644, Size: int64(len(conf))}, conf},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "./usr/bin/hi", Linkname: "hello"}, ""},
+	}
+
+	for _, c := range []Compression{NoCompression, Gzip, XZ, Zstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w, err := NewWriter(buf, control, &WriterOptions{
+				Compression:  c,
+				Scripts:      map[string][]byte{"postinst": []byte(postinst)},
+				ControlFiles: map[string][]byte{"conffiles": []byte(conffiles)},
+				ModTime:      time.Unix(1600000000, 0),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				hdr := f.hdr
+				if err := w.WriteHeader(&hdr); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.WriteString(w, f.content); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			deb := buf.Bytes()
+
+			got, err := ExtractControl(bytes.NewReader(deb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(got, []byte("Package: foo\n")) {
+				t.Errorf("ExtractControl(...) = %q; want to start with Package: foo", got)
+			}
+
+			ca, err := ReadControlArchive(bytes.NewReader(deb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "control.tar" + c.Extension(); ca.Member.Name != want {
+				t.Errorf("control member = %q; want %q", ca.Member.Name, want)
+			}
+			wantControl := "Package: foo\n" +
+				"Architecture: all\n" +
+				"Version: 1.0-1\n" +
+				"Maintainer: Jane Doe <jane@example.com>\n" +
+				// 1 KiB each for the 3 directories, the symlink,
+				// and the two regular files.
+				"Installed-Size: 6\n" +
+				"Description: Test package\n" +
+				" A package for testing.\n"
+			if diff := cmp.Diff(wantControl, string(ca.Control)); diff != "" {
+				t.Errorf("control (-want +got):\n%s", diff)
+			}
+			const wantMD5Sums = "38a88ba0fcb1f078de84ebe81db78cd7  usr/bin/hello\n" +
+				"801ef2bfa1ce9046be4eb650dabcc017  etc/foo.conf\n"
+			if diff := cmp.Diff(wantMD5Sums, string(ca.MD5Sums)); diff != "" {
+				t.Errorf("md5sums (-want +got):\n%s", diff)
+			}
+			if string(ca.Conffiles) != conffiles {
+				t.Errorf("conffiles = %q; want %q", ca.Conffiles, conffiles)
+			}
+			if got := string(ca.Scripts["postinst"]); got != postinst {
+				t.Errorf("postinst = %q; want %q", got, postinst)
+			}
+
+			r, err := NewReader(bytes.NewReader(deb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			type entry struct {
+				Name string
+				Mode int64
+			}
+			var entries []entry
+			for {
+				hdr, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.IsData() {
+					entries = append(entries, entry{hdr.Name, hdr.Mode})
+				}
+			}
+			wantEntries := []entry{
+				{"./", 0o755},
+				{"./usr/", 0o755},
+				{"./usr/bin/", 0o755},
+				{"./usr/bin/hello", 0o755},
+				{"./etc/", 0o755},
+				{"./etc/foo.conf", 0o644},
+				{"./usr/bin/hi", 0},
+			}
+			if diff := cmp.Diff(wantEntries, entries); diff != "" {
+				t.Errorf("data entries (-want +got):\n%s", diff)
+			}
+			p, err := OpenPackage(bytes.NewReader(deb), int64(len(deb)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotHello, err := p.ReadFile("/usr/bin/hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(gotHello) != hello {
+				t.Errorf("/usr/bin/hello = %q; want %q", gotHello, hello)
+			}
+
+			if c != Zstd {
+				// Older versions of dpkg-deb don't support zstd.
+				checkDpkgDeb(t, deb)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := NewWriter(ioutil.Discard, Paragraph{{Name: "Package", Value: "foo"}}, nil); err == nil {
+			t.Error("NewWriter with missing fields did not return an error")
+		}
+		bad := append(Paragraph(nil), control...)
+		bad.Set("Package", "Foo_Bar")
+		if _, err := NewWriter(ioutil.Discard, bad, nil); err == nil {
+			t.Error("NewWriter with invalid package name did not return an error")
+		}
+		bad = append(Paragraph(nil), control...)
+		bad.Set("Version", "v1.0")
+		if _, err := NewWriter(ioutil.Discard, bad, nil); err == nil {
+			t.Error("NewWriter with invalid version did not return an error")
+		}
+		if _, err := NewWriter(ioutil.Discard, control, &WriterOptions{Compression: Bzip2}); err == nil {
+			t.Error("NewWriter with bzip2 compression did not return an error")
+		}
+		if _, err := NewWriter(ioutil.Discard, control, &WriterOptions{Scripts: map[string][]byte{"install": nil}}); err == nil {
+			t.Error("NewWriter with unknown maintainer script did not return an error")
+		}
+
+		w, err := NewWriter(ioutil.Discard, control, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "foo", Size: 5}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, "abc"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err == nil {
+			t.Error("Close after short write did not return an error")
+		}
+	})
+}
+
+// checkDpkgDeb verifies a package with dpkg-deb, if it is installed.
+func checkDpkgDeb(t *testing.T, deb []byte) {
+	t.Helper()
+	dpkgDeb, err := exec.LookPath("dpkg-deb")
+	if err != nil {
+		t.Log("dpkg-deb not found; skipping check")
+		return
+	}
+	dir, err := ioutil.TempDir("", "aptblob_deb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+	debPath := filepath.Join(dir, "foo.deb")
+	if err := ioutil.WriteFile(debPath, deb, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	out, err := exec.CommandContext(ctx, dpkgDeb, "--field", debPath, "Package").CombinedOutput()
+	if err != nil {
+		t.Fatalf("dpkg-deb --field: %v\n%s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "foo" {
+		t.Errorf("dpkg-deb --field Package = %q; want \"foo\"", got)
+	}
+	extractDir := filepath.Join(dir, "root")
+	out, err = exec.CommandContext(ctx, dpkgDeb, "--extract", debPath, extractDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("dpkg-deb --extract: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(extractDir, "etc", "foo.conf")); err != nil {
+		t.Error(err)
+	}
+}
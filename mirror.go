@@ -0,0 +1,247 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// mirrorOptions is the set of options to cmdMirror.
+type mirrorOptions struct {
+	// baseURL is the URL of the upstream repository root.
+	baseURL string
+	// suite is the upstream distribution to mirror from.
+	suite string
+	// component is the upstream component to mirror from.
+	component string
+	// archs is the list of upstream architectures to mirror.
+	archs []string
+	// filters is a list of shell patterns (as in path.Match) matched against
+	// package names. If empty, every package is mirrored.
+	filters []string
+	// keyringPath is the path to an OpenPGP keyring used to verify the
+	// upstream InRelease file.
+	keyringPath string
+	// client is the HTTP client used to talk to the upstream repository.
+	// If nil, http.DefaultClient is used.
+	client *http.Client
+}
+
+// cmdMirror downloads binary packages from an upstream APT repository and
+// uploads them to comp as if they were passed to cmdUpload. The upstream
+// InRelease file must be signed by a key in the keyring, and every index and
+// package is checked against the checksums that it signs.
+func cmdMirror(ctx context.Context, bucket *blob.Bucket, comp component, keyIDs []string, validFor time.Duration, opts mirrorOptions) error {
+	if opts.keyringPath == "" {
+		return errors.New("mirror: keyring not provided")
+	}
+	if len(opts.archs) == 0 {
+		return errors.New("mirror: no architectures given")
+	}
+	for _, pattern := range opts.filters {
+		if _, err := slashpath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("mirror: filter %q: %w", pattern, err)
+		}
+	}
+	if opts.client == nil {
+		opts.client = http.DefaultClient
+	}
+	opts.baseURL = strings.TrimSuffix(opts.baseURL, "/")
+	upstreamDist := distribution(opts.suite)
+	upstreamComp := component{dist: upstreamDist, name: opts.component}
+
+	inRelease, err := fetch(ctx, opts.client, opts.baseURL+"/"+upstreamDist.signedIndexPath())
+	if err != nil {
+		return fmt.Errorf("mirror: %w", err)
+	}
+	releaseData, _, err := deb.VerifyClearSigned(ctx, inRelease, opts.keyringPath)
+	if err != nil {
+		return fmt.Errorf("mirror: %s: %w", upstreamDist.signedIndexPath(), err)
+	}
+	release, err := deb.ParseReleaseIndex(bytes.NewReader(releaseData))
+	if err != nil {
+		return fmt.Errorf("mirror: %s: %w", upstreamDist.signedIndexPath(), err)
+	}
+	if validUntil := release.Get("Valid-Until"); validUntil != "" {
+		t, err := parseReleaseDate(validUntil)
+		if err != nil {
+			return fmt.Errorf("mirror: %s: Valid-Until: %w", upstreamDist.signedIndexPath(), err)
+		}
+		if time.Now().After(t) {
+			return fmt.Errorf("mirror: %s: expired on %s", upstreamDist.signedIndexPath(), validUntil)
+		}
+	}
+	sigs, err := deb.ParseIndexSignatures(release.Get("SHA256"), sha256.Size)
+	if err != nil {
+		return fmt.Errorf("mirror: %s: SHA256: %w", upstreamDist.signedIndexPath(), err)
+	}
+
+	dir, err := ioutil.TempDir("", "aptblob_mirror")
+	if err != nil {
+		return fmt.Errorf("mirror: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	seen := make(map[string]bool)
+	for _, arch := range opts.archs {
+		key := upstreamComp.binaryIndexPath(arch)
+		packages, err := fetchIndex(ctx, opts.client, opts.baseURL+"/"+upstreamDist.dir(), strings.TrimPrefix(key, upstreamDist.dir()+"/"), sigs)
+		if err != nil {
+			return fmt.Errorf("mirror: %s: %w", key, err)
+		}
+		for _, pkg := range packages {
+			filename := pkg.Get("Filename")
+			if seen[filename] || !matchesAny(opts.filters, pkg.Get("Package")) {
+				continue
+			}
+			seen[filename] = true
+			path, err := fetchPackage(ctx, opts.client, opts.baseURL, dir, pkg)
+			if err != nil {
+				return fmt.Errorf("mirror: %w", err)
+			}
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return errors.New("mirror: no packages matched")
+	}
+	return cmdUpload(ctx, bucket, comp, paths, packageUploadOptions{keyIDs: keyIDs, validFor: validFor})
+}
+
+// fetchIndex downloads one of the variants of the index at distPath (relative
+// to the distribution directory at distURL) that is listed in sigs,
+// checks it against its signature, and parses it.
+func fetchIndex(ctx context.Context, client *http.Client, distURL string, distPath string, sigs []deb.IndexSignature) ([]deb.Paragraph, error) {
+	// Prefer the smallest variant that we know how to read.
+	for i := len(indexCompressions) - 1; i >= 0; i-- {
+		ext := indexCompressions[i]
+		sig, ok := findIndexSignature(sigs, distPath+ext)
+		if !ok {
+			continue
+		}
+		data, err := fetch(ctx, client, distURL+"/"+distPath+ext)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSHA256(data, sig); err != nil {
+			return nil, fmt.Errorf("%s: %w", distPath+ext, err)
+		}
+		return readIndex(bytes.NewReader(data), ext, deb.ControlFields)
+	}
+	return nil, errors.New("not listed in Release")
+}
+
+func findIndexSignature(sigs []deb.IndexSignature, filename string) (deb.IndexSignature, bool) {
+	for _, sig := range sigs {
+		if sig.Filename == filename {
+			return sig, true
+		}
+	}
+	return deb.IndexSignature{}, false
+}
+
+// fetchPackage downloads the binary package described by the index paragraph
+// pkg into dir, checking its size and SHA-256 checksum. It returns the path to
+// the downloaded file.
+func fetchPackage(ctx context.Context, client *http.Client, baseURL string, dir string, pkg deb.Paragraph) (string, error) {
+	filename := pkg.Get("Filename")
+	name := slashpath.Base(filename)
+	if filename == "" || !strings.HasSuffix(name, ".deb") {
+		return "", fmt.Errorf("package %s: invalid Filename %q", pkg.Get("Package"), filename)
+	}
+	checksum, err := hex.DecodeString(pkg.Get("SHA256"))
+	if err != nil || len(checksum) != sha256.Size {
+		return "", fmt.Errorf("package %s: missing or invalid SHA256", pkg.Get("Package"))
+	}
+	size, err := strconv.ParseInt(pkg.Get("Size"), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("package %s: missing or invalid Size", pkg.Get("Package"))
+	}
+	data, err := fetch(ctx, client, baseURL+"/"+filename)
+	if err != nil {
+		return "", err
+	}
+	if err := checkSHA256(data, deb.IndexSignature{Checksum: checksum, Size: size, Filename: filename}); err != nil {
+		return "", fmt.Errorf("%s: %w", filename, err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0o666); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func checkSHA256(data []byte, sig deb.IndexSignature) error {
+	if int64(len(data)) != sig.Size {
+		return fmt.Errorf("size is %d (expected %d)", len(data), sig.Size)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], sig.Checksum) {
+		return fmt.Errorf("SHA256 is %x (expected %x)", sum[:], sig.Checksum)
+	}
+	return nil
+}
+
+// fetch reads the content at the given URL.
+func fetch(ctx context.Context, client *http.Client, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", u, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", u, err)
+	}
+	return data, nil
+}
+
+// matchesAny reports whether name matches any of the given patterns.
+// An empty list of patterns matches every name.
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := slashpath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"gocloud.dev/blob/memblob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+func TestMirror(t *testing.T) {
+	keys := newTestKeyring(t, "Vendor", "Other")
+	if t.Failed() {
+		return
+	}
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not found:", err)
+	}
+	ctx := context.Background()
+	upstreamDir, err := ioutil.TempDir("", "aptblob_upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(upstreamDir) })
+
+	// Build an upstream repository with a single package.
+	debData, err := ioutil.ReadFile(filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const debFilename = "pool/main/n/nullpkg/nullpkg_1.0-1_amd64.deb"
+	writeTestFile(t, filepath.Join(upstreamDir, filepath.FromSlash(debFilename)), debData)
+	control, err := deb.ExtractControl(bytes.NewReader(debData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := deb.NewParser(bytes.NewReader(control))
+	p.Fields = deb.ControlFields
+	if !p.Single() {
+		t.Fatal(p.Err())
+	}
+	pkg := p.Paragraph()
+	debSum := sha256.Sum256(debData)
+	pkg.Set("Filename", debFilename)
+	pkg.Set("Size", strconv.Itoa(len(debData)))
+	pkg.Set("SHA256", hex.EncodeToString(debSum[:]))
+	packages := new(bytes.Buffer)
+	if err := deb.Save(packages, []deb.Paragraph{pkg}); err != nil {
+		t.Fatal(err)
+	}
+	packagesPath := filepath.Join(upstreamDir, "dists", "vendor", "main", "binary-amd64", "Packages")
+	writeTestFile(t, packagesPath, packages.Bytes())
+	packagesSum := sha256.Sum256(packages.Bytes())
+	release := deb.Paragraph{
+		{Name: "Suite", Value: "vendor"},
+		{Name: "Components", Value: "main"},
+		{Name: "Architectures", Value: "amd64"},
+		{Name: "SHA256", Value: "\n " + hex.EncodeToString(packagesSum[:]) + " " + strconv.Itoa(packages.Len()) + " main/binary-amd64/Packages"},
+	}
+	sign := exec.Command("gpg", "--batch", "--local-user", keys[0]+"!", "--clear-sign")
+	sign.Stdin = bytes.NewReader([]byte(release.String()))
+	inRelease, err := sign.Output()
+	if err != nil {
+		t.Fatal("sign upstream Release:", err)
+	}
+	writeTestFile(t, filepath.Join(upstreamDir, "dists", "vendor", "InRelease"), inRelease)
+	srv := httptest.NewServer(http.FileServer(http.Dir(upstreamDir)))
+	t.Cleanup(srv.Close)
+
+	vendorKeyring, err := exportPublicKeys(ctx, keys[:1], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendorKeyringPath := filepath.Join(upstreamDir, "vendor.asc")
+	writeTestFile(t, vendorKeyringPath, vendorKeyring)
+	otherKeyring, err := exportPublicKeys(ctx, keys[1:], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyringPath := filepath.Join(upstreamDir, "other.gpg")
+	writeTestFile(t, otherKeyringPath, otherKeyring)
+
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	opts := mirrorOptions{
+		baseURL:     srv.URL,
+		suite:       "vendor",
+		component:   "main",
+		archs:       []string{"amd64"},
+		keyringPath: otherKeyringPath,
+		client:      srv.Client(),
+	}
+	if err := cmdMirror(ctx, bucket, comp, nil, 0, opts); err == nil {
+		t.Error("mirror with wrong keyring succeeded")
+	}
+	opts.keyringPath = vendorKeyringPath
+	opts.filters = []string{"other*"}
+	if err := cmdMirror(ctx, bucket, comp, nil, 0, opts); err == nil {
+		t.Error("mirror with non-matching filter succeeded")
+	}
+	opts.filters = []string{"null*"}
+	if err := cmdMirror(ctx, bucket, comp, nil, 0, opts); err != nil {
+		t.Fatal("mirror:", err)
+	}
+	if err := checkFile(ctx, bucket, poolPath("nullpkg_1.0-1_amd64.deb"), "nullpkg_1.0-1_amd64.deb"); err != nil {
+		t.Error(err)
+	}
+	got, _, err := listParagraphs(ctx, bucket, comp.binaryIndexPath("amd64"), deb.ControlFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Get("Package") != "nullpkg" {
+		t.Errorf("mirrored %s = %v; want nullpkg", comp.binaryIndexPath("amd64"), got)
+	}
+
+	// Tamper with the upstream index.
+	writeTestFile(t, packagesPath, append(packages.Bytes(), "\n"...))
+	if err := cmdMirror(ctx, bucket, comp, nil, 0, opts); err == nil {
+		t.Error("mirror with tampered index succeeded")
+	}
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
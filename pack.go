@@ -0,0 +1,435 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// packOptions is the set of options to cmdPack.
+type packOptions struct {
+	// controlPath is the path to the package's control file. Files named
+	// *.yaml or *.yml hold a YAML mapping of field names to values; others are
+	// in the deb822 format of a binary package's DEBIAN/control file.
+	controlPath string
+	// root is the directory whose contents the package installs.
+	root string
+	// scriptsDir is a directory of maintainer scripts and other control files
+	// (like conffiles) to include in the package. It may be empty.
+	scriptsDir string
+	// compression is the compression format of the package's archives.
+	compression deb.Compression
+}
+
+// debCompressionNames maps --compression names to compression formats.
+var debCompressionNames = map[string]deb.Compression{
+	"none": deb.NoCompression,
+	"gzip": deb.Gzip,
+	"xz":   deb.XZ,
+	"zstd": deb.Zstd,
+}
+
+// Fields that apt and aptblob fill in, and so must not appear in a control
+// file given to pack.
+var packComputedFields = []string{
+	"Installed-Size",
+	"Filename",
+	"Size",
+	"MD5sum",
+	"SHA1",
+	"SHA256",
+}
+
+// cmdPack builds a binary package and writes it to outPath. If outPath is
+// empty, the package is written to the current directory with its canonical
+// name, like "foo_1.0-1_amd64.deb". The path written is printed to out and
+// added to the report.
+func cmdPack(ctx context.Context, out io.Writer, outPath string, opts packOptions) error {
+	control, err := readPackControl(opts.controlPath)
+	if err != nil {
+		return err
+	}
+	if outPath == "" {
+		outPath = debFilename(control)
+	}
+	if err := packFile(outPath, control, opts); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, outPath)
+	reportFrom(ctx).addBuilt(outPath)
+	return nil
+}
+
+// packTemp builds a binary package in dir with its canonical name and
+// returns the path to the package.
+func packTemp(dir string, opts packOptions) (string, error) {
+	control, err := readPackControl(opts.controlPath)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, debFilename(control))
+	if err := packFile(path, control, opts); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// readPackControl reads and validates a control file for pack.
+func readPackControl(path string) (deb.Paragraph, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read control: %w", err)
+	}
+	var control deb.Paragraph
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		control, err = parseYAMLControl(data)
+		if err != nil {
+			return nil, fmt.Errorf("read control: %s: %w", path, withCode(codeParseError, err))
+		}
+	default:
+		p := deb.NewParser(bytes.NewReader(data))
+		p.Fields = deb.ControlFields
+		if !p.Single() {
+			return nil, fmt.Errorf("read control: %s: %w", path, withCode(codeParseError, p.Err()))
+		}
+		control = p.Paragraph()
+	}
+	if err := validatePackControl(control); err != nil {
+		return nil, fmt.Errorf("read control: %s: %w", path, withCode(codeParseError, err))
+	}
+	return control, nil
+}
+
+// parseYAMLControl parses a control file written as a YAML mapping, like:
+//
+//	Package: hello
+//	Version: "1.0-1"
+//	Depends: [libc6 (>= 2.17), adduser]
+//	Description: |
+//	  Say hello
+//	  Prints a greeting.
+//
+// Fields keep their order. Lists are only allowed in folded fields like
+// Depends, and are joined with commas. Booleans become "yes" or "no". Lines
+// after the first in a multi-line Description are indented and blank lines
+// become ".", as in a deb822 file.
+func parseYAMLControl(data []byte) (deb.Paragraph, error) {
+	var fields yaml.MapSlice
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("empty control file")
+	}
+	control := make(deb.Paragraph, 0, len(fields))
+	for _, item := range fields {
+		name, ok := item.Key.(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("field name %v is not a string", item.Key)
+		}
+		if control.Get(name) != "" {
+			return nil, fmt.Errorf("duplicate field %q", name)
+		}
+		value, err := yamlControlValue(name, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		control = append(control, deb.Field{Name: name, Value: value})
+	}
+	return control, nil
+}
+
+// yamlControlValue converts a YAML value to the value of the named control
+// field.
+func yamlControlValue(name string, v interface{}) (string, error) {
+	typ := deb.ControlFields[name]
+	var value string
+	switch v := v.(type) {
+	case string:
+		value = v
+	case int:
+		value = strconv.Itoa(v)
+	case bool:
+		value = "no"
+		if v {
+			value = "yes"
+		}
+	case []interface{}:
+		if typ != deb.Folded {
+			return "", fmt.Errorf("field %q must not be a list", name)
+		}
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("field %q: list item %v is not a string", name, item)
+			}
+			items = append(items, s)
+		}
+		value = strings.Join(items, ", ")
+	case nil:
+		return "", fmt.Errorf("empty field %q", name)
+	default:
+		// Floats in particular lose their formatting: 1.10 would become 1.1.
+		return "", fmt.Errorf("field %q: unsupported value %v (quote it to use it as a string)", name, v)
+	}
+	value = strings.Trim(strings.TrimRight(value, "\n"), " \t")
+	if value == "" {
+		return "", fmt.Errorf("empty field %q", name)
+	}
+	if !strings.Contains(value, "\n") {
+		return value, nil
+	}
+	switch typ {
+	case deb.Folded:
+		return strings.ReplaceAll(value, "\n", " "), nil
+	case deb.Multiline:
+		lines := strings.Split(value, "\n")
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "" {
+				lines[i] = "."
+			}
+			lines[i] = " " + lines[i]
+		}
+		return strings.Join(lines, "\n"), nil
+	default:
+		return "", fmt.Errorf("field %q must be a single line", name)
+	}
+}
+
+// validatePackControl checks that a control paragraph has the fields
+// required of a binary package and none of the fields that are computed.
+func validatePackControl(control deb.Paragraph) error {
+	for _, name := range []string{"Package", "Version", "Architecture", "Maintainer", "Description"} {
+		if control.Get(name) == "" {
+			return fmt.Errorf("missing %s", name)
+		}
+	}
+	for _, name := range packComputedFields {
+		if control.Get(name) != "" {
+			return fmt.Errorf("%s is computed automatically and must not be set", name)
+		}
+	}
+	if synopsis := strings.SplitN(control.Get("Description"), "\n", 2)[0]; strings.TrimSpace(synopsis) == "" {
+		return errors.New("Description: missing synopsis on first line")
+	}
+	// Unmarshaling checks the syntax of the relationship fields.
+	return deb.Unmarshal(control, new(deb.BinaryPackage))
+}
+
+// debFilename returns the canonical file name of a binary package,
+// like "foo_1.0-1_amd64.deb". The epoch is omitted, as in Debian archives.
+func debFilename(control deb.Paragraph) string {
+	version := control.Get("Version")
+	if i := strings.IndexByte(version, ':'); i != -1 {
+		version = version[i+1:]
+	}
+	return control.Get("Package") + "_" + version + "_" + control.Get("Architecture") + ".deb"
+}
+
+// packFile builds a binary package and writes it to path, which must not be
+// inside the package's root directory.
+func packFile(path string, control deb.Paragraph, opts packOptions) (err error) {
+	if inside, err := pathWithin(path, opts.root); err != nil {
+		return fmt.Errorf("pack: %w", err)
+	} else if inside {
+		return fmt.Errorf("pack: %s is inside the package root %s", path, opts.root)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("pack: %w", closeErr)
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	return pack(f, control, opts)
+}
+
+// pathWithin reports whether path is dir or a path inside it.
+func pathWithin(path, dir string) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		// Paths on different volumes.
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+// pack writes a binary package with the given control fields to w.
+// If SOURCE_DATE_EPOCH is set, no file in the package has a modification time
+// later than it, so that the package is reproducible.
+func pack(w io.Writer, control deb.Paragraph, opts packOptions) error {
+	writerOpts := &deb.WriterOptions{Compression: opts.compression}
+	epoch, hasEpoch, err := sourceDateEpoch()
+	if err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	if hasEpoch {
+		writerOpts.ModTime = epoch
+	}
+	if opts.scriptsDir != "" {
+		writerOpts.Scripts, writerOpts.ControlFiles, err = readPackScripts(opts.scriptsDir)
+		if err != nil {
+			return fmt.Errorf("pack: %w", err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(opts.root, "DEBIAN")); err == nil && info.IsDir() {
+		return fmt.Errorf("pack: %s: DEBIAN directory is not installed (use --scripts for maintainer scripts)", opts.root)
+	}
+	dw, err := deb.NewWriter(w, control, writerOpts)
+	if err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	err = filepath.Walk(opts.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == opts.root {
+			return nil
+		}
+		rel, err := filepath.Rel(opts.root, path)
+		if err != nil {
+			return err
+		}
+		modTime := info.ModTime()
+		if hasEpoch && modTime.After(epoch) {
+			modTime = epoch
+		}
+		// Files are always owned by root, like dpkg-deb --root-owner-group.
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(rel),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: modTime,
+		}
+		if info.Mode()&os.ModeSetuid != 0 {
+			hdr.Mode |= 0o4000
+		}
+		if info.Mode()&os.ModeSetgid != 0 {
+			hdr.Mode |= 0o2000
+		}
+		if info.Mode()&os.ModeSticky != 0 {
+			hdr.Mode |= 0o1000
+		}
+		switch {
+		case info.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+			return packRegularFile(dw, hdr, path)
+		case info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			return dw.WriteHeader(hdr)
+		case info.Mode()&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return dw.WriteHeader(hdr)
+		default:
+			return fmt.Errorf("%s: unsupported file type %v", path, info.Mode()&os.ModeType)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	if err := dw.Close(); err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	return nil
+}
+
+func packRegularFile(dw *deb.Writer, hdr *tar.Header, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := dw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	n, err := io.Copy(dw, f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if n != hdr.Size {
+		return fmt.Errorf("%s: changed size while packing", path)
+	}
+	return nil
+}
+
+// readPackScripts reads the files in dir, returning the maintainer scripts
+// and the other control files separately.
+func readPackScripts(dir string) (scripts, controlFiles map[string][]byte, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() {
+			return nil, nil, fmt.Errorf("%s: not a regular file", filepath.Join(dir, name))
+		}
+		if name == "control" || name == "md5sums" {
+			return nil, nil, fmt.Errorf("%s: %s is generated and must not be in the scripts directory", filepath.Join(dir, name), name)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		switch name {
+		case "preinst", "postinst", "prerm", "postrm", "config":
+			if scripts == nil {
+				scripts = make(map[string][]byte)
+			}
+			scripts[name] = data
+		default:
+			if controlFiles == nil {
+				controlFiles = make(map[string][]byte)
+			}
+			controlFiles[name] = data
+		}
+	}
+	return scripts, controlFiles, nil
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+const testPackControl = "Package: hello\n" +
+	"Version: 1:1.0-1\n" +
+	"Architecture: amd64\n" +
+	"Maintainer: Jane Doe <jane@example.com>\n" +
+	"Depends: libc6 (>= 2.17)\n" +
+	"Description: Say hello\n" +
+	" Prints a greeting.\n"
+
+func TestPack(t *testing.T) {
+	dir := t.TempDir()
+	controlPath := filepath.Join(dir, "control")
+	writeTestFile(t, controlPath, []byte(testPackControl))
+	root := filepath.Join(dir, "root")
+	writeTestFile(t, filepath.Join(root, "usr", "bin", "hello"), []byte("#!/bin/sh\necho hello\n"))
+	if err := os.Chmod(filepath.Join(root, "usr", "bin", "hello"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "etc", "hello.conf"), []byte("greeting=hello\n"))
+	scriptsDir := filepath.Join(dir, "scripts")
+	writeTestFile(t, filepath.Join(scriptsDir, "postinst"), []byte("#!/bin/sh\nset -e\n"))
+	writeTestFile(t, filepath.Join(scriptsDir, "conffiles"), []byte("/etc/hello.conf\n"))
+	opts := packOptions{
+		controlPath: controlPath,
+		root:        root,
+		scriptsDir:  scriptsDir,
+		compression: deb.XZ,
+	}
+
+	t.Run("Build", func(t *testing.T) {
+		outPath := filepath.Join(t.TempDir(), "hello.deb")
+		out := new(bytes.Buffer)
+		if err := cmdPack(context.Background(), out, outPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := out.String(), outPath+"\n"; got != want {
+			t.Errorf("output = %q; want %q", got, want)
+		}
+		f, err := os.Open(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		ca, err := deb.ReadControlArchive(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const wantControl = "Package: hello\n" +
+			"Architecture: amd64\n" +
+			"Version: 1:1.0-1\n" +
+			"Maintainer: Jane Doe <jane@example.com>\n" +
+			"Installed-Size: 5\n" +
+			"Depends: libc6 (>= 2.17)\n" +
+			"Description: Say hello\n" +
+			" Prints a greeting.\n"
+		if diff := cmp.Diff(wantControl, string(ca.Control)); diff != "" {
+			t.Errorf("control (-want +got):\n%s", diff)
+		}
+		const wantMD5Sums = "801ef2bfa1ce9046be4eb650dabcc017  etc/hello.conf\n" +
+			"d604a220708aa59433ba410986cd4ffa  usr/bin/hello\n"
+		if diff := cmp.Diff(wantMD5Sums, string(ca.MD5Sums)); diff != "" {
+			t.Errorf("md5sums (-want +got):\n%s", diff)
+		}
+		if got, want := string(ca.Scripts["postinst"]), "#!/bin/sh\nset -e\n"; got != want {
+			t.Errorf("postinst = %q; want %q", got, want)
+		}
+		if got, want := string(ca.Conffiles), "/etc/hello.conf\n"; got != want {
+			t.Errorf("conffiles = %q; want %q", got, want)
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := deb.OpenPackage(f, info.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := p.Stat("/usr/bin/hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Mode != 0o755 || hdr.Uname != "root" || hdr.Gname != "root" {
+			t.Errorf("/usr/bin/hello mode = %#o, owner = %s:%s; want 0755, root:root", hdr.Mode, hdr.Uname, hdr.Gname)
+		}
+	})
+
+	t.Run("OutInsideRoot", func(t *testing.T) {
+		outPath := filepath.Join(root, "hello.deb")
+		if err := cmdPack(context.Background(), new(bytes.Buffer), outPath, opts); err == nil {
+			t.Error("cmdPack did not return an error")
+		}
+		if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+			t.Errorf("%s exists after failed pack", outPath)
+		}
+	})
+
+	t.Run("DefaultName", func(t *testing.T) {
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		outDir := t.TempDir()
+		if err := os.Chdir(outDir); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := os.Chdir(wd); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		if err := cmdPack(context.Background(), ioutil.Discard, "", opts); err != nil {
+			t.Fatal(err)
+		}
+		// The epoch is not part of the file name.
+		if _, err := os.Stat(filepath.Join(outDir, "hello_1.0-1_amd64.deb")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		rep := &report{enabled: true, command: "pack"}
+		outPath := filepath.Join(t.TempDir(), "hello.deb")
+		if err := cmdPack(withReport(context.Background(), rep), ioutil.Discard, outPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := rep.write(buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Built []string
+		}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("%v\n%s", err, buf)
+		}
+		if diff := cmp.Diff([]string{outPath}, got.Built); diff != "" {
+			t.Errorf("built (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Reproducible", func(t *testing.T) {
+		setSourceDateEpoch(t, "1600000000")
+		var debs [2][]byte
+		for i := range debs {
+			buf := new(bytes.Buffer)
+			control, err := readPackControl(controlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pack(buf, control, opts); err != nil {
+				t.Fatal(err)
+			}
+			debs[i] = buf.Bytes()
+		}
+		if !bytes.Equal(debs[0], debs[1]) {
+			t.Error("packages built with the same SOURCE_DATE_EPOCH differ")
+		}
+	})
+
+	t.Run("Upload", func(t *testing.T) {
+		ctx := context.Background()
+		bucket := memblob.OpenBucket(nil)
+		debPath, err := packTemp(t.TempDir(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{debPath}, packageUploadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		packages, _, err := listParagraphs(ctx, bucket, "dists/stable/main/binary-amd64/Packages", deb.ControlFields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packages) != 1 || packages[0].Get("Package") != "hello" || packages[0].Get("Installed-Size") != "5" {
+			t.Errorf("Packages = %v; want hello with Installed-Size: 5", packages)
+		}
+	})
+}
+
+func TestValidatePackControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		control string
+	}{
+		{"MissingMaintainer", "Package: foo\nVersion: 1.0\nArchitecture: all\nDescription: Foo\n"},
+		{"MissingDescription", "Package: foo\nVersion: 1.0\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\n"},
+		{"EmptySynopsis", "Package: foo\nVersion: 1.0\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nDescription:\n Foo\n"},
+		{"InstalledSize", "Package: foo\nVersion: 1.0\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nInstalled-Size: 1\nDescription: Foo\n"},
+		{"Filename", "Package: foo\nVersion: 1.0\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nFilename: pool/foo.deb\nDescription: Foo\n"},
+		{"BadDepends", "Package: foo\nVersion: 1.0\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nDepends: bar (>>)\nDescription: Foo\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controlPath := filepath.Join(t.TempDir(), "control")
+			writeTestFile(t, controlPath, []byte(test.control))
+			if _, err := readPackControl(controlPath); err == nil {
+				t.Error("readPackControl did not return an error")
+			} else if got := errorCode(err); got != codeParseError {
+				t.Errorf("errorCode(%v) = %q; want %q", err, got, codeParseError)
+			}
+		})
+	}
+}
+
+func TestReadPackControlYAML(t *testing.T) {
+	const yamlControl = "Package: hello\n" +
+		"Version: \"1:1.0-1\"\n" +
+		"Architecture: amd64\n" +
+		"Maintainer: Jane Doe <jane@example.com>\n" +
+		"Essential: no\n" +
+		"Depends: [libc6 (>= 2.17), adduser]\n" +
+		"Description: |\n" +
+		"  Say hello\n" +
+		"  Prints a greeting.\n" +
+		"\n" +
+		"  Then exits.\n"
+	const debControl = "Package: hello\n" +
+		"Version: 1:1.0-1\n" +
+		"Architecture: amd64\n" +
+		"Maintainer: Jane Doe <jane@example.com>\n" +
+		"Essential: no\n" +
+		"Depends: libc6 (>= 2.17), adduser\n" +
+		"Description: Say hello\n" +
+		" Prints a greeting.\n" +
+		" .\n" +
+		" Then exits.\n"
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "control.yaml")
+	writeTestFile(t, yamlPath, []byte(yamlControl))
+	debPath := filepath.Join(dir, "control")
+	writeTestFile(t, debPath, []byte(debControl))
+
+	got, err := readPackControl(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := readPackControl(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readPackControl(%q) (-deb822 +yaml):\n%s", "control.yaml", diff)
+	}
+
+	tests := []struct {
+		name    string
+		control string
+	}{
+		{"Syntax", "Package: [hello\n"},
+		{"Empty", ""},
+		{"FloatVersion", "Package: foo\nVersion: 1.10\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nDescription: Foo\n"},
+		{"ListMaintainer", "Package: foo\nVersion: \"1.0\"\nArchitecture: all\nMaintainer: [Jane Doe <jane@example.com>]\nDescription: Foo\n"},
+		{"MultilineVersion", "Package: foo\nVersion: |\n  1.0\n  2.0\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nDescription: Foo\n"},
+		{"MissingDescription", "Package: foo\nVersion: \"1.0\"\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\n"},
+		{"InstalledSize", "Package: foo\nVersion: \"1.0\"\nArchitecture: all\nMaintainer: Jane Doe <jane@example.com>\nInstalled-Size: 1\nDescription: Foo\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controlPath := filepath.Join(t.TempDir(), "control.yml")
+			writeTestFile(t, controlPath, []byte(test.control))
+			if _, err := readPackControl(controlPath); err == nil {
+				t.Error("readPackControl did not return an error")
+			} else if got := errorCode(err); got != codeParseError {
+				t.Errorf("errorCode(%v) = %q; want %q", err, got, codeParseError)
+			}
+		})
+	}
+}
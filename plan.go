@@ -0,0 +1,116 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// plan describes the changes that a command run with --dry-run would make.
+// When the context has a plan, upload, uploadIndex, uploadReleaseIndex,
+// copyObject, deleteObject, and syncObject write what they would do to the
+// plan instead of changing the bucket.
+// Everything else, including reading the bucket, parsing, and hashing,
+// happens as usual, so a dry run fails in the same ways as a real one.
+type plan struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type planContextKey struct{}
+
+// withDryRun returns a context whose commands write a plan to w instead of
+// changing the bucket.
+func withDryRun(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, planContextKey{}, &plan{w: w})
+}
+
+// planFrom returns the plan in the context or nil if this is not a dry run.
+func planFrom(ctx context.Context) *plan {
+	p, _ := ctx.Value(planContextKey{}).(*plan)
+	return p
+}
+
+// upload records an object that would be written, like a pool object.
+func (p *plan) upload(key string, h indexHashes) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "would upload %s (%d bytes, SHA256 %x)\n", key, h.size, h.sha256)
+}
+
+// copy records an object that would be copied from key to the key to, which
+// is empty if it is the same.
+func (p *plan) copy(key, to string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if to == "" {
+		fmt.Fprintf(p.w, "would copy %s (%d bytes)\n", key, size)
+	} else {
+		fmt.Fprintf(p.w, "would copy %s to %s (%d bytes)\n", key, to, size)
+	}
+}
+
+// diff records the change to a text file that would be written.
+func (p *plan) diff(key string, oldText, newText string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d := unifiedDiff("a/"+key, "b/"+key, oldText, newText); d != "" {
+		io.WriteString(p.w, d)
+	}
+}
+
+// delete records an object that would be deleted.
+func (p *plan) delete(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "would delete %s\n", key)
+}
+
+// downloadIndexText returns the uncompressed content of the index at key,
+// reading whichever variant of the index is present in the bucket. It returns
+// the empty string if no variant is present.
+func downloadIndexText(ctx context.Context, bucket *blob.Bucket, key string) (string, error) {
+	for _, ext := range indexCompressions {
+		r, err := bucket.NewReader(ctx, key+ext, nil)
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key+ext, err)
+		}
+		dr, err := decompressIndex(r, ext)
+		if err != nil {
+			r.Close()
+			return "", fmt.Errorf("%s: %w", key+ext, err)
+		}
+		data, err := ioutil.ReadAll(dr)
+		dr.Close()
+		r.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key+ext, err)
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	comp := component{dist: "stable", name: "main"}
+
+	t.Run("UploadEmpty", func(t *testing.T) {
+		bucket := memblob.OpenBucket(nil)
+		out := new(bytes.Buffer)
+		err := cmdUpload(withDryRun(ctx, out), bucket, comp, []string{
+			filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+			filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+		}, packageUploadOptions{})
+		if err != nil {
+			t.Fatal("upload:", err)
+		}
+		for _, want := range []string{
+			"would upload pool/nullpkg_1.0-1_amd64.deb (",
+			"would upload pool/nullpkg_1.0-1/nullpkg_1.0.orig.tar.gz (",
+			"+++ b/dists/stable/main/binary-amd64/Packages\n",
+			"+Package: nullpkg\n",
+			"+++ b/dists/stable/main/source/Sources\n",
+			"+++ b/dists/stable/Release\n",
+			"+Components: main\n",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("output does not contain %q:\n%s", want, out)
+			}
+		}
+		keys, err := listKeys(ctx, bucket, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) > 0 {
+			t.Errorf("dry run wrote %q", keys)
+		}
+	})
+
+	t.Run("Init", func(t *testing.T) {
+		bucket := memblob.OpenBucket(nil)
+		if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{}); err != nil {
+			t.Fatal("upload:", err)
+		}
+		before, err := bucket.ReadAll(ctx, testReleaseKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		stdin := strings.NewReader("Origin: example\nLabel: example\n")
+		if err := cmdInit(withDryRun(ctx, out), bucket, stdin, ioutil.Discard, "stable", nil, 0); err != nil {
+			t.Fatal("init:", err)
+		}
+		for _, want := range []string{"+Origin: example\n", "-Components: main\n"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("output does not contain %q:\n%s", want, out)
+			}
+		}
+		after, err := bucket.ReadAll(ctx, testReleaseKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Errorf("dry run changed %s", testReleaseKey)
+		}
+	})
+	// Commands that copy and delete objects leave the bucket as it was.
+	bucket := memblob.OpenBucket(nil)
+	if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{}); err != nil {
+		t.Fatal("upload:", err)
+	}
+	if err := cmdSnapshot(ctx, bucket, "stable", "good"); err != nil {
+		t.Fatal("snapshot:", err)
+	}
+	if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1.dsc")}, packageUploadOptions{}); err != nil {
+		t.Fatal("upload:", err)
+	}
+	tests := []struct {
+		name string
+		run  func(ctx context.Context) error
+		want []string
+	}{
+		{
+			name: "Snapshot",
+			run: func(ctx context.Context) error {
+				return cmdSnapshot(ctx, bucket, "stable", "next")
+			},
+			want: []string{"would copy dists/stable/Release to snapshots/stable/next/Release ("},
+		},
+		{
+			name: "Rollback",
+			run: func(ctx context.Context) error {
+				return cmdRollback(ctx, bucket, "stable", "good", nil)
+			},
+			want: []string{
+				"would copy snapshots/stable/good/main/binary-amd64/Packages to dists/stable/main/binary-amd64/Packages (",
+				"+++ b/dists/stable/Release\n",
+				"would delete dists/stable/main/source/Sources\n",
+			},
+		},
+		{
+			name: "Freeze",
+			run: func(ctx context.Context) error {
+				return cmdFreeze(ctx, bucket, "stable", "frozen", nil, freezeOptions{})
+			},
+			want: []string{
+				"would copy dists/stable/main/source/Sources to dists/frozen/main/source/Sources (",
+				"+Suite: frozen\n",
+			},
+		},
+		{
+			name: "Sync",
+			run: func(ctx context.Context) error {
+				dst := memblob.OpenBucket(nil)
+				if err := cmdSync(ctx, dst, bucket, nil); err != nil {
+					return err
+				}
+				if keys, err := listKeys(ctx, dst, ""); err != nil {
+					return err
+				} else if len(keys) > 0 {
+					t.Errorf("dry run wrote %q to destination", keys)
+				}
+				return nil
+			},
+			want: []string{
+				"would copy pool/nullpkg_1.0-1_amd64.deb (",
+				"would copy dists/stable/Release (",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before, err := readAllObjects(ctx, bucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := new(bytes.Buffer)
+			if err := test.run(withDryRun(ctx, out)); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, out)
+				}
+			}
+			checkUnchanged(ctx, t, bucket, before)
+		})
+	}
+
+	t.Run("PublishKey", func(t *testing.T) {
+		keys := newTestKeyring(t, "Example")
+		if t.Failed() {
+			return
+		}
+		before, err := readAllObjects(ctx, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		err = cmdPublishKey(withDryRun(ctx, out), bucket, "stable", keys, publishKeyOptions{
+			baseURL: "https://apt.example.com",
+			name:    "example",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"would upload example-archive-keyring.gpg (", "would upload example.sources ("} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("output does not contain %q:\n%s", want, out)
+			}
+		}
+		checkUnchanged(ctx, t, bucket, before)
+	})
+}
+
+// checkUnchanged reports an error if the bucket's objects differ from before.
+func checkUnchanged(ctx context.Context, t *testing.T, bucket *blob.Bucket, before map[string]string) {
+	t.Helper()
+	after, err := readAllObjects(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(before, after); diff != "" {
+		t.Errorf("dry run changed bucket (-before +after):\n%s", diff)
+	}
+}
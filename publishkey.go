@@ -0,0 +1,181 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gocloud.dev/blob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// publishKeyOptions is the set of options to cmdPublishKey.
+type publishKeyOptions struct {
+	// baseURL is the URL that clients use to reach the repository root.
+	baseURL string
+	// name is the name of the repository, used to derive default paths.
+	name string
+	// keyPath is the key of the published keyring without an extension.
+	// Defaults to name + "-archive-keyring".
+	keyPath string
+	// sourcesPath is the key of the published sources file.
+	// Defaults to name + ".sources".
+	sourcesPath string
+	// signedBy is the path on clients where the keyring is installed.
+	// Defaults to "/usr/share/keyrings/" + name + "-archive-keyring.gpg".
+	signedBy string
+}
+
+// cmdPublishKey exports the public signing keys into the bucket as both a
+// binary keyring and an ASCII-armored file, then writes a deb822 sources file
+// that clients can drop into /etc/apt/sources.list.d.
+func cmdPublishKey(ctx context.Context, bucket *blob.Bucket, dist distribution, keyIDs []string, opts publishKeyOptions) error {
+	if len(keyIDs) == 0 {
+		return errors.New("key ID not provided")
+	}
+	if opts.baseURL == "" {
+		return errors.New("base URL not provided")
+	}
+	if opts.name == "" {
+		opts.name = string(dist)
+	}
+	if opts.keyPath == "" {
+		opts.keyPath = opts.name + "-archive-keyring"
+	}
+	if opts.sourcesPath == "" {
+		opts.sourcesPath = opts.name + ".sources"
+	}
+	if opts.signedBy == "" {
+		opts.signedBy = "/usr/share/keyrings/" + opts.name + "-archive-keyring.gpg"
+	}
+
+	release, err := downloadReleaseIndex(ctx, bucket, dist)
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		return withCode(codeNotFound, fmt.Errorf("%s not found", dist.indexPath()))
+	}
+	sources, err := sourcesParagraph(release, dist, opts.baseURL, opts.signedBy)
+	if err != nil {
+		return err
+	}
+
+	keyring, err := exportPublicKeys(ctx, keyIDs, false)
+	if err != nil {
+		return err
+	}
+	armoredKeyring, err := exportPublicKeys(ctx, keyIDs, true)
+	if err != nil {
+		return err
+	}
+	_, err = upload(ctx, bucket, opts.keyPath+".gpg", bytes.NewReader(keyring), uploadOptions{
+		contentType:  "application/pgp-keys",
+		cacheControl: "max-age=300",
+		report:       true,
+	})
+	if err != nil {
+		return fmt.Errorf("upload keyring: %w", err)
+	}
+	_, err = upload(ctx, bucket, opts.keyPath+".asc", bytes.NewReader(armoredKeyring), uploadOptions{
+		contentType:  "application/pgp-keys",
+		cacheControl: "max-age=300",
+		report:       true,
+	})
+	if err != nil {
+		return fmt.Errorf("upload armored keyring: %w", err)
+	}
+	sourcesData := new(bytes.Buffer)
+	if err := deb.Save(sourcesData, []deb.Paragraph{sources}); err != nil {
+		return err
+	}
+	_, err = upload(ctx, bucket, opts.sourcesPath, bytes.NewReader(sourcesData.Bytes()), uploadOptions{
+		contentType:  "text/plain; charset=utf-8",
+		cacheControl: "max-age=300",
+		report:       true,
+	})
+	if err != nil {
+		return fmt.Errorf("upload sources: %w", err)
+	}
+	return nil
+}
+
+// sourcesParagraph returns a deb822-style APT sources entry for a distribution.
+// See sources.list(5) for the format.
+func sourcesParagraph(release deb.Paragraph, dist distribution, baseURL string, signedBy string) (deb.Paragraph, error) {
+	components := release.Get("Components")
+	if components == "" {
+		return nil, fmt.Errorf("%s: no Components", dist.indexPath())
+	}
+	indexes, err := listedIndexes(release)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dist.indexPath(), err)
+	}
+	types := "deb"
+	for _, distPath := range indexes {
+		if strings.HasSuffix(distPath, "/source/Sources") {
+			types += " deb-src"
+			break
+		}
+	}
+	var archs []string
+	for _, arch := range strings.Fields(release.Get("Architectures")) {
+		if arch != "all" {
+			archs = append(archs, arch)
+		}
+	}
+
+	para := deb.Paragraph{
+		{Name: "Types", Value: types},
+		{Name: "URIs", Value: baseURL},
+		{Name: "Suites", Value: string(dist)},
+		{Name: "Components", Value: components},
+	}
+	if len(archs) > 0 {
+		para.Set("Architectures", strings.Join(archs, " "))
+	}
+	para.Set("Signed-By", signedBy)
+	return para, nil
+}
+
+// exportPublicKeys uses gpg to export the given public keys.
+func exportPublicKeys(ctx context.Context, keyIDs []string, armor bool) ([]byte, error) {
+	args := []string{"--batch", "--export-options", "export-minimal"}
+	if armor {
+		args = append(args, "--armor")
+	}
+	args = append(args, "--export", "--")
+	args = append(args, keyIDs...)
+	c := exec.CommandContext(ctx, "gpg", args...)
+	out := new(bytes.Buffer)
+	c.Stdout = out
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("export keys: %w", err)
+	}
+	if out.Len() == 0 {
+		// gpg succeeds even if none of the keys could be found.
+		return nil, fmt.Errorf("export keys: no keys found for %s", strings.Join(keyIDs, ", "))
+	}
+	return out.Bytes(), nil
+}
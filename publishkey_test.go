@@ -0,0 +1,105 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+func TestSourcesParagraph(t *testing.T) {
+	release := deb.Paragraph{
+		{Name: "Codename", Value: "stable"},
+		{Name: "Components", Value: "main contrib"},
+		{Name: "Architectures", Value: "amd64 all arm64"},
+		{Name: "SHA256", Value: "\n" +
+			" 0000000000000000000000000000000000000000000000000000000000000000 0 main/binary-amd64/Packages\n" +
+			" 0000000000000000000000000000000000000000000000000000000000000000 0 main/source/Sources.gz"},
+	}
+	got, err := sourcesParagraph(release, "stable", "https://apt.example.com", "/usr/share/keyrings/example.gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := deb.Paragraph{
+		{Name: "Types", Value: "deb deb-src"},
+		{Name: "URIs", Value: "https://apt.example.com"},
+		{Name: "Suites", Value: "stable"},
+		{Name: "Components", Value: "main contrib"},
+		{Name: "Architectures", Value: "amd64 arm64"},
+		{Name: "Signed-By", Value: "/usr/share/keyrings/example.gpg"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sourcesParagraph(...) (-want +got):\n%s", diff)
+	}
+}
+
+func TestPublishKey(t *testing.T) {
+	keys := newTestKeyring(t, "Example")
+	if t.Failed() {
+		return
+	}
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{keyIDs: keys})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	err = cmdPublishKey(ctx, bucket, "stable", keys, publishKeyOptions{
+		baseURL: "https://apt.example.com",
+		name:    "example",
+		keyPath: "keys/example",
+	})
+	if err != nil {
+		t.Fatal("publish-key:", err)
+	}
+
+	keyring, err := bucket.ReadAll(ctx, "keys/example.gpg")
+	if err != nil {
+		t.Error(err)
+	} else if len(keyring) == 0 {
+		t.Error("keys/example.gpg is empty")
+	}
+	armored, err := bucket.ReadAll(ctx, "keys/example.asc")
+	if err != nil {
+		t.Error(err)
+	} else if !bytes.HasPrefix(armored, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		t.Errorf("keys/example.asc = %q; want armored public key", armored)
+	}
+	got, _, err := listParagraphs(ctx, bucket, "example.sources", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []deb.Paragraph{{
+		{Name: "Types", Value: "deb"},
+		{Name: "URIs", Value: "https://apt.example.com"},
+		{Name: "Suites", Value: "stable"},
+		{Name: "Components", Value: "main"},
+		{Name: "Architectures", Value: "amd64"},
+		{Name: "Signed-By", Value: "/usr/share/keyrings/example-archive-keyring.gpg"},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("example.sources (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"gocloud.dev/gcerrors"
+	"zombiezen.com/go/aptblob/internal/deb"
+)
+
+// Error codes. These are reported in JSON output and determine the exit
+// status, so they must not change.
+const (
+	codeSignatureRequired = "signature-required"
+	codeImmutableConflict = "immutable-conflict"
+	codeParseError        = "parse-error"
+	codeNotFound          = "not-found"
+)
+
+// exitCodes maps error codes to process exit statuses.
+// Errors without a code exit with status 1.
+var exitCodes = map[string]int{
+	codeSignatureRequired: 3,
+	codeImmutableConflict: 4,
+	codeParseError:        5,
+	codeNotFound:          6,
+}
+
+// codedError is an error with one of the error codes.
+type codedError struct {
+	code string
+	err  error
+}
+
+// withCode returns an error that has the given code.
+func withCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// errorCode returns the code of the first coded error in err's chain.
+// Missing objects in a bucket and missing local files are reported as
+// not-found. errorCode returns the empty string for any other error.
+func errorCode(err error) string {
+	var ce *codedError
+	switch {
+	case errors.As(err, &ce):
+		return ce.code
+	case gcerrors.Code(err) == gcerrors.NotFound || errors.Is(err, os.ErrNotExist):
+		return codeNotFound
+	default:
+		return ""
+	}
+}
+
+// exitCode returns the process exit status for an error.
+func exitCode(err error) int {
+	if code, ok := exitCodes[errorCode(err)]; ok {
+		return code
+	}
+	return 1
+}
+
+// report is the result of a command, written by --output=json.
+// Commands add to the report in their context as they change the bucket.
+// A nil *report ignores additions, so commands don't have to check whether
+// JSON output was requested.
+type report struct {
+	// enabled is set once the command line has been parsed and JSON output
+	// was requested. Until then, reportFrom returns nil.
+	enabled bool
+	command string
+	dryRun  bool
+
+	mu        sync.Mutex
+	built     []string
+	uploaded  []reportObject
+	copied    []reportCopy
+	deleted   []string
+	indexes   []reportIndex
+	releases  []reportRelease
+	snapshots []reportSnapshot
+}
+
+// reportObject is an object written by a command, like a pool object or a
+// published keyring.
+type reportObject struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	// Signer is the fingerprint of the key that signed the object
+	// if the signature was verified.
+	Signer string `json:"signer,omitempty"`
+	// Existed is true if an identical object was already in the bucket.
+	Existed bool `json:"existed,omitempty"`
+}
+
+// reportCopy is an object copied by the command.
+type reportCopy struct {
+	Key string `json:"key"`
+	// To is the key that the object was copied to. It is omitted if the
+	// object was copied to the same key somewhere else: another bucket for
+	// sync, or the destination directory or tar file for export.
+	To   string `json:"to,omitempty"`
+	Size int64  `json:"size"`
+	// Existed is true if an identical object was already at the destination.
+	Existed bool `json:"existed,omitempty"`
+}
+
+// reportIndex is an index file whose checksum in a Release file changed.
+// OldSHA256 is empty for new indexes and NewSHA256 is empty for removed
+// indexes.
+type reportIndex struct {
+	Key       string `json:"key"`
+	OldSHA256 string `json:"oldSHA256,omitempty"`
+	NewSHA256 string `json:"newSHA256,omitempty"`
+}
+
+// reportRelease is a Release file written by a command.
+type reportRelease struct {
+	Key  string `json:"key"`
+	Diff string `json:"diff"`
+}
+
+// reportSnapshot is a snapshot listed by a command.
+type reportSnapshot struct {
+	Name string `json:"name"`
+	Date string `json:"date"`
+}
+
+type reportContextKey struct{}
+
+// withReport returns a context that commands add results to.
+func withReport(ctx context.Context, r *report) context.Context {
+	return context.WithValue(ctx, reportContextKey{}, r)
+}
+
+// reportFrom returns the report in the context or nil if there is none or it
+// is not enabled.
+func reportFrom(ctx context.Context) *report {
+	r, _ := ctx.Value(reportContextKey{}).(*report)
+	if r == nil || !r.enabled {
+		return nil
+	}
+	return r
+}
+
+func (r *report) addObject(key string, h indexHashes, signer string, existed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploaded = append(r.uploaded, reportObject{
+		Key:     key,
+		Size:    h.size,
+		MD5:     hex.EncodeToString(h.md5[:]),
+		SHA1:    hex.EncodeToString(h.sha1[:]),
+		SHA256:  hex.EncodeToString(h.sha256[:]),
+		Signer:  signer,
+		Existed: existed,
+	})
+}
+
+// addBuilt records the path of a package built by the command.
+func (r *report) addBuilt(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.built = append(r.built, path)
+}
+
+// addCopy records an object copied from key to the key to, which is empty if
+// it is the same.
+func (r *report) addCopy(key, to string, size int64, existed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.copied = append(r.copied, reportCopy{
+		Key:     key,
+		To:      to,
+		Size:    size,
+		Existed: existed,
+	})
+}
+
+// addDelete records an object deleted from the bucket.
+func (r *report) addDelete(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleted = append(r.deleted, key)
+}
+
+// addRelease records a change to the Release file of a distribution, along
+// with the changes to the index checksums it lists.
+func (r *report) addRelease(dist distribution, oldRelease, newRelease deb.Paragraph) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.releases = append(r.releases, reportRelease{
+		Key: dist.indexPath(),
+		Diff: unifiedDiff(
+			"a/"+dist.indexPath(),
+			"b/"+dist.indexPath(),
+			paragraphText(oldRelease),
+			paragraphText(newRelease),
+		),
+	})
+
+	// Malformed checksum fields have already been reported by the command that
+	// built the Release file, so they are treated as empty here.
+	oldSigs, _ := deb.ParseIndexSignatures(oldRelease.Get("SHA256"), sha256.Size)
+	newSigs, _ := deb.ParseIndexSignatures(newRelease.Get("SHA256"), sha256.Size)
+	old := make(map[string]string, len(oldSigs))
+	for _, sig := range oldSigs {
+		old[sig.Filename] = hex.EncodeToString(sig.Checksum)
+	}
+	for _, sig := range newSigs {
+		newSum := hex.EncodeToString(sig.Checksum)
+		if oldSum := old[sig.Filename]; oldSum != newSum {
+			r.indexes = append(r.indexes, reportIndex{
+				Key:       dist.dir() + "/" + sig.Filename,
+				OldSHA256: oldSum,
+				NewSHA256: newSum,
+			})
+		}
+		delete(old, sig.Filename)
+	}
+	for _, sig := range oldSigs {
+		if oldSum, removed := old[sig.Filename]; removed {
+			r.indexes = append(r.indexes, reportIndex{
+				Key:       dist.dir() + "/" + sig.Filename,
+				OldSHA256: oldSum,
+			})
+		}
+	}
+}
+
+func (r *report) addSnapshot(name, date string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshots = append(r.snapshots, reportSnapshot{Name: name, Date: date})
+}
+
+// reportError is the error member of a JSON report.
+type reportError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// write writes the report as a JSON object to w. If err is not nil, then the
+// report includes it along with whatever the command did before failing.
+func (r *report) write(w io.Writer, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj := struct {
+		Command   string           `json:"command"`
+		OK        bool             `json:"ok"`
+		DryRun    bool             `json:"dryRun,omitempty"`
+		Error     *reportError     `json:"error,omitempty"`
+		Built     []string         `json:"built,omitempty"`
+		Uploaded  []reportObject   `json:"uploaded,omitempty"`
+		Copied    []reportCopy     `json:"copied,omitempty"`
+		Deleted   []string         `json:"deleted,omitempty"`
+		Indexes   []reportIndex    `json:"indexes,omitempty"`
+		Releases  []reportRelease  `json:"releases,omitempty"`
+		Snapshots []reportSnapshot `json:"snapshots,omitempty"`
+	}{
+		Command:   r.command,
+		OK:        err == nil,
+		DryRun:    r.dryRun,
+		Built:     r.built,
+		Uploaded:  r.uploaded,
+		Copied:    r.copied,
+		Deleted:   r.deleted,
+		Indexes:   r.indexes,
+		Releases:  r.releases,
+		Snapshots: r.snapshots,
+	}
+	if err != nil {
+		obj.Error = &reportError{
+			Code:    errorCode(err),
+			Message: err.Error(),
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(obj)
+}
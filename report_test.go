@@ -0,0 +1,301 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestReport(t *testing.T) {
+	rep := &report{enabled: true, command: "upload"}
+	ctx := withReport(context.Background(), rep)
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{}); err != nil {
+		t.Fatal("upload:", err)
+	}
+	if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{}); err != nil {
+		t.Fatal("second upload:", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := rep.write(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Command  string
+		OK       bool
+		Uploaded []struct {
+			Key     string
+			SHA256  string
+			Existed bool
+		}
+		Indexes []struct {
+			Key       string
+			OldSHA256 string
+			NewSHA256 string
+		}
+		Releases []struct {
+			Key  string
+			Diff string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v\n%s", err, buf)
+	}
+	if got.Command != "upload" || !got.OK {
+		t.Errorf("command = %q, ok = %t; want %q, true", got.Command, got.OK, "upload")
+	}
+	var uploaded []string
+	for _, obj := range got.Uploaded {
+		uploaded = append(uploaded, obj.Key)
+		if obj.SHA256 == "" {
+			t.Errorf("%s reported without SHA256", obj.Key)
+		}
+	}
+	wantUploaded := []string{"pool/nullpkg_1.0-1_amd64.deb", "pool/nullpkg_1.0-1_amd64.deb"}
+	if diff := cmp.Diff(wantUploaded, uploaded); diff != "" {
+		t.Errorf("uploaded (-want +got):\n%s", diff)
+	}
+	if len(got.Uploaded) == 2 && (got.Uploaded[0].Existed || !got.Uploaded[1].Existed) {
+		t.Errorf("existed = %t, %t; want false, true", got.Uploaded[0].Existed, got.Uploaded[1].Existed)
+	}
+	var newIndexes []string
+	for _, idx := range got.Indexes {
+		if idx.OldSHA256 == "" {
+			newIndexes = append(newIndexes, idx.Key)
+		} else if idx.OldSHA256 == idx.NewSHA256 {
+			t.Errorf("%s reported as changed with same SHA256", idx.Key)
+		}
+	}
+	wantNewIndexes := []string{
+		"dists/stable/main/binary-amd64/Packages",
+		"dists/stable/main/binary-amd64/Packages.gz",
+	}
+	if diff := cmp.Diff(wantNewIndexes, newIndexes); diff != "" {
+		t.Errorf("new indexes (-want +got):\n%s", diff)
+	}
+	if len(got.Releases) != 2 {
+		t.Fatalf("got %d releases; want 2", len(got.Releases))
+	}
+	if !strings.HasPrefix(got.Releases[0].Diff, "--- a/dists/stable/Release\n+++ b/dists/stable/Release\n") {
+		t.Errorf("first Release diff = %q; want unified diff of dists/stable/Release", got.Releases[0].Diff)
+	}
+}
+
+// commandReport is the part of a JSON report that TestReportCommands checks.
+type commandReport struct {
+	Uploaded []struct {
+		Key string
+	}
+	Copied []struct {
+		Key     string
+		To      string
+		Size    int64
+		Existed bool
+	}
+	Deleted []string
+}
+
+// writeReport writes rep as JSON and decodes it back.
+func writeReport(t *testing.T, rep *report) *commandReport {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := rep.write(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := new(commandReport)
+	if err := json.Unmarshal(buf.Bytes(), got); err != nil {
+		t.Fatalf("%v\n%s", err, buf)
+	}
+	return got
+}
+
+func (r *commandReport) copiedTo() map[string]string {
+	m := make(map[string]string)
+	for _, c := range r.Copied {
+		m[c.Key] = c.To
+	}
+	return m
+}
+
+func TestReportCommands(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{}); err != nil {
+		t.Fatal("upload:", err)
+	}
+
+	t.Run("Snapshot", func(t *testing.T) {
+		rep := &report{enabled: true, command: "snapshot"}
+		if err := cmdSnapshot(withReport(ctx, rep), bucket, "stable", "good"); err != nil {
+			t.Fatal(err)
+		}
+		got := writeReport(t, rep).copiedTo()
+		for src, dst := range map[string]string{
+			"dists/stable/Release":                    "snapshots/stable/good/Release",
+			"dists/stable/main/binary-amd64/Packages": "snapshots/stable/good/main/binary-amd64/Packages",
+		} {
+			if got[src] != dst {
+				t.Errorf("%s copied to %q; want %q", src, got[src], dst)
+			}
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1.dsc")}, packageUploadOptions{}); err != nil {
+			t.Fatal("upload:", err)
+		}
+		rep := &report{enabled: true, command: "rollback"}
+		if err := cmdRollback(withReport(ctx, rep), bucket, "stable", "good", nil); err != nil {
+			t.Fatal(err)
+		}
+		got := writeReport(t, rep)
+		const packagesKey = "snapshots/stable/good/main/binary-amd64/Packages"
+		if to := got.copiedTo()[packagesKey]; to != "dists/stable/main/binary-amd64/Packages" {
+			t.Errorf("%s copied to %q; want dists/stable/main/binary-amd64/Packages", packagesKey, to)
+		}
+		deleted := make(map[string]bool)
+		for _, key := range got.Deleted {
+			deleted[key] = true
+		}
+		for _, key := range []string{"dists/stable/main/source/Sources", "dists/stable/main/source/Sources.gz"} {
+			if !deleted[key] {
+				t.Errorf("%s not reported as deleted; deleted = %q", key, got.Deleted)
+			}
+		}
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		dst := memblob.OpenBucket(nil)
+		for i, wantExisted := range []bool{false, true} {
+			rep := &report{enabled: true, command: "sync"}
+			if err := cmdSync(withReport(ctx, rep), dst, bucket, []distribution{"stable"}); err != nil {
+				t.Fatal(err)
+			}
+			got := writeReport(t, rep)
+			var found bool
+			for _, c := range got.Copied {
+				if c.To != "" {
+					t.Errorf("sync #%d: %s copied to %q; want same key", i+1, c.Key, c.To)
+				}
+				if c.Key == "pool/nullpkg_1.0-1_amd64.deb" {
+					found = true
+					if c.Existed != wantExisted || c.Size == 0 {
+						t.Errorf("sync #%d: %s existed = %t, size = %d; want %t, non-zero", i+1, c.Key, c.Existed, c.Size, wantExisted)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("sync #%d: pool/nullpkg_1.0-1_amd64.deb not reported as copied", i+1)
+			}
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		rep := &report{enabled: true, command: "export"}
+		dest := filepath.Join(t.TempDir(), "repo")
+		if err := cmdExport(withReport(ctx, rep), bucket, "stable", exportOptions{dest: dest}); err != nil {
+			t.Fatal(err)
+		}
+		got := writeReport(t, rep).copiedTo()
+		for _, key := range []string{"dists/stable/Release", "dists/stable/main/binary-amd64/Packages", "pool/nullpkg_1.0-1_amd64.deb"} {
+			if to, ok := got[key]; !ok {
+				t.Errorf("%s not reported as copied", key)
+			} else if to != "" {
+				t.Errorf("%s copied to %q; want same key", key, to)
+			}
+		}
+	})
+
+	t.Run("PublishKey", func(t *testing.T) {
+		keys := newTestKeyring(t, "Example")
+		if t.Failed() {
+			return
+		}
+		rep := &report{enabled: true, command: "publish-key"}
+		err := cmdPublishKey(withReport(ctx, rep), bucket, "stable", keys, publishKeyOptions{
+			baseURL: "https://apt.example.com",
+			name:    "example",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var uploaded []string
+		for _, obj := range writeReport(t, rep).Uploaded {
+			uploaded = append(uploaded, obj.Key)
+		}
+		want := []string{"example-archive-keyring.gpg", "example-archive-keyring.asc", "example.sources"}
+		if diff := cmp.Diff(want, uploaded); diff != "" {
+			t.Errorf("uploaded (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	if err := cmdUpload(ctx, bucket, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{}); err != nil {
+		t.Fatal("upload:", err)
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		err := cmdRefresh(ctx, bucket, "missing", nil, 0)
+		if got := errorCode(err); got != codeNotFound {
+			t.Errorf("errorCode(%v) = %q; want %q", err, got, codeNotFound)
+		}
+	})
+	t.Run("ParseError", func(t *testing.T) {
+		err := cmdInit(ctx, bucket, strings.NewReader("bogus\n"), ioutil.Discard, "stable", nil, 0)
+		if got := errorCode(err); got != codeParseError {
+			t.Errorf("errorCode(%v) = %q; want %q", err, got, codeParseError)
+		}
+	})
+	t.Run("ImmutableConflict", func(t *testing.T) {
+		conflict := memblob.OpenBucket(nil)
+		if err := conflict.WriteAll(ctx, poolPath("nullpkg_1.0-1_amd64.deb"), []byte("different"), nil); err != nil {
+			t.Fatal(err)
+		}
+		err := cmdUpload(ctx, conflict, comp, []string{filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")}, packageUploadOptions{})
+		if got := errorCode(err); got != codeImmutableConflict {
+			t.Errorf("errorCode(%v) = %q; want %q", err, got, codeImmutableConflict)
+		}
+	})
+	t.Run("SignatureRequired", func(t *testing.T) {
+		if err := bucket.WriteAll(ctx, distribution("stable").indexSignaturePath(), []byte("signature"), nil); err != nil {
+			t.Fatal(err)
+		}
+		err := cmdRefresh(ctx, bucket, "stable", nil, 0)
+		if got := errorCode(err); got != codeSignatureRequired {
+			t.Errorf("errorCode(%v) = %q; want %q", err, got, codeSignatureRequired)
+		}
+		if got, want := exitCode(err), 3; got != want {
+			t.Errorf("exitCode(%v) = %d; want %d", err, got, want)
+		}
+	})
+}
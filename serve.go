@@ -0,0 +1,220 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	slashpath "path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// serveOptions is the set of options to cmdServe.
+type serveOptions struct {
+	// policy controls access to the repository.
+	// If nil, the repository is public.
+	policy *accessPolicy
+	// signedURLExpiry is the lifetime of signed URLs that clients are
+	// redirected to for pool objects. If zero, or if the bucket does not
+	// support signed URLs, pool objects are served directly.
+	signedURLExpiry time.Duration
+}
+
+// cmdServe serves the objects in the bucket over HTTP on addr until ctx is
+// done.
+func cmdServe(ctx context.Context, bucket *blob.Bucket, addr string, logger *log.Logger, opts serveOptions) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("serving on http://%s/", l.Addr())
+	srv := &http.Server{
+		Handler: &bucketHandler{
+			bucket:          bucket,
+			logger:          logger,
+			policy:          opts.policy,
+			signedURLExpiry: opts.signedURLExpiry,
+		},
+		ErrorLog: logger,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// bucketHandler is an http.Handler that serves the objects in a bucket.
+type bucketHandler struct {
+	bucket          *blob.Bucket
+	logger          *log.Logger
+	policy          *accessPolicy
+	signedURLExpiry time.Duration
+	poolRefs        poolRefCache
+}
+
+func (h *bucketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") || slashpath.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		// Buckets don't have directories, so there's nothing to list.
+		http.NotFound(w, r)
+		return
+	}
+	if h.policy != nil && !h.authorize(w, r, key) {
+		return
+	}
+	ctx := r.Context()
+	attr, err := h.bucket.Attributes(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.logger.Printf("%s: %v", key, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if h.signedURLExpiry > 0 && strings.HasPrefix(key, poolPath("")) {
+		u, err := h.bucket.SignedURL(ctx, key, &blob.SignedURLOptions{Expiry: h.signedURLExpiry})
+		if err == nil {
+			http.Redirect(w, r, u, http.StatusTemporaryRedirect)
+			return
+		}
+		if gcerrors.Code(err) != gcerrors.Unimplemented {
+			h.logger.Printf("%s: %v", key, err)
+		}
+	}
+
+	contentType := attr.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(slashpath.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	switch {
+	case h.policy != nil && attr.CacheControl != "":
+		// Keep shared caches from serving private objects to other clients.
+		w.Header().Set("Cache-Control", "private, "+attr.CacheControl)
+	case h.policy != nil:
+		w.Header().Set("Cache-Control", "private")
+	case attr.CacheControl != "":
+		w.Header().Set("Cache-Control", attr.CacheControl)
+	}
+	if attr.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", attr.ContentEncoding)
+	}
+	w.Header().Set("ETag", objectETag(attr))
+	content := &objectReadSeeker{
+		ctx:    ctx,
+		bucket: h.bucket,
+		key:    key,
+		size:   attr.Size,
+	}
+	defer content.Close()
+	http.ServeContent(w, r, "", attr.ModTime, content)
+}
+
+// objectETag returns a strong entity tag for an object.
+func objectETag(attr *blob.Attributes) string {
+	if len(attr.MD5) > 0 {
+		return `"` + hex.EncodeToString(attr.MD5) + `"`
+	}
+	return `"` + strconv.FormatInt(attr.ModTime.UnixNano(), 36) + "-" + strconv.FormatInt(attr.Size, 36) + `"`
+}
+
+// objectReadSeeker is an io.ReadSeeker for an object in a bucket.
+// Reads start a new range read after each seek, so that serving a range of a
+// large object doesn't read the whole object.
+type objectReadSeeker struct {
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+	size   int64
+
+	offset int64
+	r      *blob.Reader
+}
+
+func (ors *objectReadSeeker) Read(p []byte) (int, error) {
+	if ors.offset >= ors.size {
+		return 0, io.EOF
+	}
+	if ors.r == nil {
+		var err error
+		ors.r, err = ors.bucket.NewRangeReader(ors.ctx, ors.key, ors.offset, -1, nil)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := ors.r.Read(p)
+	ors.offset += int64(n)
+	return n, err
+}
+
+func (ors *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += ors.offset
+	case io.SeekEnd:
+		offset += ors.size
+	default:
+		return ors.offset, fmt.Errorf("seek %s: invalid whence %d", ors.key, whence)
+	}
+	if offset < 0 {
+		return ors.offset, fmt.Errorf("seek %s: negative position", ors.key)
+	}
+	if offset != ors.offset {
+		ors.Close()
+		ors.offset = offset
+	}
+	return offset, nil
+}
+
+func (ors *objectReadSeeker) Close() error {
+	if ors.r == nil {
+		return nil
+	}
+	err := ors.r.Close()
+	ors.r = nil
+	return err
+}
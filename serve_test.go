@@ -0,0 +1,179 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob/memblob"
+)
+
+func TestServe(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	err := cmdUpload(ctx, bucket, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	srv := httptest.NewServer(&bucketHandler{
+		bucket: bucket,
+		logger: log.New(ioutil.Discard, "", 0),
+	})
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantHeader map[string]string
+		wantBody   string
+	}{
+		{
+			name:       "Release",
+			path:       "/dists/stable/Release",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{
+				"Content-Type": "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:       "Index",
+			path:       "/dists/stable/main/binary-amd64/Packages",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{
+				"Cache-Control": "max-age=300",
+			},
+		},
+		{
+			name:       "Package",
+			path:       "/pool/nullpkg_1.0-1_amd64.deb",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{
+				"Content-Type":  "application/vnd.debian.binary-package",
+				"Cache-Control": "immutable",
+			},
+		},
+		{
+			name:       "Range",
+			path:       "/pool/nullpkg_1.0-1_amd64.deb",
+			header:     http.Header{"Range": {"bytes=0-7"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "!<arch>\n",
+		},
+		{
+			name:       "Head",
+			method:     http.MethodHead,
+			path:       "/pool/nullpkg_1.0-1_amd64.deb",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Directory",
+			path:       "/dists/stable/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Root",
+			path:       "/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Missing",
+			path:       "/dists/unstable/Release",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Post",
+			method:     http.MethodPost,
+			path:       "/dists/stable/Release",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, srv.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("status = %d; want %d", resp.StatusCode, test.wantStatus)
+			}
+			for k, want := range test.wantHeader {
+				if got := resp.Header.Get(k); got != want {
+					t.Errorf("%s = %q; want %q", k, got, want)
+				}
+			}
+			if test.wantBody != "" && string(body) != test.wantBody {
+				t.Errorf("body = %q; want %q", body, test.wantBody)
+			}
+		})
+	}
+
+	t.Run("Conditional", func(t *testing.T) {
+		const path = "/pool/nullpkg_1.0-1_amd64.deb"
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			t.Fatal("no ETag")
+		}
+		if resp.Header.Get("Last-Modified") == "" {
+			t.Error("no Last-Modified")
+		}
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-None-Match", etag)
+		resp, err = srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("status = %d; want %d", resp.StatusCode, http.StatusNotModified)
+		}
+	})
+}
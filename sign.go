@@ -0,0 +1,282 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// checkSigningKeys verifies that the keys that will be used to sign a
+// distribution are compatible with its existing signatures. If the
+// distribution is signed, then at least one of its current signers must be
+// among the given keys, so that clients that trust the existing signatures
+// continue to trust the distribution. This permits rotating keys by first
+// signing with both the old and the new key, then with only the new key.
+func checkSigningKeys(ctx context.Context, bucket *blob.Bucket, dist distribution, keyIDs []string) error {
+	if len(keyIDs) == 0 {
+		if signed, err := isDistributionSigned(ctx, bucket, dist); err != nil {
+			return err
+		} else if signed {
+			return withCode(codeSignatureRequired, errors.New("distribution is signed but key ID not provided"))
+		}
+		return nil
+	}
+
+	signers, err := distributionSigners(ctx, bucket, dist)
+	if err != nil {
+		return err
+	}
+	if len(signers) == 0 {
+		return nil
+	}
+	provided, err := gpgKeyIDs(ctx, keyIDs)
+	if err != nil {
+		return err
+	}
+	for _, id := range signers {
+		if provided[id] {
+			return nil
+		}
+	}
+	return withCode(codeSignatureRequired, fmt.Errorf("distribution is signed by %s, but none of the provided keys match", formatKeyIDs(signers)))
+}
+
+// distributionSigners returns the sorted IDs of the keys that signed
+// the distribution's InRelease and Release.gpg files.
+func distributionSigners(ctx context.Context, bucket *blob.Bucket, dist distribution) ([]uint64, error) {
+	seen := make(map[uint64]bool)
+	var signers []uint64
+	add := func(ids []uint64) {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				signers = append(signers, id)
+			}
+		}
+	}
+
+	inRelease, err := bucket.ReadAll(ctx, dist.signedIndexPath())
+	if err == nil {
+		block, _ := clearsign.Decode(inRelease)
+		if block == nil {
+			return nil, fmt.Errorf("%s: not clear-signed", dist.signedIndexPath())
+		}
+		ids, err := signatureIssuers(block.ArmoredSignature.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dist.signedIndexPath(), err)
+		}
+		add(ids)
+	} else if gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("check distribution signature: %w", err)
+	}
+
+	detached, err := bucket.ReadAll(ctx, dist.indexSignaturePath())
+	if err == nil {
+		block, err := armor.Decode(bytes.NewReader(detached))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dist.indexSignaturePath(), err)
+		}
+		ids, err := signatureIssuers(block.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dist.indexSignaturePath(), err)
+		}
+		add(ids)
+	} else if gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("check distribution signature: %w", err)
+	}
+
+	sort.Slice(signers, func(i, j int) bool { return signers[i] < signers[j] })
+	return signers, nil
+}
+
+// signatureIssuers returns the IDs of the keys that issued the signatures
+// in an unarmored OpenPGP signature stream.
+//
+// The signature packets are inspected directly rather than with
+// packet.Reader, since the issuer is known even if the signature algorithm
+// (like EdDSA) is not supported by the openpgp package.
+func signatureIssuers(r io.Reader) ([]uint64, error) {
+	var ids []uint64
+	packets := packet.NewOpaqueReader(r)
+	for {
+		p, err := packets.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read signatures: %w", err)
+		}
+		if p.Tag != signaturePacketTag {
+			continue
+		}
+		id, err := signatureIssuer(p.Contents)
+		if err != nil {
+			return nil, fmt.Errorf("read signatures: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// signaturePacketTag is the OpenPGP packet tag of a signature packet.
+// See https://tools.ietf.org/html/rfc4880#section-4.3
+const signaturePacketTag = 2
+
+// Signature subpacket types that identify the issuer.
+// See https://tools.ietf.org/html/rfc4880#section-5.2.3.1
+const (
+	issuerSubpacket            = 16
+	issuerFingerprintSubpacket = 33
+)
+
+// signatureIssuer returns the issuer key ID of a signature packet body.
+func signatureIssuer(body []byte) (uint64, error) {
+	if len(body) == 0 {
+		return 0, errors.New("empty signature packet")
+	}
+	switch body[0] {
+	case 3:
+		// Version 3 signatures have the key ID at a fixed offset.
+		// See https://tools.ietf.org/html/rfc4880#section-5.2.2
+		if len(body) < 15 {
+			return 0, errors.New("short signature packet")
+		}
+		return binary.BigEndian.Uint64(body[7:15]), nil
+	case 4:
+		// Version 4 signatures have a list of hashed subpackets followed by a
+		// list of unhashed subpackets. The issuer may be in either.
+		rest := body[4:]
+		for i := 0; i < 2; i++ {
+			if len(rest) < 2 {
+				return 0, errors.New("short signature packet")
+			}
+			n := int(binary.BigEndian.Uint16(rest))
+			rest = rest[2:]
+			if len(rest) < n {
+				return 0, errors.New("short signature packet")
+			}
+			if id, ok := findIssuerSubpacket(rest[:n]); ok {
+				return id, nil
+			}
+			rest = rest[n:]
+		}
+		return 0, errors.New("signature has no issuer")
+	default:
+		return 0, fmt.Errorf("unsupported signature version %d", body[0])
+	}
+}
+
+// findIssuerSubpacket searches a list of signature subpackets for the issuer.
+func findIssuerSubpacket(subpackets []byte) (uint64, bool) {
+	for len(subpackets) > 0 {
+		// Decode subpacket length.
+		// See https://tools.ietf.org/html/rfc4880#section-5.2.3.1
+		var n int
+		switch {
+		case subpackets[0] < 192:
+			n = int(subpackets[0])
+			subpackets = subpackets[1:]
+		case subpackets[0] < 255:
+			if len(subpackets) < 2 {
+				return 0, false
+			}
+			n = (int(subpackets[0])-192)<<8 + int(subpackets[1]) + 192
+			subpackets = subpackets[2:]
+		default:
+			if len(subpackets) < 5 {
+				return 0, false
+			}
+			n = int(binary.BigEndian.Uint32(subpackets[1:]))
+			subpackets = subpackets[5:]
+		}
+		if n == 0 || len(subpackets) < n {
+			return 0, false
+		}
+		typ, data := subpackets[0]&0x7f, subpackets[1:n]
+		subpackets = subpackets[n:]
+		switch {
+		case typ == issuerSubpacket && len(data) == 8:
+			return binary.BigEndian.Uint64(data), true
+		case typ == issuerFingerprintSubpacket && len(data) == 21 && data[0] == 4:
+			// Version 4 key IDs are the low 64 bits of the fingerprint.
+			return binary.BigEndian.Uint64(data[13:]), true
+		}
+	}
+	return 0, false
+}
+
+// gpgKeyIDs asks gpg for the IDs of the given keys and their subkeys.
+func gpgKeyIDs(ctx context.Context, keyIDs []string) (map[uint64]bool, error) {
+	args := []string{"--batch", "--with-colons", "--fixed-list-mode", "--list-keys", "--"}
+	args = append(args, keyIDs...)
+	c := exec.CommandContext(ctx, "gpg", args...)
+	out := new(bytes.Buffer)
+	c.Stdout = out
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	return parseGPGKeyList(out)
+}
+
+// parseGPGKeyList parses the output of gpg --with-colons --list-keys,
+// returning the IDs of the public keys and subkeys.
+// The format is documented at https://github.com/gpg/gnupg/blob/master/doc/DETAILS
+func parseGPGKeyList(r io.Reader) (map[uint64]bool, error) {
+	ids := make(map[uint64]bool)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 5 || fields[0] != "pub" && fields[0] != "sub" {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[4], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("list keys: parse key ID %q: %w", fields[4], err)
+		}
+		ids[id] = true
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	return ids, nil
+}
+
+func formatKeyIDs(ids []uint64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%016X", id)
+	}
+	return strings.Join(parts, ", ")
+}
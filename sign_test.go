@@ -0,0 +1,163 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestSignatureIssuers(t *testing.T) {
+	var want []uint64
+	sigs := new(bytes.Buffer)
+	for _, name := range []string{"Alice", "Bob"} {
+		e, err := openpgp.NewEntity(name, "", strings.ToLower(name)+"@example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := openpgp.DetachSign(sigs, e, strings.NewReader("Hello, World!\n"), nil); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, e.PrimaryKey.KeyId)
+	}
+	got, err := signatureIssuers(sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("signatureIssuers(...) (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseGPGKeyList(t *testing.T) {
+	const output = "tru::1:1600000000:0:3:1:5\n" +
+		"pub:u:255:22:1D4BE3BE8D94FBC1:1600000000:::u:::scSC:::::ed25519:::0:\n" +
+		"fpr:::::::::A0E6D31D9C8C9CC6F0C3F0A01D4BE3BE8D94FBC1:\n" +
+		"uid:u::::1600000000::0123456789ABCDEF0123456789ABCDEF01234567::Alice <alice@example.com>::::::::::0:\n" +
+		"sub:u:255:22:5A1B3D37A0E2E81F:1600000000::::::s:::::ed25519::\n" +
+		"fpr:::::::::3E2D1C7E9E3B7C1F0D9A82AA5A1B3D37A0E2E81F:\n"
+	got, err := parseGPGKeyList(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64]bool{
+		0x1D4BE3BE8D94FBC1: true,
+		0x5A1B3D37A0E2E81F: true,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseGPGKeyList(...) (-want +got):\n%s", diff)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	keys := newTestKeyring(t, "Old", "New", "Other")
+	if t.Failed() {
+		return
+	}
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	debPath := filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb")
+
+	if err := cmdUpload(ctx, bucket, comp, []string{debPath}, packageUploadOptions{keyIDs: []string{keys[0]}}); err != nil {
+		t.Fatal("upload with old key:", err)
+	}
+	if err := cmdUpload(ctx, bucket, comp, []string{debPath}, packageUploadOptions{}); err == nil {
+		t.Error("upload without key succeeded on signed distribution")
+	}
+	if err := cmdUpload(ctx, bucket, comp, []string{debPath}, packageUploadOptions{keyIDs: []string{keys[0], keys[1]}}); err != nil {
+		t.Fatal("upload with both keys:", err)
+	}
+	signers, err := distributionSigners(ctx, bucket, comp.dist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Errorf("after signing with both keys, signers = %s; want 2 signers", formatKeyIDs(signers))
+	}
+	if err := cmdUpload(ctx, bucket, comp, []string{debPath}, packageUploadOptions{keyIDs: []string{keys[2]}}); err == nil {
+		t.Error("upload with unrelated key succeeded")
+	}
+	if err := cmdUpload(ctx, bucket, comp, []string{debPath}, packageUploadOptions{keyIDs: []string{keys[1]}}); err != nil {
+		t.Fatal("upload with new key:", err)
+	}
+	signers, err = distributionSigners(ctx, bucket, comp.dist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 {
+		t.Errorf("after signing with new key, signers = %s; want 1 signer", formatKeyIDs(signers))
+	}
+}
+
+// newTestKeyring creates a temporary GnuPG home directory with a signing key
+// for each of the given names and returns their fingerprints.
+// The test is skipped if gpg is not installed.
+func newTestKeyring(t *testing.T, names ...string) []string {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not found:", err)
+	}
+	dir, err := ioutil.TempDir("", "aptblob_gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldHome, hadHome := os.LookupEnv("GNUPGHOME")
+	os.Setenv("GNUPGHOME", dir)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		if hadHome {
+			os.Setenv("GNUPGHOME", oldHome)
+		} else {
+			os.Unsetenv("GNUPGHOME")
+		}
+		os.RemoveAll(dir)
+	})
+
+	var fingerprints []string
+	for _, name := range names {
+		uid := name + " <" + strings.ToLower(name) + "@example.com>"
+		gen := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-generate-key", uid, "ed25519", "sign", "never")
+		if out, err := gen.CombinedOutput(); err != nil {
+			t.Fatalf("generate key for %s: %v\n%s", name, err, out)
+		}
+		list := exec.Command("gpg", "--batch", "--with-colons", "--list-keys", uid)
+		out, err := list.Output()
+		if err != nil {
+			t.Fatalf("list key for %s: %v", name, err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+				fingerprints = append(fingerprints, fields[9])
+				break
+			}
+		}
+	}
+	if len(fingerprints) != len(names) {
+		t.Fatalf("found %d fingerprints; want %d", len(fingerprints), len(names))
+	}
+	return fingerprints
+}
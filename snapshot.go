@@ -0,0 +1,324 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	slashpath "path"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// snapshotDir returns the directory that holds the named snapshot of the
+// distribution. Snapshots only hold the distribution's indexes, since pool
+// objects are immutable and shared.
+func (dist distribution) snapshotDir(name string) string {
+	return dist.snapshotsDir() + "/" + name
+}
+
+// snapshotsDir returns the directory that holds all snapshots of the
+// distribution.
+func (dist distribution) snapshotsDir() string {
+	return "snapshots/" + string(dist)
+}
+
+// cmdSnapshot copies the current indexes and Release file of a distribution
+// into an immutable snapshot.
+func cmdSnapshot(ctx context.Context, bucket *blob.Bucket, dist distribution, name string) error {
+	if err := validateSnapshotName(name); err != nil {
+		return err
+	}
+	dir := dist.snapshotDir(name)
+	if exists, err := bucket.Exists(ctx, dir+"/Release"); err != nil {
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	} else if exists {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+	if exists, err := bucket.Exists(ctx, dist.indexPath()); err != nil {
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	} else if !exists {
+		return withCode(codeNotFound, fmt.Errorf("snapshot %s: %s not found", name, dist.indexPath()))
+	}
+
+	if _, err := copyIndexes(ctx, bucket, dir, dist.dir(), immutable); err != nil {
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	// The Release file is copied last: its presence marks a complete snapshot.
+	if err := copyObject(ctx, bucket, dir+"/Release", dist.indexPath(), immutable); err != nil {
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+// cmdListSnapshots writes the names and dates of a distribution's snapshots
+// to out.
+func cmdListSnapshots(ctx context.Context, bucket *blob.Bucket, dist distribution, out io.Writer) error {
+	iter := bucket.List(&blob.ListOptions{
+		Prefix:    dist.snapshotsDir() + "/",
+		Delimiter: "/",
+	})
+	for {
+		obj, err := iter.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("list snapshots: %w", err)
+		}
+		if !obj.IsDir {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(obj.Key, dist.snapshotsDir()+"/"), "/")
+		release, err := downloadReleaseIndexAt(ctx, bucket, dist.snapshotDir(name)+"/Release")
+		if err != nil {
+			return fmt.Errorf("list snapshots: %w", err)
+		}
+		if release == nil {
+			// Incomplete snapshot.
+			continue
+		}
+		fmt.Fprintf(out, "%s\t%s\n", name, release.Get("Date"))
+		reportFrom(ctx).addSnapshot(name, release.Get("Date"))
+	}
+}
+
+// cmdRollback restores a distribution's indexes and Release file from a
+// snapshot and signs the Release file again.
+func cmdRollback(ctx context.Context, bucket *blob.Bucket, dist distribution, name string, keyIDs []string) error {
+	if err := validateSnapshotName(name); err != nil {
+		return err
+	}
+	if err := checkSigningKeys(ctx, bucket, dist, keyIDs); err != nil {
+		return err
+	}
+	dir := dist.snapshotDir(name)
+	release, err := downloadReleaseIndexAt(ctx, bucket, dir+"/Release")
+	if err != nil {
+		return fmt.Errorf("rollback to %s: %w", name, err)
+	}
+	if release == nil {
+		return withCode(codeNotFound, fmt.Errorf("rollback to %s: snapshot not found", name))
+	}
+
+	restored, err := copyIndexes(ctx, bucket, dist.dir(), dir, defaultCacheControl)
+	if err != nil {
+		return fmt.Errorf("rollback to %s: %w", name, err)
+	}
+
+	now := time.Now()
+	setReleaseDates(&release, now, now, releaseValidity(release))
+	if err := uploadReleaseIndex(ctx, bucket, dist, release, keyIDs); err != nil {
+		return fmt.Errorf("rollback to %s: %w", name, err)
+	}
+
+	// Remove indexes created after the snapshot.
+	currentKeys, err := listDistKeys(ctx, bucket, dist.dir())
+	if err != nil {
+		return fmt.Errorf("rollback to %s: %w", name, err)
+	}
+	for _, key := range currentKeys {
+		rel := strings.TrimPrefix(key, dist.dir()+"/")
+		if isReleaseFile(rel) || restored[rel] {
+			continue
+		}
+		if err := deleteObject(ctx, bucket, key); err != nil {
+			return fmt.Errorf("rollback to %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// freezeOptions is the set of options to cmdFreeze.
+type freezeOptions struct {
+	// snapshot is the name of the source distribution's snapshot to freeze.
+	// If empty, the source distribution's current state is used.
+	snapshot string
+	// suite and codename are the Suite and Codename fields of the new
+	// distribution. They default to the new distribution's name.
+	suite    string
+	codename string
+	// notAutomatic and butAutomaticUpgrades set the fields of the same name,
+	// which control APT's pinning of the new distribution.
+	notAutomatic         bool
+	butAutomaticUpgrades bool
+}
+
+// cmdFreeze creates a new distribution from the indexes of another
+// distribution (or one of its snapshots). The new distribution shares pool
+// objects with the source distribution, but later uploads to the source
+// distribution do not change it.
+func cmdFreeze(ctx context.Context, bucket *blob.Bucket, src, dst distribution, keyIDs []string, opts freezeOptions) error {
+	if src == dst {
+		return errors.New("freeze: source and destination distributions are the same")
+	}
+	if err := checkSigningKeys(ctx, bucket, src, keyIDs); err != nil {
+		return err
+	}
+	if exists, err := bucket.Exists(ctx, dst.indexPath()); err != nil {
+		return fmt.Errorf("freeze %s: %w", dst, err)
+	} else if exists {
+		return fmt.Errorf("freeze %s: distribution already exists", dst)
+	}
+	srcDir := src.dir()
+	if opts.snapshot != "" {
+		if err := validateSnapshotName(opts.snapshot); err != nil {
+			return err
+		}
+		srcDir = src.snapshotDir(opts.snapshot)
+	}
+	release, err := downloadReleaseIndexAt(ctx, bucket, srcDir+"/Release")
+	if err != nil {
+		return fmt.Errorf("freeze %s: %w", dst, err)
+	}
+	if release == nil {
+		return withCode(codeNotFound, fmt.Errorf("freeze %s: %s/Release not found", dst, srcDir))
+	}
+
+	if _, err := copyIndexes(ctx, bucket, dst.dir(), srcDir, defaultCacheControl); err != nil {
+		return fmt.Errorf("freeze %s: %w", dst, err)
+	}
+	if opts.suite == "" {
+		opts.suite = string(dst)
+	}
+	if opts.codename == "" {
+		opts.codename = string(dst)
+	}
+	release.Set("Suite", opts.suite)
+	release.Set("Codename", opts.codename)
+	if opts.notAutomatic {
+		release.Set("NotAutomatic", "yes")
+	}
+	if opts.butAutomaticUpgrades {
+		release.Set("ButAutomaticUpgrades", "yes")
+	}
+	now := time.Now()
+	setReleaseDates(&release, now, now, releaseValidity(release))
+	if err := uploadReleaseIndex(ctx, bucket, dst, release, keyIDs); err != nil {
+		return fmt.Errorf("freeze %s: %w", dst, err)
+	}
+	return nil
+}
+
+// copyIndexes copies every object in srcDir except the Release file and its
+// signatures to dstDir, giving the copies the given Cache-Control. It returns
+// the set of copied paths relative to the directories.
+func copyIndexes(ctx context.Context, bucket *blob.Bucket, dstDir, srcDir string, cacheControl string) (map[string]bool, error) {
+	keys, err := listDistKeys(ctx, bucket, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	copied := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, srcDir+"/")
+		if isReleaseFile(rel) {
+			continue
+		}
+		if err := copyObject(ctx, bucket, dstDir+"/"+rel, key, cacheControl); err != nil {
+			return nil, err
+		}
+		copied[rel] = true
+	}
+	return copied, nil
+}
+
+// isReleaseFile reports whether the path relative to a distribution's
+// directory is the Release file or one of its signatures.
+func isReleaseFile(rel string) bool {
+	return rel == "Release" || rel == "InRelease" || rel == "Release.gpg"
+}
+
+// distReleaseDir returns the directory of a slash-separated path if the path
+// names the Release file of a distribution or one of its signatures, rather
+// than a component's per-architecture Release file.
+func distReleaseDir(p string) (dir string, ok bool) {
+	i := strings.LastIndex(p, "/")
+	if i < 0 || !isReleaseFile(p[i+1:]) {
+		return "", false
+	}
+	dir = p[:i]
+	if parent := slashpath.Base(dir); parent == "source" || strings.HasPrefix(parent, "binary-") {
+		return "", false
+	}
+	return dir, true
+}
+
+func validateSnapshotName(name string) error {
+	if name == "" {
+		return errors.New("snapshot name is empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/ \t\n") {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+// listDistKeys returns the keys of all objects in a distribution's directory,
+// leaving out those of distributions nested inside it (like stable/updates in
+// stable).
+func listDistKeys(ctx context.Context, bucket *blob.Bucket, dir string) ([]string, error) {
+	keys, err := listKeys(ctx, bucket, dir+"/")
+	if err != nil {
+		return nil, err
+	}
+	var nested []string
+	for _, key := range keys {
+		if sub, ok := distReleaseDir(strings.TrimPrefix(key, dir+"/")); ok {
+			nested = append(nested, dir+"/"+sub+"/")
+		}
+	}
+	if len(nested) == 0 {
+		return keys, nil
+	}
+	filtered := keys[:0]
+	for _, key := range keys {
+		if !hasAnyPrefix(key, nested) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered, nil
+}
+
+// hasAnyPrefix reports whether s begins with any of the prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listKeys returns the keys of all objects that start with the given prefix.
+func listKeys(ctx context.Context, bucket *blob.Bucket, prefix string) ([]string, error) {
+	var keys []string
+	iter := bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		keys = append(keys, obj.Key)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob/memblob"
+)
+
+func TestSnapshotRollback(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	err := cmdUpload(ctx, bucket, comp, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	if err := cmdSnapshot(ctx, bucket, "stable", "good"); err != nil {
+		t.Fatal("snapshot:", err)
+	}
+	if err := cmdSnapshot(ctx, bucket, "stable", "good"); err == nil {
+		t.Error("second snapshot with same name succeeded")
+	}
+	goodRelease, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make a bad upload.
+	err = cmdUpload(ctx, bucket, comp, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	const sourcesKey = "dists/stable/main/source/Sources"
+	if exists, err := bucket.Exists(ctx, sourcesKey); err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Fatalf("%s does not exist after upload", sourcesKey)
+	}
+
+	out := new(bytes.Buffer)
+	if err := cmdListSnapshots(ctx, bucket, "stable", out); err != nil {
+		t.Error("snapshot list:", err)
+	} else if !strings.HasPrefix(out.String(), "good\t") || strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("snapshot list output = %q; want a single line for \"good\"", out)
+	}
+
+	if err := cmdRollback(ctx, bucket, "stable", "good", nil); err != nil {
+		t.Fatal("rollback:", err)
+	}
+	release, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"MD5Sum", "SHA1", "SHA256"} {
+		if got, want := release.Get(k), goodRelease.Get(k); got != want {
+			t.Errorf("after rollback, %s = %q; want %q", k, got, want)
+		}
+	}
+	for _, key := range []string{sourcesKey, sourcesKey + gzipExtension} {
+		if exists, err := bucket.Exists(ctx, key); err != nil {
+			t.Error(err)
+		} else if exists {
+			t.Errorf("%s exists after rollback", key)
+		}
+	}
+	const packagesKey = "dists/stable/main/binary-amd64/Packages"
+	if exists, err := bucket.Exists(ctx, packagesKey); err != nil {
+		t.Error(err)
+	} else if !exists {
+		t.Errorf("%s does not exist after rollback", packagesKey)
+	}
+
+	if err := cmdRollback(ctx, bucket, "stable", "missing", nil); err == nil {
+		t.Error("rollback to missing snapshot succeeded")
+	}
+}
+
+func TestSnapshotNestedDistribution(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	for _, dist := range []distribution{"stable", "stable/updates"} {
+		comp := component{dist: dist, name: "main"}
+		err := cmdUpload(ctx, bucket, comp, []string{
+			filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+		}, packageUploadOptions{})
+		if err != nil {
+			t.Fatalf("upload to %s: %v", dist, err)
+		}
+	}
+	if err := cmdSnapshot(ctx, bucket, "stable", "good"); err != nil {
+		t.Fatal("snapshot:", err)
+	}
+	for _, key := range []string{"snapshots/stable/good/Release", "snapshots/stable/good/main/binary-amd64/Packages"} {
+		attr, err := bucket.Attributes(ctx, key)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if attr.CacheControl != immutable {
+			t.Errorf("%s Cache-Control = %q; want %q", key, attr.CacheControl, immutable)
+		}
+	}
+	const nestedSnapshotKey = "snapshots/stable/good/updates/Release"
+	if exists, err := bucket.Exists(ctx, nestedSnapshotKey); err != nil {
+		t.Error(err)
+	} else if exists {
+		t.Errorf("%s exists; snapshot included nested distribution", nestedSnapshotKey)
+	}
+
+	// Change the nested distribution, then roll back its parent.
+	err := cmdUpload(ctx, bucket, component{dist: "stable/updates", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	if err := cmdRollback(ctx, bucket, "stable", "good", nil); err != nil {
+		t.Fatal("rollback:", err)
+	}
+	for _, key := range []string{"dists/stable/updates/Release", "dists/stable/updates/main/source/Sources"} {
+		if exists, err := bucket.Exists(ctx, key); err != nil {
+			t.Error(err)
+		} else if !exists {
+			t.Errorf("%s deleted by rollback of parent distribution", key)
+		}
+	}
+	const packagesKey = "dists/stable/main/binary-amd64/Packages"
+	if attr, err := bucket.Attributes(ctx, packagesKey); err != nil {
+		t.Error(err)
+	} else if attr.CacheControl != defaultCacheControl {
+		t.Errorf("%s Cache-Control = %q after rollback; want %q", packagesKey, attr.CacheControl, defaultCacheControl)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	comp := component{dist: "stable", name: "main"}
+	err := cmdUpload(ctx, bucket, comp, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+	srcRelease, err := downloadReleaseIndex(ctx, bucket, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmdFreeze(ctx, bucket, "stable", "stable-2020-10-01", nil, freezeOptions{
+		notAutomatic:         true,
+		butAutomaticUpgrades: true,
+	})
+	if err != nil {
+		t.Fatal("freeze:", err)
+	}
+	if err := cmdFreeze(ctx, bucket, "stable", "stable-2020-10-01", nil, freezeOptions{}); err == nil {
+		t.Error("second freeze to same distribution succeeded")
+	}
+
+	// Upload to the source distribution should not affect the frozen one.
+	err = cmdUpload(ctx, bucket, comp, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+
+	release, err := downloadReleaseIndex(ctx, bucket, "stable-2020-10-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFields := map[string]string{
+		"Suite":                "stable-2020-10-01",
+		"Codename":             "stable-2020-10-01",
+		"NotAutomatic":         "yes",
+		"ButAutomaticUpgrades": "yes",
+		"SHA256":               srcRelease.Get("SHA256"),
+	}
+	for k, want := range wantFields {
+		if got := release.Get(k); got != want {
+			t.Errorf("%s = %q; want %q", k, got, want)
+		}
+	}
+	const frozenPackagesKey = "dists/stable-2020-10-01/main/binary-amd64/Packages"
+	if exists, err := bucket.Exists(ctx, frozenPackagesKey); err != nil {
+		t.Error(err)
+	} else if !exists {
+		t.Errorf("%s does not exist", frozenPackagesKey)
+	}
+	const frozenSourcesKey = "dists/stable-2020-10-01/main/source/Sources"
+	if exists, err := bucket.Exists(ctx, frozenSourcesKey); err != nil {
+		t.Error(err)
+	} else if exists {
+		t.Errorf("%s exists after upload to source distribution", frozenSourcesKey)
+	}
+}
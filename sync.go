@@ -0,0 +1,156 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// cmdSync copies the pool and the given distributions from one bucket to
+// another. If dists is empty, every distribution in src is copied.
+//
+// Objects are copied in an order that keeps dst consistent for clients at
+// every step: pool objects first, then each distribution's indexes, then its
+// Release file and signatures.
+func cmdSync(ctx context.Context, dst, src *blob.Bucket, dists []distribution) error {
+	if len(dists) == 0 {
+		var err error
+		dists, err = listDistributions(ctx, src)
+		if err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	for _, dist := range dists {
+		if exists, err := src.Exists(ctx, dist.indexPath()); err != nil {
+			return fmt.Errorf("sync %s: %w", dist, err)
+		} else if !exists {
+			return withCode(codeNotFound, fmt.Errorf("sync %s: %s not found", dist, dist.indexPath()))
+		}
+	}
+
+	poolKeys, err := listKeys(ctx, src, poolPath(""))
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	for _, key := range poolKeys {
+		if err := syncObject(ctx, dst, src, key); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	for _, dist := range dists {
+		keys, err := listDistKeys(ctx, src, dist.dir())
+		if err != nil {
+			return fmt.Errorf("sync %s: %w", dist, err)
+		}
+		for _, key := range keys {
+			if isReleaseFile(strings.TrimPrefix(key, dist.dir()+"/")) {
+				continue
+			}
+			if err := syncObject(ctx, dst, src, key); err != nil {
+				return fmt.Errorf("sync %s: %w", dist, err)
+			}
+		}
+		// The Release file and its signatures come last. Signatures that src
+		// doesn't have are removed from dst, so that clients don't check the new
+		// Release file against them.
+		for _, key := range []string{dist.indexPath(), dist.indexSignaturePath(), dist.signedIndexPath()} {
+			err := syncObject(ctx, dst, src, key)
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				if err := deleteObject(ctx, dst, key); err != nil {
+					return fmt.Errorf("sync %s: %w", dist, err)
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("sync %s: %w", dist, err)
+			}
+		}
+	}
+	return nil
+}
+
+// listDistributions returns the names of the distributions in the bucket,
+// including nested ones like stable/updates. A distribution is a directory
+// under dists/ that has a Release file.
+func listDistributions(ctx context.Context, bucket *blob.Bucket) ([]distribution, error) {
+	keys, err := listKeys(ctx, bucket, "dists/")
+	if err != nil {
+		return nil, fmt.Errorf("list distributions: %w", err)
+	}
+	var dists []distribution
+	for _, key := range keys {
+		if dir, ok := distReleaseDir(key); ok && strings.HasSuffix(key, "/Release") {
+			dists = append(dists, distribution(strings.TrimPrefix(dir, "dists/")))
+		}
+	}
+	return dists, nil
+}
+
+// syncObject copies the object at key from src to dst, preserving its
+// Content-Type and Cache-Control. Immutable objects that already exist in dst
+// are not copied again.
+func syncObject(ctx context.Context, dst, src *blob.Bucket, key string) error {
+	attr, err := src.Attributes(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if attr.CacheControl == immutable {
+		if exists, err := immutableObjectExists(ctx, dst, key, attr.Size, attr.MD5); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		} else if exists {
+			reportFrom(ctx).addCopy(key, "", attr.Size, true)
+			return nil
+		}
+	}
+	if plan := planFrom(ctx); plan != nil {
+		plan.copy(key, "", attr.Size)
+		reportFrom(ctx).addCopy(key, "", attr.Size, false)
+		return nil
+	}
+	r, err := src.NewReader(ctx, key, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	defer r.Close()
+	w, err := dst.NewWriter(ctx, key, &blob.WriterOptions{
+		ContentType:        attr.ContentType,
+		CacheControl:       attr.CacheControl,
+		ContentDisposition: attr.ContentDisposition,
+		ContentEncoding:    attr.ContentEncoding,
+		ContentLanguage:    attr.ContentLanguage,
+		ContentMD5:         attr.MD5,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	_, copyErr := io.Copy(w, r)
+	closeErr := w.Close()
+	if copyErr != nil {
+		return fmt.Errorf("%s: %w", key, copyErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("%s: %w", key, closeErr)
+	}
+	reportFrom(ctx).addCopy(key, "", attr.Size, false)
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	err := cmdUpload(ctx, src, component{dist: "stable", name: "main"}, []string{
+		filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+		filepath.Join("testdata", "nullpkg_1.0-1.dsc"),
+	}, packageUploadOptions{})
+	if err != nil {
+		t.Fatal("upload:", err)
+	}
+
+	dst := memblob.OpenBucket(nil)
+	if err := cmdSync(ctx, dst, src, nil); err != nil {
+		t.Fatal("sync:", err)
+	}
+	for _, key := range []string{
+		"dists/stable/Release",
+		"dists/stable/main/binary-amd64/Packages.gz",
+		"dists/stable/main/source/Sources",
+		poolPath("nullpkg_1.0-1_amd64.deb"),
+		poolPath("nullpkg_1.0-1/nullpkg_1.0.orig.tar.gz"),
+	} {
+		want, err := src.ReadAll(ctx, key)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		got, err := dst.ReadAll(ctx, key)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs after sync", key)
+		}
+	}
+	attr, err := dst.Attributes(ctx, poolPath("nullpkg_1.0-1_amd64.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.CacheControl != immutable || attr.ContentType != "application/vnd.debian.binary-package" {
+		t.Errorf("synced package has Cache-Control: %q, Content-Type: %q; want %q, %q",
+			attr.CacheControl, attr.ContentType, immutable, "application/vnd.debian.binary-package")
+	}
+	if err := cmdSync(ctx, dst, src, []distribution{"stable"}); err != nil {
+		t.Error("second sync:", err)
+	}
+	if err := cmdSync(ctx, dst, src, []distribution{"missing"}); err == nil {
+		t.Error("sync of missing distribution succeeded")
+	}
+
+	conflict := memblob.OpenBucket(nil)
+	if err := conflict.WriteAll(ctx, poolPath("nullpkg_1.0-1_amd64.deb"), []byte("different"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdSync(ctx, conflict, src, nil); err == nil {
+		t.Error("sync over a different immutable object succeeded")
+	}
+	if exists, err := conflict.Exists(ctx, "dists/stable/Release"); err != nil {
+		t.Error(err)
+	} else if exists {
+		t.Error("Release copied after failed pool sync")
+	}
+}
+
+func TestSyncNestedDistribution(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	for _, dist := range []distribution{"stable", "stable/updates"} {
+		err := cmdUpload(ctx, src, component{dist: dist, name: "main"}, []string{
+			filepath.Join("testdata", "nullpkg_1.0-1_amd64.deb"),
+		}, packageUploadOptions{})
+		if err != nil {
+			t.Fatalf("upload to %s: %v", dist, err)
+		}
+	}
+
+	dists, err := listDistributions(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]distribution{"stable", "stable/updates"}, dists); diff != "" {
+		t.Errorf("listDistributions(...) (-want +got):\n%s", diff)
+	}
+
+	// Each distribution's Release file must come after all of its indexes.
+	out := new(bytes.Buffer)
+	if err := cmdSync(withDryRun(ctx, out), memblob.OpenBucket(nil), src, nil); err != nil {
+		t.Fatal("sync:", err)
+	}
+	for _, dist := range dists {
+		releaseLine := strings.Index(out.String(), "would copy "+dist.indexPath()+" ")
+		if releaseLine == -1 {
+			t.Errorf("sync plan does not copy %s. Plan:\n%s", dist.indexPath(), out)
+			continue
+		}
+		indexLine := strings.LastIndex(out.String(), "would copy "+dist.dir()+"/main/")
+		if indexLine > releaseLine {
+			t.Errorf("sync plan copies %s before its indexes. Plan:\n%s", dist.indexPath(), out)
+		}
+	}
+
+	dst := memblob.OpenBucket(nil)
+	if err := cmdSync(ctx, dst, src, []distribution{"stable"}); err != nil {
+		t.Fatal("sync:", err)
+	}
+	if exists, err := dst.Exists(ctx, "dists/stable/updates/Release"); err != nil {
+		t.Error(err)
+	} else if exists {
+		t.Error("sync of stable copied stable/updates")
+	}
+}
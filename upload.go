@@ -24,6 +24,7 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -32,9 +33,11 @@ import (
 	"os/exec"
 	slashpath "path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/ulikunitz/xz"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
 	"golang.org/x/crypto/openpgp/clearsign"
@@ -76,9 +79,26 @@ func (comp component) sourceIndexPath() string {
 	return comp.dir() + "/source/Sources"
 }
 
-func uploadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distribution, release deb.Paragraph, keyID string) error {
+func uploadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distribution, release deb.Paragraph, keyIDs []string) error {
+	release = append(deb.Paragraph(nil), release...)
+	deb.SortFields(release, deb.ReleaseFieldOrder)
+	if r := reportFrom(ctx); r != nil {
+		oldRelease, err := downloadReleaseIndex(ctx, bucket, dist)
+		if err != nil {
+			return fmt.Errorf("upload Release: %w", err)
+		}
+		r.addRelease(dist, oldRelease, release)
+	}
 	data := new(bytes.Buffer)
 	deb.Save(data, []deb.Paragraph{release})
+	if plan := planFrom(ctx); plan != nil {
+		oldRelease, err := downloadReleaseIndex(ctx, bucket, dist)
+		if err != nil {
+			return fmt.Errorf("upload Release: %w", err)
+		}
+		plan.diff(dist.indexPath(), paragraphText(oldRelease), data.String())
+		return nil
+	}
 	err := bucket.WriteAll(ctx, dist.indexPath(), data.Bytes(), &blob.WriterOptions{
 		ContentType: "text/plain; charset=utf-8",
 	})
@@ -86,16 +106,11 @@ func uploadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distribut
 		return fmt.Errorf("upload Release: %w", err)
 	}
 
-	if keyID == "" {
+	if len(keyIDs) == 0 {
 		return nil
 	}
 
-	clearSign := exec.CommandContext(ctx, "gpg",
-		"--batch",
-		"--armor",
-		"--local-user", keyID+"!",
-		"--clear-sign",
-	)
+	clearSign := exec.CommandContext(ctx, "gpg", gpgSignArgs(keyIDs, "--clear-sign")...)
 	clearSign.Stdin = bytes.NewReader(data.Bytes())
 	clearSignOutput := new(bytes.Buffer)
 	clearSign.Stdout = clearSignOutput
@@ -110,12 +125,7 @@ func uploadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distribut
 		return fmt.Errorf("upload InRelease: %w", err)
 	}
 
-	detachSign := exec.CommandContext(ctx, "gpg",
-		"--batch",
-		"--armor",
-		"--local-user", keyID+"!",
-		"--detach-sign",
-	)
+	detachSign := exec.CommandContext(ctx, "gpg", gpgSignArgs(keyIDs, "--detach-sign")...)
 	detachSign.Stdin = bytes.NewReader(data.Bytes())
 	detachSignOutput := new(bytes.Buffer)
 	detachSign.Stdout = detachSignOutput
@@ -132,6 +142,16 @@ func uploadReleaseIndex(ctx context.Context, bucket *blob.Bucket, dist distribut
 	return nil
 }
 
+// gpgSignArgs returns the arguments to gpg to sign with the given keys.
+// gpg makes one signature for each --local-user.
+func gpgSignArgs(keyIDs []string, command string) []string {
+	args := []string{"--batch", "--armor"}
+	for _, keyID := range keyIDs {
+		args = append(args, "--local-user", keyID+"!")
+	}
+	return append(args, command)
+}
+
 type indexHashes struct {
 	size   int64
 	md5    [md5.Size]byte
@@ -139,34 +159,157 @@ type indexHashes struct {
 	sha256 [sha256.Size]byte
 }
 
-const gzipExtension = ".gz"
+// Index compression extensions.
+const (
+	gzipExtension = ".gz"
+	xzExtension   = ".xz"
+)
 
-func uploadIndex(ctx context.Context, bucket *blob.Bucket, key string, packages []deb.Paragraph) (uncompressed, gzipped indexHashes, err error) {
-	buf := new(bytes.Buffer)
-	if err := deb.Save(buf, packages); err != nil {
-		return indexHashes{}, indexHashes{}, err
-	}
-	uncompressed, err = upload(ctx, bucket, key, bytes.NewReader(buf.Bytes()), uploadOptions{
-		contentType: "text/plain; charset=utf-8",
-	})
+// indexCompressions is the set of index compression extensions that aptblob
+// knows how to write. The empty string is the uncompressed index.
+var indexCompressions = []string{"", gzipExtension, xzExtension}
+
+// defaultIndexCompressions is the set of index variants written for an index
+// that is not yet listed in the Release file.
+var defaultIndexCompressions = []string{"", gzipExtension}
+
+// uploadIndex writes the paragraphs to the given key once for each of the
+// given compression extensions, returning the hashes of each variant.
+func uploadIndex(ctx context.Context, bucket *blob.Bucket, key string, packages []deb.Paragraph, compressions []string) (map[string]indexHashes, error) {
+	sortIndex(key, packages)
+	variants, err := encodeIndex(packages, compressions)
 	if err != nil {
-		return indexHashes{}, indexHashes{}, err
+		return nil, fmt.Errorf("%s: %w", key, err)
 	}
-	gzipBuf := new(bytes.Buffer)
-	zw := gzip.NewWriter(gzipBuf)
-	if _, err := io.Copy(zw, buf); err != nil {
-		return indexHashes{}, indexHashes{}, fmt.Errorf("compress %s: %w", key, err)
+	if plan := planFrom(ctx); plan != nil {
+		oldText, err := downloadIndexText(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		newText := new(strings.Builder)
+		if err := deb.Save(newText, packages); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		plan.diff(key, oldText, newText.String())
 	}
-	if err := zw.Close(); err != nil {
-		return indexHashes{}, indexHashes{}, fmt.Errorf("compress %s: %w", key, err)
+	hashes := make(map[string]indexHashes, len(variants))
+	for _, v := range variants {
+		h, err := upload(ctx, bucket, key+v.ext, bytes.NewReader(v.data), uploadOptions{
+			contentType: v.contentType,
+		})
+		if err != nil {
+			return nil, err
+		}
+		hashes[v.ext] = h
 	}
-	gzipped, err = upload(ctx, bucket, key+gzipExtension, bytes.NewReader(gzipBuf.Bytes()), uploadOptions{
-		contentType: "application/gzip",
+	return hashes, nil
+}
+
+// sortIndex puts the paragraphs of the Packages or Sources index at key in
+// canonical order, so that an index's content doesn't depend on the order in
+// which packages were uploaded. Paragraphs are sorted by package name, then
+// version, then architecture, and their fields are put in canonical order.
+// sortIndex modifies the slice and the paragraphs in-place.
+func sortIndex(key string, packages []deb.Paragraph) {
+	order := deb.PackagesFieldOrder
+	if slashpath.Base(key) == "Sources" {
+		order = deb.SourcesFieldOrder
+	}
+	for _, pkg := range packages {
+		deb.SortFields(pkg, order)
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		pi, pj := packages[i], packages[j]
+		if ni, nj := pi.Get("Package"), pj.Get("Package"); ni != nj {
+			return ni < nj
+		}
+		if c := deb.CompareVersions(pi.Get("Version"), pj.Get("Version")); c != 0 {
+			return c < 0
+		}
+		return pi.Get("Architecture") < pj.Get("Architecture")
 	})
-	if err != nil {
-		return indexHashes{}, indexHashes{}, err
+}
+
+// indexVariant is an index file encoded with a particular compression.
+type indexVariant struct {
+	ext         string
+	data        []byte
+	contentType string
+}
+
+// encodeIndex serializes the paragraphs once for each of the given compression
+// extensions.
+func encodeIndex(packages []deb.Paragraph, compressions []string) ([]indexVariant, error) {
+	buf := new(bytes.Buffer)
+	if err := deb.Save(buf, packages); err != nil {
+		return nil, err
+	}
+	variants := make([]indexVariant, 0, len(compressions))
+	for _, ext := range compressions {
+		data, contentType, err := compressIndex(buf.Bytes(), ext)
+		if err != nil {
+			return nil, fmt.Errorf("compress: %w", err)
+		}
+		variants = append(variants, indexVariant{
+			ext:         ext,
+			data:        data,
+			contentType: contentType,
+		})
+	}
+	return variants, nil
+}
+
+// compressIndex compresses an index with the algorithm named by the given
+// extension and returns the Content-Type of the result.
+func compressIndex(data []byte, ext string) ([]byte, string, error) {
+	switch ext {
+	case "":
+		return data, "text/plain; charset=utf-8", nil
+	case gzipExtension:
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, "", err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "application/gzip", nil
+	case xzExtension:
+		buf := new(bytes.Buffer)
+		xzw, err := xz.NewWriter(buf)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := xzw.Write(data); err != nil {
+			return nil, "", err
+		}
+		if err := xzw.Close(); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "application/x-xz", nil
+	default:
+		return nil, "", fmt.Errorf("unknown compression %q", ext)
+	}
+}
+
+// decompressIndex returns a reader of the uncompressed content of an index
+// compressed with the algorithm named by the given extension.
+func decompressIndex(r io.Reader, ext string) (io.ReadCloser, error) {
+	switch ext {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case gzipExtension:
+		return gzip.NewReader(r)
+	case xzExtension:
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xzr), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", ext)
 	}
-	return
 }
 
 func uploadBinaryPackage(ctx context.Context, bucket *blob.Bucket, debPath string) (deb.Paragraph, error) {
@@ -183,10 +326,9 @@ func uploadBinaryPackage(ctx context.Context, bucket *blob.Bucket, debPath strin
 	p := deb.NewParser(bytes.NewReader(control))
 	p.Fields = deb.ControlFields
 	if !p.Single() {
-		return nil, fmt.Errorf("upload binary package %s: control: %w", debName, p.Err())
+		return nil, fmt.Errorf("upload binary package %s: control: %w", debName, withCode(codeParseError, p.Err()))
 	}
 	pkg := p.Paragraph()
-	promotePackageField(pkg)
 	arch := pkg.Get("Architecture")
 	if arch == "" {
 		return nil, fmt.Errorf("upload binary package %s: missing Architecture field", debName)
@@ -203,19 +345,31 @@ func uploadBinaryPackage(ctx context.Context, bucket *blob.Bucket, debPath strin
 	pkg.Set("MD5sum", hex.EncodeToString(packageHashes.md5[:]))
 	pkg.Set("SHA1", hex.EncodeToString(packageHashes.sha1[:]))
 	pkg.Set("SHA256", hex.EncodeToString(packageHashes.sha256[:]))
+	deb.SortFields(pkg, deb.PackagesFieldOrder)
 	return pkg, nil
 }
 
-func uploadSourcePackage(ctx context.Context, bucket *blob.Bucket, dscPath string) (deb.Paragraph, error) {
+// uploadSourcePackage uploads the .dsc file at dscPath and the files it lists.
+// If keyringPath is not empty, then the .dsc file must be clear-signed by a key
+// in the keyring and the files must match the checksums it lists.
+func uploadSourcePackage(ctx context.Context, bucket *blob.Bucket, dscPath string, keyringPath string) (deb.Paragraph, error) {
 	packageName := strings.TrimSuffix(filepath.Base(dscPath), ".dsc")
 	dsc, err := ioutil.ReadFile(dscPath)
 	if err != nil {
 		return nil, fmt.Errorf("upload source package %s: %w", packageName, err)
 	}
-	p := deb.NewParser(bytes.NewReader(maybeClearSigned(dsc)))
+	control := maybeClearSigned(dsc)
+	signer := ""
+	if keyringPath != "" {
+		control, signer, err = deb.VerifyClearSigned(ctx, dsc, keyringPath)
+		if err != nil {
+			return nil, fmt.Errorf("upload source package %s: %w", packageName, withCode(codeSignatureRequired, err))
+		}
+	}
+	p := deb.NewParser(bytes.NewReader(control))
 	p.Fields = deb.SourceControlFields
 	if !p.Single() {
-		return nil, fmt.Errorf("upload source package %s: %w", packageName, p.Err())
+		return nil, fmt.Errorf("upload source package %s: %w", packageName, withCode(codeParseError, p.Err()))
 	}
 	pkg := p.Paragraph()
 	dir := poolPath(packageName)
@@ -224,10 +378,17 @@ func uploadSourcePackage(ctx context.Context, bucket *blob.Bucket, dscPath strin
 	if err != nil {
 		return nil, fmt.Errorf("upload source package %s: files: %w", packageName, err)
 	}
+	if keyringPath != "" {
+		// The signature only covers the other files through their checksums.
+		if err := checkSourceFiles(filepath.Dir(dscPath), pkg); err != nil {
+			return nil, fmt.Errorf("upload source package %s: %w", packageName, withCode(codeSignatureRequired, err))
+		}
+	}
 
 	_, err = upload(ctx, bucket, dir+"/"+filepath.Base(dscPath), bytes.NewReader(dsc), uploadOptions{
 		contentType:  "text/plain; charset=utf-8",
 		cacheControl: immutable,
+		signer:       signer,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("upload source package %s: %s: %w", packageName, filepath.Base(dscPath), err)
@@ -254,6 +415,39 @@ func uploadSourcePackage(ctx context.Context, bucket *blob.Bucket, dscPath strin
 	return pkg, nil
 }
 
+// checkSourceFiles verifies that the files listed in a source control
+// paragraph are present in dir and match the listed sizes and checksums.
+func checkSourceFiles(dir string, pkg deb.Paragraph) error {
+	files, err := deb.ParseIndexSignatures(pkg.Get("Files"), md5.Size)
+	if err != nil {
+		return fmt.Errorf("files: %w", err)
+	}
+	sha256Sums, err := deb.ParseIndexSignatures(pkg.Get("Checksums-Sha256"), sha256.Size)
+	if err != nil {
+		return fmt.Errorf("checksums-sha256: %w", err)
+	}
+	for _, sig := range files {
+		f, err := os.Open(filepath.Join(dir, sig.Filename))
+		if err != nil {
+			return err
+		}
+		h, err := hashContent(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", sig.Filename, err)
+		}
+		if h.size != sig.Size || !bytes.Equal(h.md5[:], sig.Checksum) {
+			return fmt.Errorf("%s: does not match signed checksum", sig.Filename)
+		}
+		for _, sum := range sha256Sums {
+			if sum.Filename == sig.Filename && !bytes.Equal(h.sha256[:], sum.Checksum) {
+				return fmt.Errorf("%s: does not match signed checksum", sig.Filename)
+			}
+		}
+	}
+	return nil
+}
+
 // maybeClearSigned returns the plaintext of a file that may or may not be
 // wrapped in GPG clear-signed armor.
 func maybeClearSigned(data []byte) []byte {
@@ -264,20 +458,6 @@ func maybeClearSigned(data []byte) []byte {
 	return block.Plaintext
 }
 
-// promotePackageField ensures the Package field is the first in the paragraph.
-// It modifies the paragraph in-place.
-//
-// This is necessary for Packages and Sources paragraphs to be spec-compliant.
-func promotePackageField(para deb.Paragraph) {
-	for i, f := range para {
-		if f.Name == "Package" {
-			copy(para[1:], para[:i])
-			para[0] = f
-			return
-		}
-	}
-}
-
 // transformSourceControl changes a Debian source control paragraph to a Sources
 // index paragraph.
 func transformSourceControl(para *deb.Paragraph, dir string) {
@@ -286,8 +466,8 @@ func transformSourceControl(para *deb.Paragraph, dir string) {
 			(*para)[i].Name = "Package"
 		}
 	}
-	promotePackageField(*para)
 	para.Set("Directory", dir)
+	deb.SortFields(*para, deb.SourcesFieldOrder)
 }
 
 func poolPath(name string) string {
@@ -297,19 +477,49 @@ func poolPath(name string) string {
 // immutable is the Cache-Control header that indicates that the content is immutable.
 const immutable = "immutable"
 
+// defaultCacheControl is the Cache-Control header for mutable objects:
+// a 5 minute cache.
+const defaultCacheControl = "max-age=300"
+
+// hashContent computes the size and checksums of the content read from r.
+func hashContent(r io.Reader) (indexHashes, error) {
+	md5Hash := md5.New()
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), r)
+	if err != nil {
+		return indexHashes{}, err
+	}
+	var h indexHashes
+	h.size = size
+	md5Hash.Sum(h.md5[:0])
+	sha1Hash.Sum(h.sha1[:0])
+	sha256Hash.Sum(h.sha256[:0])
+	return h, nil
+}
+
 type uploadOptions struct {
 	contentType  string
 	cacheControl string
+	// signer is the fingerprint of the key that signed the content, if any.
+	// It is only used for reporting.
+	signer string
+	// report is whether to add a mutable object to the report. Pool objects
+	// are always reported, and indexes are reported through their Release
+	// file.
+	report bool
+}
+
+// reported reports whether the object is added to the report.
+func (opts uploadOptions) reported() bool {
+	return opts.cacheControl == immutable || opts.report
 }
 
 func upload(ctx context.Context, bucket *blob.Bucket, key string, content io.ReadSeeker, opts uploadOptions) (indexHashes, error) {
 	if _, err := content.Seek(0, io.SeekStart); err != nil {
 		return indexHashes{}, fmt.Errorf("upload %s: %w", key, err)
 	}
-	md5Hash := md5.New()
-	sha1Hash := sha1.New()
-	sha256Hash := sha256.New()
-	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), content)
+	h, err := hashContent(content)
 	if err != nil {
 		return indexHashes{}, fmt.Errorf("upload %s: %w", key, err)
 	}
@@ -317,27 +527,23 @@ func upload(ctx context.Context, bucket *blob.Bucket, key string, content io.Rea
 		return indexHashes{}, fmt.Errorf("upload %s: %w", key, err)
 	}
 
-	var h indexHashes
-	h.size = size
-	md5Hash.Sum(h.md5[:0])
-	sha1Hash.Sum(h.sha1[:0])
-	sha256Hash.Sum(h.sha256[:0])
 	if opts.cacheControl == immutable {
-		attr, err := bucket.Attributes(ctx, key)
-		if err == nil {
-			// Immutable objects don't have to be uploaded if they already exist,
-			// but they must match the existing object.
-			if attr.Size != h.size || !bytes.Equal(h.md5[:], attr.MD5) {
-				return indexHashes{}, fmt.Errorf("upload %s: immutable object differs", key)
-			}
-			return h, nil
-		} else if gcerrors.Code(err) != gcerrors.NotFound {
+		if exists, err := immutableObjectExists(ctx, bucket, key, h.size, h.md5[:]); err != nil {
 			return indexHashes{}, fmt.Errorf("upload %s: %w", key, err)
+		} else if exists {
+			reportFrom(ctx).addObject(key, h, opts.signer, true)
+			return h, nil
 		}
 	}
+	if plan := planFrom(ctx); plan != nil {
+		if opts.reported() {
+			plan.upload(key, h)
+			reportFrom(ctx).addObject(key, h, opts.signer, false)
+		}
+		return h, nil
+	}
 	if opts.cacheControl == "" {
-		// Default to 5 minute cache.
-		opts.cacheControl = "max-age=300"
+		opts.cacheControl = defaultCacheControl
 	}
 	w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{
 		ContentType:  opts.contentType,
@@ -355,5 +561,107 @@ func upload(ctx context.Context, bucket *blob.Bucket, key string, content io.Rea
 	if closeErr != nil {
 		return indexHashes{}, fmt.Errorf("upload %s: %w", key, closeErr)
 	}
+	if opts.reported() {
+		reportFrom(ctx).addObject(key, h, opts.signer, false)
+	}
 	return h, nil
 }
+
+// copyObject copies the object at srcKey to dstKey in the same bucket and
+// gives the copy the given Cache-Control. Immutable copies that already exist
+// are not copied again.
+func copyObject(ctx context.Context, bucket *blob.Bucket, dstKey, srcKey string, cacheControl string) error {
+	attr, err := bucket.Attributes(ctx, srcKey)
+	if err != nil {
+		return fmt.Errorf("copy %s: %w", srcKey, err)
+	}
+	if cacheControl == immutable {
+		if exists, err := immutableObjectExists(ctx, bucket, dstKey, attr.Size, attr.MD5); err != nil {
+			return fmt.Errorf("copy %s to %s: %w", srcKey, dstKey, err)
+		} else if exists {
+			reportFrom(ctx).addCopy(srcKey, dstKey, attr.Size, true)
+			return nil
+		}
+	}
+	if plan := planFrom(ctx); plan != nil {
+		plan.copy(srcKey, dstKey, attr.Size)
+		reportFrom(ctx).addCopy(srcKey, dstKey, attr.Size, false)
+		return nil
+	}
+	if attr.CacheControl == cacheControl {
+		if err := bucket.Copy(ctx, dstKey, srcKey, nil); err != nil {
+			return fmt.Errorf("copy %s to %s: %w", srcKey, dstKey, err)
+		}
+		reportFrom(ctx).addCopy(srcKey, dstKey, attr.Size, false)
+		return nil
+	}
+
+	// Copy doesn't take new attributes, so rewrite the object instead.
+	r, err := bucket.NewReader(ctx, srcKey, nil)
+	if err != nil {
+		return fmt.Errorf("copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	defer r.Close()
+	w, err := bucket.NewWriter(ctx, dstKey, &blob.WriterOptions{
+		ContentType:        attr.ContentType,
+		CacheControl:       cacheControl,
+		ContentDisposition: attr.ContentDisposition,
+		ContentEncoding:    attr.ContentEncoding,
+		ContentLanguage:    attr.ContentLanguage,
+		ContentMD5:         attr.MD5,
+	})
+	if err != nil {
+		return fmt.Errorf("copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	_, writeErr := io.Copy(w, r)
+	closeErr := w.Close()
+	if writeErr != nil {
+		return fmt.Errorf("copy %s to %s: %w", srcKey, dstKey, writeErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("copy %s to %s: %w", srcKey, dstKey, closeErr)
+	}
+	reportFrom(ctx).addCopy(srcKey, dstKey, attr.Size, false)
+	return nil
+}
+
+// deleteObject deletes the object at key. It is not an error if the object
+// does not exist.
+func deleteObject(ctx context.Context, bucket *blob.Bucket, key string) error {
+	if plan := planFrom(ctx); plan != nil {
+		if exists, err := bucket.Exists(ctx, key); err != nil {
+			return fmt.Errorf("delete %s: %w", key, err)
+		} else if exists {
+			plan.delete(key)
+			reportFrom(ctx).addDelete(key)
+		}
+		return nil
+	}
+	err := bucket.Delete(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	reportFrom(ctx).addDelete(key)
+	return nil
+}
+
+// immutableObjectExists reports whether an object exists at key.
+// Immutable objects don't have to be written if they already exist,
+// but they must match the existing object, so immutableObjectExists returns an
+// error if the existing object's size or MD5 hash differ from the given ones.
+func immutableObjectExists(ctx context.Context, bucket *blob.Bucket, key string, size int64, md5Hash []byte) (bool, error) {
+	attr, err := bucket.Attributes(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if attr.Size != size || !bytes.Equal(md5Hash, attr.MD5) {
+		return false, withCode(codeImmutableConflict, errors.New("immutable object differs"))
+	}
+	return true, nil
+}